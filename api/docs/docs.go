@@ -66,6 +66,107 @@ const docTemplate = `{
             "description": "Text message to hide",
             "required": true,
             "type": "string"
+          },
+          {
+            "name": "mode",
+            "in": "formData",
+            "description": "Embedding mode: lsb (default), matrix (Hamming matrix embedding, fewer pixel changes) or adaptive (textured regions only)",
+            "required": false,
+            "type": "string",
+            "enum": ["lsb", "matrix", "adaptive"]
+          },
+          {
+            "name": "maxDensity",
+            "in": "formData",
+            "description": "Adaptive mode: maximum share of textured slots carrying payload bits (default 0.5)",
+            "required": false,
+            "type": "number"
+          },
+          {
+            "name": "preserveHistogram",
+            "in": "formData",
+            "description": "Flip unused LSBs after embedding to restore the cover's first-order histogram",
+            "required": false,
+            "type": "boolean"
+          },
+          {
+            "name": "padding",
+            "in": "formData",
+            "description": "Pad the encrypted payload so its length is hidden: none (default), bucket or full",
+            "required": false,
+            "type": "string",
+            "enum": ["none", "bucket", "full"]
+          },
+          {
+            "name": "randomizeUnused",
+            "in": "formData",
+            "description": "Fill LSBs outside the payload with random bits",
+            "required": false,
+            "type": "boolean"
+          },
+          {
+            "name": "copies",
+            "in": "formData",
+            "description": "Redundant scattered copies for damage tolerance (lsb mode): a number from 1 to 255 or auto",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "passphrase",
+            "in": "formData",
+            "description": "Derive the encryption key from this passphrase with Argon2id instead of generating a random key",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "recipient",
+            "in": "formData",
+            "description": "Encrypt to this X25519 public key (stegpub:...) instead of returning a key. Repeat the field to let several recipients extract; a passphrase may be added as well",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "master",
+            "in": "formData",
+            "description": "Team master key (hex, Base58Check or recovery words). The image key is derived from it for a random image ID instead of returning a key, so the master opens every team image",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "signingKey",
+            "in": "formData",
+            "description": "Ed25519 signing key (stegsignsec:...) to sign the payload and its metadata",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "cipher",
+            "in": "formData",
+            "description": "Payload cipher (default aes-256-gcm)",
+            "required": false,
+            "type": "string",
+            "enum": ["aes-256-gcm", "chacha20-poly1305", "xchacha20-poly1305"]
+          },
+          {
+            "name": "author",
+            "in": "formData",
+            "description": "Author label sealed with the content and returned on extract",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "comment",
+            "in": "formData",
+            "description": "Comment sealed with the content and returned on extract",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "expires",
+            "in": "formData",
+            "description": "RFC 3339 time after which extract refuses the content unless forced",
+            "required": false,
+            "type": "string"
           }
         ],
         "responses": {
@@ -92,14 +193,1505 @@ const docTemplate = `{
                     "outputFileURL": {
                       "type": "string",
                       "example": "/api/files/stego_abc123.png"
+                    },
+                    "cipher": {
+                      "type": "string",
+                      "example": "aes-256-gcm"
+                    },
+                    "keyDerivation": {
+                      "type": "string",
+                      "description": "argon2id for a passphrase, x25519 for a recipient public key or hkdf for a team master key; key is then omitted",
+                      "example": "argon2id"
+                    },
+                    "embedding": {
+                      "type": "object",
+                      "properties": {
+                        "mode": {
+                          "type": "string",
+                          "example": "matrix"
+                        },
+                        "codeSize": {
+                          "type": "integer",
+                          "example": 8
+                        },
+                        "threshold": {
+                          "type": "integer",
+                          "example": 0
+                        },
+                        "payloadBits": {
+                          "type": "integer",
+                          "example": 5032
+                        },
+                        "copies": {
+                          "type": "integer",
+                          "example": 0
+                        },
+                        "changes": {
+                          "type": "integer",
+                          "example": 650
+                        },
+                        "correctionChanges": {
+                          "type": "integer",
+                          "example": 640
+                        },
+                        "randomFillChanges": {
+                          "type": "integer",
+                          "example": 0
+                        },
+                        "padding": {
+                          "type": "string",
+                          "example": "none"
+                        },
+                        "histogramDeviation": {
+                          "type": "number",
+                          "format": "float",
+                          "example": 0.00002
+                        }
+                      }
                     }
                   }
                 }
               }
             }
           },
-          "400": {
-            "description": "Invalid request",
+          "400": {
+            "description": "Invalid request",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "error": {
+                  "type": "string",
+                  "example": "No image file uploaded"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "Server error",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "error": {
+                  "type": "string",
+                  "example": "Failed to hide message"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/hideFile": {
+      "post": {
+        "consumes": ["multipart/form-data"],
+        "produces": ["application/json"],
+        "summary": "Hide a file in an image",
+        "description": "Encrypts and hides any file inside an image using steganography",
+        "parameters": [
+          {
+            "name": "image",
+            "in": "formData",
+            "description": "Cover image file (PNG or JPG)",
+            "required": true,
+            "type": "file"
+          },
+          {
+            "name": "file",
+            "in": "formData",
+            "description": "File to hide (PDF, TXT, etc.)",
+            "required": true,
+            "type": "file"
+          },
+          {
+            "name": "mode",
+            "in": "formData",
+            "description": "Embedding mode: lsb (default), matrix (Hamming matrix embedding, fewer pixel changes) or adaptive (textured regions only)",
+            "required": false,
+            "type": "string",
+            "enum": ["lsb", "matrix", "adaptive"]
+          },
+          {
+            "name": "maxDensity",
+            "in": "formData",
+            "description": "Adaptive mode: maximum share of textured slots carrying payload bits (default 0.5)",
+            "required": false,
+            "type": "number"
+          },
+          {
+            "name": "preserveHistogram",
+            "in": "formData",
+            "description": "Flip unused LSBs after embedding to restore the cover's first-order histogram",
+            "required": false,
+            "type": "boolean"
+          },
+          {
+            "name": "padding",
+            "in": "formData",
+            "description": "Pad the encrypted payload so its length is hidden: none (default), bucket or full",
+            "required": false,
+            "type": "string",
+            "enum": ["none", "bucket", "full"]
+          },
+          {
+            "name": "randomizeUnused",
+            "in": "formData",
+            "description": "Fill LSBs outside the payload with random bits",
+            "required": false,
+            "type": "boolean"
+          },
+          {
+            "name": "copies",
+            "in": "formData",
+            "description": "Redundant scattered copies for damage tolerance (lsb mode): a number from 1 to 255 or auto",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "passphrase",
+            "in": "formData",
+            "description": "Derive the encryption key from this passphrase with Argon2id instead of generating a random key",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "recipient",
+            "in": "formData",
+            "description": "Encrypt to this X25519 public key (stegpub:...) instead of returning a key. Repeat the field to let several recipients extract; a passphrase may be added as well",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "master",
+            "in": "formData",
+            "description": "Team master key (hex, Base58Check or recovery words). The image key is derived from it for a random image ID instead of returning a key, so the master opens every team image",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "signingKey",
+            "in": "formData",
+            "description": "Ed25519 signing key (stegsignsec:...) to sign the payload and its metadata",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "cipher",
+            "in": "formData",
+            "description": "Payload cipher (default aes-256-gcm)",
+            "required": false,
+            "type": "string",
+            "enum": ["aes-256-gcm", "chacha20-poly1305", "xchacha20-poly1305"]
+          },
+          {
+            "name": "author",
+            "in": "formData",
+            "description": "Author label sealed with the content and returned on extract",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "comment",
+            "in": "formData",
+            "description": "Comment sealed with the content and returned on extract",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "expires",
+            "in": "formData",
+            "description": "RFC 3339 time after which extract refuses the content unless forced",
+            "required": false,
+            "type": "string"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "File hidden successfully",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": true
+                },
+                "message": {
+                  "type": "string",
+                  "example": "File hidden successfully"
+                },
+                "data": {
+                  "type": "object",
+                  "properties": {
+                    "key": {
+                      "type": "string",
+                      "example": "5a7b8c9d5a7b8c9d5a7b8c9d5a7b8c9d5a7b8c9d5a7b8c9d5a7b8c9d5a7b8c9d"
+                    },
+                    "outputFileURL": {
+                      "type": "string",
+                      "example": "/api/files/stego_abc123.png"
+                    },
+                    "cipher": {
+                      "type": "string",
+                      "example": "aes-256-gcm"
+                    },
+                    "keyDerivation": {
+                      "type": "string",
+                      "description": "argon2id for a passphrase, x25519 for a recipient public key or hkdf for a team master key; key is then omitted",
+                      "example": "argon2id"
+                    },
+                    "embedding": {
+                      "type": "object",
+                      "properties": {
+                        "mode": {
+                          "type": "string",
+                          "example": "matrix"
+                        },
+                        "codeSize": {
+                          "type": "integer",
+                          "example": 8
+                        },
+                        "threshold": {
+                          "type": "integer",
+                          "example": 0
+                        },
+                        "payloadBits": {
+                          "type": "integer",
+                          "example": 5032
+                        },
+                        "copies": {
+                          "type": "integer",
+                          "example": 0
+                        },
+                        "changes": {
+                          "type": "integer",
+                          "example": 650
+                        },
+                        "correctionChanges": {
+                          "type": "integer",
+                          "example": 640
+                        },
+                        "randomFillChanges": {
+                          "type": "integer",
+                          "example": 0
+                        },
+                        "padding": {
+                          "type": "string",
+                          "example": "none"
+                        },
+                        "histogramDeviation": {
+                          "type": "number",
+                          "format": "float",
+                          "example": 0.00002
+                        }
+                      }
+                    },
+                    "fileDetails": {
+                      "type": "object",
+                      "properties": {
+                        "originalName": {
+                          "type": "string",
+                          "example": "document.pdf"
+                        },
+                        "fileType": {
+                          "type": "string",
+                          "example": ".pdf"
+                        },
+                        "fileSize": {
+                          "type": "integer",
+                          "example": 12345
+                        }
+                      }
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "Invalid request",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "error": {
+                  "type": "string",
+                  "example": "No file to hide uploaded"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "Server error",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "error": {
+                  "type": "string",
+                  "example": "Failed to hide file"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/extract": {
+      "post": {
+        "consumes": ["multipart/form-data"],
+        "produces": ["application/json"],
+        "summary": "Extract hidden content",
+        "description": "Extracts and decrypts hidden content from a steganographic image",
+        "parameters": [
+          {
+            "name": "image",
+            "in": "formData",
+            "description": "Image containing hidden data",
+            "required": true,
+            "type": "file"
+          },
+          {
+            "name": "key",
+            "in": "formData",
+            "description": "Decryption key as 64 hexadecimal characters, Base58Check or 24 recovery words, required unless passphrase or identity is given",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "share",
+            "in": "formData",
+            "description": "Key share (hex or 27 words) from stego split or hide --shares, instead of key. Repeat the field for as many shares as the threshold",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "master",
+            "in": "formData",
+            "description": "Team master key, for content hidden with one (instead of key)",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "passphrase",
+            "in": "formData",
+            "description": "Passphrase used when hiding (instead of key)",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "force",
+            "in": "formData",
+            "description": "Extract the content even if it has expired",
+            "required": false,
+            "type": "boolean"
+          },
+          {
+            "name": "identity",
+            "in": "formData",
+            "description": "X25519 private key (stegsec:...) for content hidden to a recipient",
+            "required": false,
+            "type": "string"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Content extracted successfully",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": true
+                },
+                "message": {
+                  "type": "string",
+                  "example": "Content extracted successfully"
+                },
+                "data": {
+                  "type": "object",
+                  "properties": {
+                    "isFile": {
+                      "type": "boolean"
+                    },
+                    "message": {
+                      "type": "string",
+                      "example": "This is a secret message."
+                    },
+                    "fileURL": {
+                      "type": "string",
+                      "example": "/api/files/document.pdf"
+                    },
+                    "fileName": {
+                      "type": "string",
+                      "example": "document.pdf"
+                    },
+                    "fileType": {
+                      "type": "string",
+                      "example": ".pdf"
+                    },
+                    "fileSize": {
+                      "type": "integer",
+                      "example": 12345
+                    },
+                    "copies": {
+                      "type": "integer",
+                      "example": 5
+                    },
+                    "recoveredBy": {
+                      "type": "string",
+                      "example": "majority vote"
+                    },
+                    "cipher": {
+                      "type": "string",
+                      "example": "aes-256-gcm"
+                    },
+                    "signature": {
+                      "type": "object",
+                      "description": "Sender signature checked against the server's trusted signers list (STEG_TRUSTED_SIGNERS)",
+                      "properties": {
+                        "status": {
+                          "type": "string",
+                          "enum": ["unsigned", "verified", "unverified", "invalid"],
+                          "example": "verified"
+                        },
+                        "signer": {
+                          "type": "string",
+                          "example": "alice"
+                        },
+                        "keyId": {
+                          "type": "string",
+                          "example": "3f2a9c01d4e5b678"
+                        }
+                      }
+                    },
+                    "contentType": {
+                      "type": "string",
+                      "example": "application/pdf"
+                    },
+                    "info": {
+                      "type": "object",
+                      "description": "Info sealed with the content when it was hidden, absent for older content",
+                      "properties": {
+                        "created": {
+                          "type": "string",
+                          "example": "2026-10-18T16:20:31Z"
+                        },
+                        "author": {
+                          "type": "string",
+                          "example": "alice"
+                        },
+                        "comment": {
+                          "type": "string",
+                          "example": "quarterly figures"
+                        },
+                        "expires": {
+                          "type": "string",
+                          "example": "2026-12-31T00:00:00Z"
+                        }
+                      }
+                    },
+                    "thread": {
+                      "type": "array",
+                      "description": "For a thread, its messages oldest first; messages sealed with other credentials only have seq and sealed",
+                      "items": {
+                        "type": "object",
+                        "properties": {
+                          "seq": {
+                            "type": "integer",
+                            "example": 1
+                          },
+                          "created": {
+                            "type": "string",
+                            "example": "2026-10-18T16:41:08Z"
+                          },
+                          "message": {
+                            "type": "string",
+                            "example": "Meet at noon"
+                          },
+                          "sealed": {
+                            "type": "boolean"
+                          },
+                          "signature": {
+                            "type": "object"
+                          }
+                        }
+                      }
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "Invalid request",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "error": {
+                  "type": "string",
+                  "example": "Invalid key format"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "The key, passphrase or private key does not open the content (code WRONG_KEY)",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "error": {
+                  "type": "string",
+                  "example": "Failed to decrypt data: wrong encryption key"
+                },
+                "code": {
+                  "type": "string",
+                  "example": "WRONG_KEY"
+                }
+              }
+            }
+          },
+          "410": {
+            "description": "The content has expired; send force=true to open it anyway (code EXPIRED)",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "error": {
+                  "type": "string",
+                  "example": "Content expired on 2026-10-18T16:19:53Z"
+                },
+                "code": {
+                  "type": "string",
+                  "example": "EXPIRED"
+                }
+              }
+            }
+          },
+          "422": {
+            "description": "No hidden content in the image (code NO_PAYLOAD) or the hidden content is damaged (code CORRUPT_PAYLOAD)",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "error": {
+                  "type": "string",
+                  "example": "Failed to decrypt data: hidden data is damaged: segment 0 failed authentication"
+                },
+                "code": {
+                  "type": "string",
+                  "example": "CORRUPT_PAYLOAD"
+                },
+                "failedSegment": {
+                  "type": "integer",
+                  "description": "Index of the first payload segment that failed authentication, when known",
+                  "example": 0
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "Server error",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "error": {
+                  "type": "string",
+                  "example": "Failed to extract content"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/rekey": {
+      "post": {
+        "consumes": ["multipart/form-data"],
+        "produces": ["application/json"],
+        "summary": "Re-encrypt hidden content under a new key",
+        "description": "Decrypts the payload of a stego image with the old credentials, encrypts it under the new ones and embeds it again over the same image with its existing mode, copies, padding, density cap, random fill and histogram correction, scrubbing whatever of the old payload the new one does not overwrite; the original cover is not needed",
+        "parameters": [
+          {
+            "name": "image",
+            "in": "formData",
+            "description": "Stego image whose payload is re-encrypted",
+            "required": true,
+            "type": "file"
+          },
+          {
+            "name": "oldKey",
+            "in": "formData",
+            "description": "Current key (hex, Base58Check or recovery words), required unless another old credential is given",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "oldPassphrase",
+            "in": "formData",
+            "description": "Current passphrase (instead of oldKey)",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "oldIdentity",
+            "in": "formData",
+            "description": "X25519 private key (stegsec:...) the content is currently encrypted to (instead of oldKey)",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "oldMaster",
+            "in": "formData",
+            "description": "Team master key the content is currently sealed under (instead of oldKey)",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "passphrase",
+            "in": "formData",
+            "description": "New passphrase; with no new credential a random key is generated and returned",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "recipient",
+            "in": "formData",
+            "description": "New X25519 public key (stegpub:...) to encrypt to. Repeat the field for several recipients",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "master",
+            "in": "formData",
+            "description": "New team master key to derive the image key from",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "signingKey",
+            "in": "formData",
+            "description": "Ed25519 signing key (stegsignsec:...) to sign the new payload; an old signature is otherwise dropped",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "cipher",
+            "in": "formData",
+            "description": "Payload cipher (default aes-256-gcm)",
+            "required": false,
+            "type": "string",
+            "enum": ["aes-256-gcm", "chacha20-poly1305", "xchacha20-poly1305"]
+          },
+          {
+            "name": "author",
+            "in": "formData",
+            "description": "New author label; the old one is kept if omitted",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "comment",
+            "in": "formData",
+            "description": "New comment; the old one is kept if omitted",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "expires",
+            "in": "formData",
+            "description": "New RFC 3339 expiry; the old one is kept if omitted",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "force",
+            "in": "formData",
+            "description": "Rekey the content even if it has expired",
+            "required": false,
+            "type": "boolean"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Payload re-encrypted successfully",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": true
+                },
+                "message": {
+                  "type": "string",
+                  "example": "Payload re-encrypted successfully"
+                },
+                "data": {
+                  "type": "object",
+                  "properties": {
+                    "key": {
+                      "type": "string",
+                      "example": "5a7b8c9d5a7b8c9d5a7b8c9d5a7b8c9d5a7b8c9d5a7b8c9d5a7b8c9d5a7b8c9d"
+                    },
+                    "outputFileURL": {
+                      "type": "string",
+                      "example": "/api/files/stego_abc123.png"
+                    },
+                    "cipher": {
+                      "type": "string",
+                      "example": "aes-256-gcm"
+                    },
+                    "keyDerivation": {
+                      "type": "string",
+                      "example": "x25519"
+                    },
+                    "isFile": {
+                      "type": "boolean"
+                    },
+                    "isVault": {
+                      "type": "boolean",
+                      "description": "The payload is a vault"
+                    },
+                    "signatureDropped": {
+                      "type": "boolean",
+                      "description": "The image was signed and no signingKey was given to sign the new payload"
+                    },
+                    "embedding": {
+                      "type": "object"
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "Invalid request or old key",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "error": {
+                  "type": "string",
+                  "example": "Invalid old key: encryption key cannot be empty"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "The key, passphrase or private key does not open the content (code WRONG_KEY)",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "error": {
+                  "type": "string",
+                  "example": "Failed to decrypt data: wrong encryption key"
+                },
+                "code": {
+                  "type": "string",
+                  "example": "WRONG_KEY"
+                }
+              }
+            }
+          },
+          "410": {
+            "description": "The content has expired; send force=true to open it anyway (code EXPIRED)",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "error": {
+                  "type": "string",
+                  "example": "Content expired on 2026-10-18T16:19:53Z"
+                },
+                "code": {
+                  "type": "string",
+                  "example": "EXPIRED"
+                }
+              }
+            }
+          },
+          "422": {
+            "description": "No hidden content in the image (code NO_PAYLOAD) or the hidden content is damaged (code CORRUPT_PAYLOAD)",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "error": {
+                  "type": "string",
+                  "example": "Failed to decrypt data: hidden data is damaged: segment 0 failed authentication"
+                },
+                "code": {
+                  "type": "string",
+                  "example": "CORRUPT_PAYLOAD"
+                },
+                "failedSegment": {
+                  "type": "integer",
+                  "description": "Index of the first payload segment that failed authentication, when known",
+                  "example": 0
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "Server error",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "error": {
+                  "type": "string",
+                  "example": "Failed to embed payload"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/post": {
+      "post": {
+        "consumes": ["multipart/form-data"],
+        "produces": ["application/json"],
+        "summary": "Append a message to a thread",
+        "description": "Appends a message, encrypted on its own, to the thread in a stego image, or starts a thread in a cover image. Earlier messages are kept as they are; an existing thread keeps its embedding",
+        "parameters": [
+          {
+            "name": "image",
+            "in": "formData",
+            "description": "Thread image, or a cover image to start a thread",
+            "required": true,
+            "type": "file"
+          },
+          {
+            "name": "message",
+            "in": "formData",
+            "description": "Message to append",
+            "required": true,
+            "type": "string"
+          },
+          {
+            "name": "key",
+            "in": "formData",
+            "description": "Key the thread is sealed with (hex, Base58Check or recovery words)",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "passphrase",
+            "in": "formData",
+            "description": "Passphrase to seal the message with",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "recipient",
+            "in": "formData",
+            "description": "X25519 public key (stegpub:...) to seal the message for. Repeat the field for several recipients",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "master",
+            "in": "formData",
+            "description": "Team master key to derive the message key from",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "signingKey",
+            "in": "formData",
+            "description": "Ed25519 signing key (stegsignsec:...); the message records its key ID and signature",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "mode",
+            "in": "formData",
+            "description": "New thread: embedding mode (default lsb)",
+            "required": false,
+            "type": "string",
+            "enum": ["lsb", "matrix", "adaptive"]
+          },
+          {
+            "name": "copies",
+            "in": "formData",
+            "description": "New thread: number of redundant copies, or auto",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "cipher",
+            "in": "formData",
+            "description": "Payload cipher (default aes-256-gcm)",
+            "required": false,
+            "type": "string",
+            "enum": ["aes-256-gcm", "chacha20-poly1305", "xchacha20-poly1305"]
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Message posted successfully",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": true
+                },
+                "message": {
+                  "type": "string",
+                  "example": "Message posted successfully"
+                },
+                "data": {
+                  "type": "object",
+                  "properties": {
+                    "key": {
+                      "type": "string",
+                      "description": "Generated key, only when a new thread is started without credentials",
+                      "example": "5a7b8c9d5a7b8c9d5a7b8c9d5a7b8c9d5a7b8c9d5a7b8c9d5a7b8c9d5a7b8c9d"
+                    },
+                    "outputFileURL": {
+                      "type": "string",
+                      "example": "/api/files/stego_abc123.png"
+                    },
+                    "seq": {
+                      "type": "integer",
+                      "example": 2
+                    },
+                    "messages": {
+                      "type": "integer",
+                      "example": 2
+                    },
+                    "author": {
+                      "type": "string",
+                      "description": "Signing key ID of the message's author",
+                      "example": "e2626739c35fb54b"
+                    },
+                    "cipher": {
+                      "type": "string",
+                      "example": "aes-256-gcm"
+                    },
+                    "keyDerivation": {
+                      "type": "string",
+                      "example": "argon2id"
+                    },
+                    "embedding": {
+                      "type": "object"
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "Invalid request, or no room for another message in the image",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "error": {
+                  "type": "string",
+                  "example": "posting to a thread needs the key, passphrase, master key or recipient it is sealed with"
+                }
+              }
+            }
+          },
+          "422": {
+            "description": "The image holds hidden content that is not a thread (code NOT_THREAD)",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "error": {
+                  "type": "string",
+                  "example": "Failed to read thread: the image holds hidden content, but not a thread"
+                },
+                "code": {
+                  "type": "string",
+                  "example": "NOT_THREAD"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "Server error",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "error": {
+                  "type": "string",
+                  "example": "Failed to initialize decoder"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/vault/init": {
+      "post": {
+        "consumes": ["multipart/form-data"],
+        "produces": ["application/json"],
+        "summary": "Create an encrypted secrets vault",
+        "description": "Embeds an empty vault in the cover image. It takes the embedding and key options of /hide except recipient, signingKey and expires, which a vault could not keep when it is rewritten",
+        "parameters": [
+          {
+            "name": "image",
+            "in": "formData",
+            "description": "Cover image to hold the vault",
+            "required": true,
+            "type": "file"
+          },
+          {
+            "name": "passphrase",
+            "in": "formData",
+            "description": "Passphrase to seal the vault with; with no passphrase or master a random key is generated and returned",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "master",
+            "in": "formData",
+            "description": "Team master key to derive the vault key from",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "mode",
+            "in": "formData",
+            "description": "Embedding mode (default lsb)",
+            "required": false,
+            "type": "string",
+            "enum": ["lsb", "matrix", "adaptive"]
+          },
+          {
+            "name": "padding",
+            "in": "formData",
+            "description": "Padding mode, kept across changes (default none)",
+            "required": false,
+            "type": "string",
+            "enum": ["none", "bucket", "full"]
+          },
+          {
+            "name": "copies",
+            "in": "formData",
+            "description": "Number of redundant copies, or auto",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "cipher",
+            "in": "formData",
+            "description": "Payload cipher (default aes-256-gcm)",
+            "required": false,
+            "type": "string",
+            "enum": ["aes-256-gcm", "chacha20-poly1305", "xchacha20-poly1305"]
+          },
+          {
+            "name": "author",
+            "in": "formData",
+            "description": "Author label sealed with the vault",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "comment",
+            "in": "formData",
+            "description": "Comment sealed with the vault",
+            "required": false,
+            "type": "string"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Vault created successfully",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": true
+                },
+                "message": {
+                  "type": "string",
+                  "example": "Vault created successfully"
+                },
+                "data": {
+                  "type": "object",
+                  "properties": {
+                    "key": {
+                      "type": "string",
+                      "example": "5a7b8c9d5a7b8c9d5a7b8c9d5a7b8c9d5a7b8c9d5a7b8c9d5a7b8c9d5a7b8c9d"
+                    },
+                    "outputFileURL": {
+                      "type": "string",
+                      "example": "/api/files/stego_abc123.png"
+                    },
+                    "cipher": {
+                      "type": "string",
+                      "example": "aes-256-gcm"
+                    },
+                    "keyDerivation": {
+                      "type": "string",
+                      "example": "argon2id"
+                    },
+                    "embedding": {
+                      "type": "object"
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "Invalid request",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "error": {
+                  "type": "string",
+                  "example": "expires does not apply to a vault"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "Server error",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "error": {
+                  "type": "string",
+                  "example": "Failed to create vault"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/vault/list": {
+      "post": {
+        "consumes": ["multipart/form-data"],
+        "produces": ["application/json"],
+        "summary": "List vault entries",
+        "description": "Opens the vault in a stego image and lists its entries without their secrets",
+        "parameters": [
+          {
+            "name": "image",
+            "in": "formData",
+            "description": "Stego image holding the vault",
+            "required": true,
+            "type": "file"
+          },
+          {
+            "name": "key",
+            "in": "formData",
+            "description": "Key the vault was created with (hex, Base58Check or recovery words), required unless passphrase or master is given",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "passphrase",
+            "in": "formData",
+            "description": "Passphrase the vault was created with (instead of key)",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "master",
+            "in": "formData",
+            "description": "Team master key the vault is sealed under (instead of key)",
+            "required": false,
+            "type": "string"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Vault opened successfully",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": true
+                },
+                "message": {
+                  "type": "string",
+                  "example": "Vault opened successfully"
+                },
+                "data": {
+                  "type": "object",
+                  "properties": {
+                    "entries": {
+                      "type": "array",
+                      "items": {
+                        "type": "object",
+                        "properties": {
+                          "title": {
+                            "type": "string",
+                            "example": "mail server"
+                          },
+                          "username": {
+                            "type": "string",
+                            "example": "alice"
+                          },
+                          "notes": {
+                            "type": "string"
+                          },
+                          "created": {
+                            "type": "string",
+                            "example": "2026-10-18T16:34:17Z"
+                          }
+                        }
+                      }
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "Invalid request or key",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "error": {
+                  "type": "string",
+                  "example": "Invalid key: encryption key cannot be empty"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "The key or passphrase does not open the vault (code WRONG_KEY)",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "error": {
+                  "type": "string",
+                  "example": "Failed to open vault: failed to decrypt vault: wrong encryption key"
+                },
+                "code": {
+                  "type": "string",
+                  "example": "WRONG_KEY"
+                }
+              }
+            }
+          },
+          "422": {
+            "description": "No hidden content in the image (code NO_PAYLOAD), the hidden content is not a vault (code NOT_VAULT) or it is damaged (code CORRUPT_PAYLOAD)",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "error": {
+                  "type": "string",
+                  "example": "Failed to open vault: the image holds hidden content, but not a vault"
+                },
+                "code": {
+                  "type": "string",
+                  "example": "NOT_VAULT"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/vault/get": {
+      "post": {
+        "consumes": ["multipart/form-data"],
+        "produces": ["application/json"],
+        "summary": "Get a vault entry",
+        "description": "Opens the vault in a stego image and returns one entry with its secret",
+        "parameters": [
+          {
+            "name": "image",
+            "in": "formData",
+            "description": "Stego image holding the vault",
+            "required": true,
+            "type": "file"
+          },
+          {
+            "name": "key",
+            "in": "formData",
+            "description": "Key the vault was created with (hex, Base58Check or recovery words), required unless passphrase or master is given",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "passphrase",
+            "in": "formData",
+            "description": "Passphrase the vault was created with (instead of key)",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "master",
+            "in": "formData",
+            "description": "Team master key the vault is sealed under (instead of key)",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "title",
+            "in": "formData",
+            "description": "Title of the entry",
+            "required": true,
+            "type": "string"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Entry found",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": true
+                },
+                "message": {
+                  "type": "string",
+                  "example": "Entry found"
+                },
+                "data": {
+                  "type": "object",
+                  "properties": {
+                    "title": {
+                      "type": "string",
+                      "example": "mail server"
+                    },
+                    "username": {
+                      "type": "string",
+                      "example": "alice"
+                    },
+                    "notes": {
+                      "type": "string"
+                    },
+                    "created": {
+                      "type": "string",
+                      "example": "2026-10-18T16:34:17Z"
+                    },
+                    "secret": {
+                      "type": "string",
+                      "example": "hunter2"
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "No entry with that title",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "error": {
+                  "type": "string",
+                  "example": "No entry titled mail server"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "Invalid request or key",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "error": {
+                  "type": "string",
+                  "example": "Invalid key: encryption key cannot be empty"
+                }
+              }
+            }
+          },
+          "403": {
+            "description": "The key or passphrase does not open the vault (code WRONG_KEY)",
             "schema": {
               "type": "object",
               "properties": {
@@ -109,13 +1701,17 @@ const docTemplate = `{
                 },
                 "error": {
                   "type": "string",
-                  "example": "No image file uploaded"
+                  "example": "Failed to open vault: failed to decrypt vault: wrong encryption key"
+                },
+                "code": {
+                  "type": "string",
+                  "example": "WRONG_KEY"
                 }
               }
             }
           },
-          "500": {
-            "description": "Server error",
+          "422": {
+            "description": "No hidden content in the image (code NO_PAYLOAD), the hidden content is not a vault (code NOT_VAULT) or it is damaged (code CORRUPT_PAYLOAD)",
             "schema": {
               "type": "object",
               "properties": {
@@ -125,7 +1721,11 @@ const docTemplate = `{
                 },
                 "error": {
                   "type": "string",
-                  "example": "Failed to hide message"
+                  "example": "Failed to open vault: the image holds hidden content, but not a vault"
+                },
+                "code": {
+                  "type": "string",
+                  "example": "NOT_VAULT"
                 }
               }
             }
@@ -133,31 +1733,73 @@ const docTemplate = `{
         }
       }
     },
-    "/hideFile": {
+    "/vault/add": {
       "post": {
         "consumes": ["multipart/form-data"],
         "produces": ["application/json"],
-        "summary": "Hide a file in an image",
-        "description": "Encrypts and hides any file inside an image using steganography",
+        "summary": "Add a vault entry",
+        "description": "Adds an entry to the vault and returns the image with the vault rewritten in its existing mode, copies and padding",
         "parameters": [
           {
             "name": "image",
             "in": "formData",
-            "description": "Cover image file (PNG or JPG)",
+            "description": "Stego image holding the vault",
             "required": true,
             "type": "file"
           },
           {
-            "name": "file",
+            "name": "key",
             "in": "formData",
-            "description": "File to hide (PDF, TXT, etc.)",
+            "description": "Key the vault was created with (hex, Base58Check or recovery words), required unless passphrase or master is given",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "passphrase",
+            "in": "formData",
+            "description": "Passphrase the vault was created with (instead of key)",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "master",
+            "in": "formData",
+            "description": "Team master key the vault is sealed under (instead of key)",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "title",
+            "in": "formData",
+            "description": "Title of the entry, unique in the vault",
             "required": true,
-            "type": "file"
+            "type": "string"
+          },
+          {
+            "name": "username",
+            "in": "formData",
+            "description": "Username",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "secret",
+            "in": "formData",
+            "description": "Secret to store",
+            "required": true,
+            "type": "string"
+          },
+          {
+            "name": "notes",
+            "in": "formData",
+            "description": "Notes",
+            "required": false,
+            "type": "string"
           }
         ],
         "responses": {
           "200": {
-            "description": "File hidden successfully",
+            "description": "Entry added successfully",
             "schema": {
               "type": "object",
               "properties": {
@@ -167,35 +1809,25 @@ const docTemplate = `{
                 },
                 "message": {
                   "type": "string",
-                  "example": "File hidden successfully"
+                  "example": "Entry added successfully"
                 },
                 "data": {
                   "type": "object",
                   "properties": {
-                    "key": {
-                      "type": "string",
-                      "example": "5a7b8c9d5a7b8c9d5a7b8c9d5a7b8c9d5a7b8c9d5a7b8c9d5a7b8c9d5a7b8c9d"
-                    },
                     "outputFileURL": {
                       "type": "string",
                       "example": "/api/files/stego_abc123.png"
                     },
-                    "fileDetails": {
-                      "type": "object",
-                      "properties": {
-                        "originalName": {
-                          "type": "string",
-                          "example": "document.pdf"
-                        },
-                        "fileType": {
-                          "type": "string",
-                          "example": ".pdf"
-                        },
-                        "fileSize": {
-                          "type": "integer",
-                          "example": 12345
-                        }
-                      }
+                    "cipher": {
+                      "type": "string",
+                      "example": "aes-256-gcm"
+                    },
+                    "keyDerivation": {
+                      "type": "string",
+                      "example": "argon2id"
+                    },
+                    "embedding": {
+                      "type": "object"
                     }
                   }
                 }
@@ -203,7 +1835,7 @@ const docTemplate = `{
             }
           },
           "400": {
-            "description": "Invalid request",
+            "description": "Invalid request or key",
             "schema": {
               "type": "object",
               "properties": {
@@ -213,13 +1845,13 @@ const docTemplate = `{
                 },
                 "error": {
                   "type": "string",
-                  "example": "No file to hide uploaded"
+                  "example": "Invalid key: encryption key cannot be empty"
                 }
               }
             }
           },
-          "500": {
-            "description": "Server error",
+          "403": {
+            "description": "The key or passphrase does not open the vault (code WRONG_KEY)",
             "schema": {
               "type": "object",
               "properties": {
@@ -229,7 +1861,31 @@ const docTemplate = `{
                 },
                 "error": {
                   "type": "string",
-                  "example": "Failed to hide file"
+                  "example": "Failed to open vault: failed to decrypt vault: wrong encryption key"
+                },
+                "code": {
+                  "type": "string",
+                  "example": "WRONG_KEY"
+                }
+              }
+            }
+          },
+          "422": {
+            "description": "No hidden content in the image (code NO_PAYLOAD), the hidden content is not a vault (code NOT_VAULT) or it is damaged (code CORRUPT_PAYLOAD)",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "error": {
+                  "type": "string",
+                  "example": "Failed to open vault: the image holds hidden content, but not a vault"
+                },
+                "code": {
+                  "type": "string",
+                  "example": "NOT_VAULT"
                 }
               }
             }
@@ -237,31 +1893,52 @@ const docTemplate = `{
         }
       }
     },
-    "/extract": {
+    "/vault/remove": {
       "post": {
         "consumes": ["multipart/form-data"],
         "produces": ["application/json"],
-        "summary": "Extract hidden content",
-        "description": "Extracts and decrypts hidden content from a steganographic image",
+        "summary": "Remove a vault entry",
+        "description": "Removes an entry from the vault and returns the image with the vault rewritten",
         "parameters": [
           {
             "name": "image",
             "in": "formData",
-            "description": "Image containing hidden data",
+            "description": "Stego image holding the vault",
             "required": true,
             "type": "file"
           },
           {
             "name": "key",
             "in": "formData",
-            "description": "Decryption key (64 hexadecimal characters)",
+            "description": "Key the vault was created with (hex, Base58Check or recovery words), required unless passphrase or master is given",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "passphrase",
+            "in": "formData",
+            "description": "Passphrase the vault was created with (instead of key)",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "master",
+            "in": "formData",
+            "description": "Team master key the vault is sealed under (instead of key)",
+            "required": false,
+            "type": "string"
+          },
+          {
+            "name": "title",
+            "in": "formData",
+            "description": "Title of the entry",
             "required": true,
             "type": "string"
           }
         ],
         "responses": {
           "200": {
-            "description": "Content extracted successfully",
+            "description": "Entry removed successfully",
             "schema": {
               "type": "object",
               "properties": {
@@ -271,45 +1948,49 @@ const docTemplate = `{
                 },
                 "message": {
                   "type": "string",
-                  "example": "Content extracted successfully"
+                  "example": "Entry removed successfully"
                 },
                 "data": {
                   "type": "object",
                   "properties": {
-                    "isFile": {
-                      "type": "boolean"
-                    },
-                    "message": {
-                      "type": "string",
-                      "example": "This is a secret message."
-                    },
-                    "fileURL": {
+                    "outputFileURL": {
                       "type": "string",
-                      "example": "/api/files/document.pdf"
+                      "example": "/api/files/stego_abc123.png"
                     },
-                    "fileName": {
+                    "cipher": {
                       "type": "string",
-                      "example": "document.pdf"
+                      "example": "aes-256-gcm"
                     },
-                    "fileType": {
+                    "keyDerivation": {
                       "type": "string",
-                      "example": ".pdf"
-                    },
-                    "fileSize": {
-                      "type": "integer",
-                      "example": 12345
+                      "example": "argon2id"
                     },
-                    "contentType": {
-                      "type": "string",
-                      "example": "application/pdf"
+                    "embedding": {
+                      "type": "object"
                     }
                   }
                 }
               }
             }
           },
+          "404": {
+            "description": "No entry with that title",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "error": {
+                  "type": "string",
+                  "example": "no entry titled \"mail server\""
+                }
+              }
+            }
+          },
           "400": {
-            "description": "Invalid request",
+            "description": "Invalid request or key",
             "schema": {
               "type": "object",
               "properties": {
@@ -319,13 +2000,13 @@ const docTemplate = `{
                 },
                 "error": {
                   "type": "string",
-                  "example": "Invalid key format"
+                  "example": "Invalid key: encryption key cannot be empty"
                 }
               }
             }
           },
-          "500": {
-            "description": "Server error",
+          "403": {
+            "description": "The key or passphrase does not open the vault (code WRONG_KEY)",
             "schema": {
               "type": "object",
               "properties": {
@@ -335,7 +2016,31 @@ const docTemplate = `{
                 },
                 "error": {
                   "type": "string",
-                  "example": "Failed to extract content"
+                  "example": "Failed to open vault: failed to decrypt vault: wrong encryption key"
+                },
+                "code": {
+                  "type": "string",
+                  "example": "WRONG_KEY"
+                }
+              }
+            }
+          },
+          "422": {
+            "description": "No hidden content in the image (code NO_PAYLOAD), the hidden content is not a vault (code NOT_VAULT) or it is damaged (code CORRUPT_PAYLOAD)",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "error": {
+                  "type": "string",
+                  "example": "Failed to open vault: the image holds hidden content, but not a vault"
+                },
+                "code": {
+                  "type": "string",
+                  "example": "NOT_VAULT"
                 }
               }
             }
@@ -456,6 +2161,25 @@ const docTemplate = `{
                               "example": 155520
                             }
                           }
+                        },
+                        "adaptive": {
+                          "type": "object",
+                          "properties": {
+                            "bytes": {
+                              "type": "integer",
+                              "example": 121500
+                            },
+                            "kilobytes": {
+                              "type": "number",
+                              "format": "float",
+                              "example": 118.65
+                            },
+                            "maxDensity": {
+                              "type": "number",
+                              "format": "float",
+                              "example": 0.5
+                            }
+                          }
                         }
                       }
                     }
@@ -499,6 +2223,137 @@ const docTemplate = `{
         }
       }
     },
+    "/analyze": {
+      "post": {
+        "consumes": ["multipart/form-data"],
+        "produces": ["application/json"],
+        "summary": "Detect LSB embedding",
+        "description": "Runs the chi-square attack over windows of the image's channel values, scanned row by row and column by column, and returns the embedding probability of each window. Data written in scan order from the first value shows as a leading run of windows above 0.5; smooth or saturated regions can also score high. Scattered, matrix and adaptive embedding are not detected",
+        "parameters": [
+          {
+            "name": "image",
+            "in": "formData",
+            "description": "Image file to analyze",
+            "required": true,
+            "type": "file"
+          },
+          {
+            "name": "windows",
+            "in": "formData",
+            "description": "Number of windows along each scan (default 100)",
+            "required": false,
+            "type": "integer"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Steganalysis completed",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": true
+                },
+                "message": {
+                  "type": "string",
+                  "example": "Steganalysis completed"
+                },
+                "data": {
+                  "type": "object",
+                  "properties": {
+                    "width": {
+                      "type": "integer",
+                      "example": 400
+                    },
+                    "height": {
+                      "type": "integer",
+                      "example": 300
+                    },
+                    "windowSize": {
+                      "type": "integer",
+                      "description": "Channel values in each window",
+                      "example": 3600
+                    },
+                    "probability": {
+                      "type": "number",
+                      "format": "float",
+                      "description": "Result of the attack over the whole image",
+                      "example": 0
+                    },
+                    "likely": {
+                      "type": "string",
+                      "description": "Scan order whose curve points more strongly at embedding",
+                      "example": "column-major"
+                    },
+                    "curves": {
+                      "type": "array",
+                      "items": {
+                        "type": "object",
+                        "properties": {
+                          "order": {
+                            "type": "string",
+                            "enum": ["column-major", "row-major"],
+                            "example": "column-major"
+                          },
+                          "embedded": {
+                            "type": "number",
+                            "format": "float",
+                            "description": "Share of windows with a probability above 0.5",
+                            "example": 0.29
+                          },
+                          "leading": {
+                            "type": "number",
+                            "format": "float",
+                            "description": "Share of the scan covered by the run of such windows from its start",
+                            "example": 0.29
+                          },
+                          "points": {
+                            "type": "array",
+                            "items": {
+                              "type": "object",
+                              "properties": {
+                                "offset": {
+                                  "type": "number",
+                                  "format": "float",
+                                  "description": "Where the window starts, as a share of the scan",
+                                  "example": 0
+                                },
+                                "probability": {
+                                  "type": "number",
+                                  "format": "float",
+                                  "example": 0.998
+                                }
+                              }
+                            }
+                          }
+                        }
+                      }
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "Invalid request",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "success": {
+                  "type": "boolean",
+                  "example": false
+                },
+                "error": {
+                  "type": "string",
+                  "example": "Failed to analyze image: image too small for 100 windows"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
     "/files/{filename}": {
       "get": {
         "summary": "Get a file",
@@ -577,6 +2432,10 @@ const docTemplate = `{
         },
         "error": {
           "type": "string"
+        },
+        "code": {
+          "type": "string",
+          "enum": ["NO_PAYLOAD", "WRONG_KEY", "CORRUPT_PAYLOAD", "EXPIRED"]
         }
       }
     }
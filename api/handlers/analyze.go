@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pranaykumar2/steg-go/api/utils"
+	"github.com/pranaykumar2/steg-go/pkg/steganalysis"
+)
+
+type AnalyzeResponse struct {
+	Width       int            `json:"width"`
+	Height      int            `json:"height"`
+	WindowSize  int            `json:"windowSize"`
+	Probability float64        `json:"probability"`
+	Likely      string         `json:"likely"`
+	Curves      []AnalyzeCurve `json:"curves"`
+}
+
+type AnalyzeCurve struct {
+	Order    string         `json:"order"`
+	Embedded float64        `json:"embedded"`
+	Leading  float64        `json:"leading"`
+	Points   []AnalyzePoint `json:"points"`
+}
+
+type AnalyzePoint struct {
+	Offset      float64 `json:"offset"`
+	Probability float64 `json:"probability"`
+}
+
+// Analyze runs the chi-square attack over an uploaded image and returns
+// the embedding probability curve of each scan order.
+func Analyze(c *gin.Context) {
+	windows := steganalysis.DefaultWindows
+	if value := c.PostForm("windows"); value != "" {
+		var err error
+		if windows, err = strconv.Atoi(value); err != nil || windows < 1 {
+			utils.ValidationErrorResponse(c, "windows must be a positive number")
+			return
+		}
+	}
+
+	file, err := c.FormFile("image")
+	if err != nil {
+		utils.ValidationErrorResponse(c, "No image file uploaded")
+		return
+	}
+
+	imagePath, err := utils.SaveUploadedFile(file)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Failed to save uploaded file: "+err.Error())
+		return
+	}
+
+	report, err := steganalysis.AnalyzeFile(imagePath, windows)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Failed to analyze image: "+err.Error())
+		return
+	}
+
+	response := AnalyzeResponse{
+		Width:       report.Width,
+		Height:      report.Height,
+		WindowSize:  report.WindowSize,
+		Probability: report.Probability,
+		Likely:      report.Likely().Order.String(),
+	}
+	for _, curve := range []*steganalysis.Curve{&report.ColumnMajor, &report.RowMajor} {
+		points := make([]AnalyzePoint, len(curve.Points))
+		for i, point := range curve.Points {
+			points[i] = AnalyzePoint{Offset: point.Offset, Probability: point.Probability}
+		}
+		response.Curves = append(response.Curves, AnalyzeCurve{
+			Order:    curve.Order.String(),
+			Embedded: curve.Embedded(),
+			Leading:  curve.Leading(),
+			Points:   points,
+		})
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Steganalysis completed", response)
+}
@@ -1,18 +1,25 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/hex"
+	"errors"
+	"io"
 	"net/http"
 	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/pranaykumar2/steg-go/api/utils"
 	"github.com/pranaykumar2/steg-go/internal/crypto"
 	"github.com/pranaykumar2/steg-go/internal/steganography"
+	"github.com/pranaykumar2/steg-go/internal/thread"
+	"github.com/pranaykumar2/steg-go/internal/vault"
 )
 
 type ExtractRequest struct {
-	Key string `json:"key" binding:"required"`
+	Key string `json:"key"`
 }
 
 type ExtractResponse struct {
@@ -23,6 +30,100 @@ type ExtractResponse struct {
 	FileType    string `json:"fileType,omitempty"`
 	FileSize    int64  `json:"fileSize,omitempty"`
 	ContentType string `json:"contentType,omitempty"`
+	Copies      int    `json:"copies,omitempty"`
+	RecoveredBy string `json:"recoveredBy,omitempty"`
+	Cipher      string `json:"cipher"`
+
+	Signature SignatureInfo   `json:"signature"`
+	Info      *ContentInfo    `json:"info,omitempty"`
+	Thread    []ThreadMessage `json:"thread,omitempty"`
+}
+
+type ContentInfo struct {
+	Created string `json:"created,omitempty"`
+	Author  string `json:"author,omitempty"`
+	Comment string `json:"comment,omitempty"`
+	Expires string `json:"expires,omitempty"`
+}
+
+func contentInfo(info *crypto.PayloadInfo) *ContentInfo {
+	if info == nil {
+		return nil
+	}
+
+	result := &ContentInfo{Author: info.Author, Comment: info.Comment}
+	if !info.Created.IsZero() {
+		result.Created = info.Created.UTC().Format(time.RFC3339)
+	}
+	if !info.Expires.IsZero() {
+		result.Expires = info.Expires.UTC().Format(time.RFC3339)
+	}
+	return result
+}
+
+// refuseExpired answers with an error for content past its expiry, unless
+// the form sets force, and reports whether it did.
+func refuseExpired(c *gin.Context, info *crypto.PayloadInfo) bool {
+	if info == nil || !info.Expired(time.Now()) {
+		return false
+	}
+	if force, _ := strconv.ParseBool(c.PostForm("force")); force {
+		return false
+	}
+	utils.CodedErrorResponse(c, http.StatusGone, utils.CodeExpired,
+		"Content expired on "+info.Expires.UTC().Format(time.RFC3339))
+	return true
+}
+
+type SignatureInfo struct {
+	Status string `json:"status"`
+	Signer string `json:"signer,omitempty"`
+	KeyID  string `json:"keyId,omitempty"`
+}
+
+// payloadErrorResponse reports a failure to extract or open an image's
+// payload, with an error code when the cause is known.
+func payloadErrorResponse(c *gin.Context, statusCode int, message string, err error) {
+	switch {
+	case errors.Is(err, steganography.ErrNoPayload):
+		utils.CodedErrorResponse(c, http.StatusUnprocessableEntity, utils.CodeNoPayload, message+err.Error())
+	case errors.Is(err, steganography.ErrCorruptPayload), errors.Is(err, crypto.ErrCorruptPayload):
+		var segmentErr *crypto.SegmentError
+		if errors.As(err, &segmentErr) {
+			utils.CorruptSegmentResponse(c, segmentErr.Index, message+err.Error())
+			return
+		}
+		utils.CodedErrorResponse(c, http.StatusUnprocessableEntity, utils.CodeCorruptPayload, message+err.Error())
+	case errors.Is(err, crypto.ErrWrongKey):
+		utils.CodedErrorResponse(c, http.StatusForbidden, utils.CodeWrongKey, message+err.Error())
+	case errors.Is(err, vault.ErrNotVault):
+		utils.CodedErrorResponse(c, http.StatusUnprocessableEntity, utils.CodeNotVault, message+err.Error())
+	case errors.Is(err, thread.ErrNotThread):
+		utils.CodedErrorResponse(c, http.StatusUnprocessableEntity, utils.CodeNotThread, message+err.Error())
+	default:
+		utils.ErrorResponse(c, statusCode, message+err.Error())
+	}
+}
+
+// signatureInfo checks the signature of the last extracted body against the
+// server's trusted signers list.
+func signatureInfo(decoder *steganography.Decoder) (SignatureInfo, error) {
+	signature := decoder.Signature()
+	if signature == nil {
+		return SignatureInfo{Status: string(crypto.SignatureUnsigned)}, nil
+	}
+
+	trusted, err := crypto.LoadTrustedSigners(crypto.DefaultTrustedSignersPath())
+	if err != nil {
+		return SignatureInfo{}, err
+	}
+
+	status, name := crypto.VerifySignature(trusted, signature.KeyID, signature.Message, signature.Value)
+	return SignatureInfo{
+		Status: string(status),
+		Signer: name,
+		KeyID:  hex.EncodeToString(signature.KeyID),
+	}, nil
 }
 
 func Extract(c *gin.Context) {
@@ -38,15 +139,44 @@ func Extract(c *gin.Context) {
 		return
 	}
 
-	if len(req.Key) != 64 {
-		utils.ValidationErrorResponse(c, "Invalid key length. Expected 64 hexadecimal characters")
-		return
-	}
+	passphrase, identity := c.PostForm("passphrase"), c.PostForm("identity")
+	shares, master := c.PostFormArray("share"), c.PostForm("master")
+	var key, masterKey []byte
+	defer func() {
+		crypto.Wipe(key)
+		crypto.Wipe(masterKey)
+	}()
+	if master != "" {
+		var err error
+		masterKey, err = crypto.ParseKey(master)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Invalid master key: "+err.Error())
+			return
+		}
+	} else if len(shares) > 0 {
+		keyShares := make([]crypto.KeyShare, len(shares))
+		for i, value := range shares {
+			share, err := crypto.ParseKeyShare(value)
+			if err != nil {
+				utils.ValidationErrorResponse(c, "Invalid key share "+strconv.Itoa(i+1)+": "+err.Error())
+				return
+			}
+			keyShares[i] = share
+		}
 
-	key, err := hex.DecodeString(req.Key)
-	if err != nil {
-		utils.ValidationErrorResponse(c, "Invalid key format. Must be hexadecimal")
-		return
+		var err error
+		key, err = crypto.CombineKeyShares(keyShares)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Invalid key shares: "+err.Error())
+			return
+		}
+	} else if passphrase == "" && identity == "" {
+		var err error
+		key, err = crypto.ParseKey(req.Key)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Invalid key: "+err.Error())
+			return
+		}
 	}
 
 	file, err := c.FormFile("image")
@@ -67,32 +197,150 @@ func Extract(c *gin.Context) {
 		return
 	}
 
-	data, isFile, metadata, err := decoder.Extract()
+	body, isFile, metadata, err := decoder.ExtractReader()
+	if err != nil {
+		payloadErrorResponse(c, http.StatusInternalServerError, "Failed to extract data: ", err)
+		return
+	}
+
+	// An encrypted file is decrypted from the image straight to disk, so
+	// only its envelope header is read up front.
+	streamFile := isFile && metadata == nil
+	var data []byte
+	if streamFile {
+		data, err = crypto.ReadEnvelopeHeader(body)
+	} else {
+		data, err = io.ReadAll(body)
+	}
 	if err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to extract data: "+err.Error())
 		return
 	}
+	if decoder.IsVault() {
+		utils.ValidationErrorResponse(c, "The image holds a vault; open it with /api/vault/list or /api/vault/get")
+		return
+	}
 
-	encryptor, err := crypto.NewEncryptorWithKey(key)
+	var encryptor *crypto.Encryptor
+	switch {
+	case masterKey != nil:
+		encryptor, err = crypto.NewEncryptorWithMaster(masterKey)
+	case key != nil:
+		encryptor, err = crypto.NewEncryptorWithKey(key)
+	case identity != "":
+		privateKey, parseErr := crypto.ParseIdentity(identity)
+		if parseErr != nil {
+			utils.ValidationErrorResponse(c, "Invalid private key: "+parseErr.Error())
+			return
+		}
+		encryptor, err = crypto.NewEncryptorWithIdentity(privateKey)
+	case passphrase != "":
+		encryptor, err = crypto.NewEncryptorWithPassphrase([]byte(passphrase), crypto.DefaultKDFParams)
+	}
 	if err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to initialize decryption: "+err.Error())
 		return
 	}
+	defer encryptor.Destroy()
+
+	if decoder.IsThread() {
+		extractThread(c, decoder, data, encryptor)
+		return
+	}
 
-	decrypted, err := encryptor.Decrypt(data)
+	recoveredBy := ""
+	if decoder.CopyCount() > 0 {
+		recoveredBy = "majority vote"
+	}
+
+	var decrypted []byte
+	var extracted *steganography.ExtractedFile
+	if streamFile {
+		extracted, err = decoder.DecryptFile(io.MultiReader(bytes.NewReader(data), body), encryptor, utils.TempDir,
+			func(info *crypto.PayloadInfo) error {
+				if refuseExpired(c, info) {
+					return crypto.ErrExpired
+				}
+				return nil
+			})
+		if errors.Is(err, crypto.ErrExpired) {
+			return
+		}
+		if err == nil {
+			defer steganography.WipeFile(extracted.Path)
+			metadata = extracted.Metadata
+			if extracted.Copy > 0 {
+				recoveredBy = "copy " + strconv.Itoa(extracted.Copy)
+			}
+		}
+	} else {
+		decrypted, err = encryptor.Decrypt(data)
+		for i := 0; err != nil && i < decoder.CopyCount(); i++ {
+			copyData, copyIsFile, copyMetadata, copyErr := decoder.ExtractCopy(i)
+			if copyErr != nil {
+				continue
+			}
+			if plaintext, decryptErr := encryptor.Decrypt(copyData); decryptErr == nil {
+				decrypted, isFile, metadata, err = plaintext, copyIsFile, copyMetadata, nil
+				recoveredBy = "copy " + strconv.Itoa(i+1)
+			}
+		}
+	}
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to decrypt data: "+err.Error())
+		payloadErrorResponse(c, http.StatusInternalServerError, "Failed to decrypt data: ", decoder.PayloadError(err))
+		return
+	}
+	defer crypto.Wipe(decrypted)
+	if extracted == nil && refuseExpired(c, encryptor.Info()) {
+		return
+	}
+
+	if extracted == nil && decoder.Header().Padded() {
+		decrypted, err = steganography.Unpad(decrypted)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to remove padding: "+err.Error())
+			return
+		}
+	}
+
+	if extracted == nil && isFile && metadata == nil {
+		decrypted, metadata, err = steganography.NewFileHandler().UnpackFile(decrypted)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to read file metadata: "+err.Error())
+			return
+		}
+	}
+
+	payloadCipher, err := crypto.PayloadCipher(data)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to read payload cipher: "+err.Error())
+		return
+	}
+
+	signature, err := signatureInfo(decoder)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to load trusted signers: "+err.Error())
 		return
 	}
 
 	response := ExtractResponse{
-		IsFile: isFile,
+		IsFile:      isFile,
+		Copies:      decoder.CopyCount(),
+		RecoveredBy: recoveredBy,
+		Cipher:      payloadCipher.Name(),
+		Signature:   signature,
+		Info:        contentInfo(encryptor.Info()),
 	}
 
 	if isFile && metadata != nil {
 		outputPath := filepath.Join(utils.TempDir, metadata.OriginalName)
 		fileHandler := steganography.NewFileHandler()
-		if err := fileHandler.SaveFileContent(decrypted, metadata, outputPath); err != nil {
+		if extracted != nil {
+			err = fileHandler.MoveFileContent(extracted.Path, metadata, outputPath)
+		} else {
+			err = fileHandler.SaveFileContent(decrypted, metadata, outputPath)
+		}
+		if err != nil {
 			utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to save extracted file: "+err.Error())
 			return
 		}
@@ -1,9 +1,13 @@
 package handlers
 
 import (
+	"crypto/ecdh"
 	"encoding/hex"
+	"errors"
 	"net/http"
 	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/pranaykumar2/steg-go/api/utils"
@@ -16,8 +20,215 @@ type HideTextRequest struct {
 }
 
 type HideTextResponse struct {
-	Key           string `json:"key"`
-	OutputFileURL string `json:"outputFileURL"`
+	Key           string        `json:"key,omitempty"`
+	OutputFileURL string        `json:"outputFileURL"`
+	Embedding     EmbeddingInfo `json:"embedding"`
+	KeyDerivation string        `json:"keyDerivation,omitempty"`
+	Cipher        string        `json:"cipher"`
+}
+
+type EmbeddingInfo struct {
+	Mode        string `json:"mode"`
+	CodeSize    int    `json:"codeSize,omitempty"`
+	Threshold   int    `json:"threshold,omitempty"`
+	PayloadBits int    `json:"payloadBits"`
+	Copies      int    `json:"copies,omitempty"`
+	Changes     int    `json:"changes"`
+
+	CorrectionChanges  int     `json:"correctionChanges,omitempty"`
+	RandomFillChanges  int     `json:"randomFillChanges,omitempty"`
+	Padding            string  `json:"padding,omitempty"`
+	HistogramDeviation float64 `json:"histogramDeviation"`
+}
+
+func embedOptions(c *gin.Context) (steganography.Options, error) {
+	mode, err := steganography.ParseEmbedMode(c.PostForm("mode"))
+	if err != nil {
+		return steganography.Options{}, err
+	}
+
+	options := steganography.Options{Mode: mode}
+	if density := c.PostForm("maxDensity"); density != "" {
+		options.MaxDensity, err = strconv.ParseFloat(density, 64)
+		if err != nil || options.MaxDensity <= 0 || options.MaxDensity > 1 {
+			return steganography.Options{}, errors.New("maxDensity must be a number between 0 and 1")
+		}
+	}
+
+	if preserve := c.PostForm("preserveHistogram"); preserve != "" {
+		options.PreserveHistogram, err = strconv.ParseBool(preserve)
+		if err != nil {
+			return steganography.Options{}, errors.New("preserveHistogram must be true or false")
+		}
+	}
+
+	options.Padding, err = steganography.ParsePaddingMode(c.PostForm("padding"))
+	if err != nil {
+		return steganography.Options{}, err
+	}
+
+	if randomize := c.PostForm("randomizeUnused"); randomize != "" {
+		options.RandomizeUnused, err = strconv.ParseBool(randomize)
+		if err != nil {
+			return steganography.Options{}, errors.New("randomizeUnused must be true or false")
+		}
+	}
+
+	if signingKey := c.PostForm("signingKey"); signingKey != "" {
+		options.Signer, err = crypto.ParseSigningKey(signingKey)
+		if err != nil {
+			return steganography.Options{}, err
+		}
+	}
+
+	switch copies := c.PostForm("copies"); copies {
+	case "":
+	case "auto":
+		options.Copies = steganography.AutoCopies
+	default:
+		options.Copies, err = strconv.Atoi(copies)
+		if err != nil || options.Copies < 1 || options.Copies > 255 {
+			return steganography.Options{}, errors.New("copies must be auto or a number between 1 and 255")
+		}
+	}
+
+	return options, nil
+}
+
+// hideEncryptor builds the encryptor for the form's credentials and sets
+// the requested cipher and payload info.
+func hideEncryptor(c *gin.Context) (*crypto.Encryptor, error) {
+	payloadCipher, err := crypto.ParseCipher(c.PostForm("cipher"))
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := payloadInfo(c, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptor, err := newHideEncryptor(c)
+	if err != nil {
+		return nil, err
+	}
+	encryptor.SetCipher(payloadCipher)
+	if err := encryptor.SetInfo(info); err != nil {
+		encryptor.Destroy()
+		return nil, err
+	}
+	return encryptor, nil
+}
+
+// payloadInfo is the info sealed with a new payload: the form's author,
+// comment and expiry over those of the payload it replaces, if any.
+func payloadInfo(c *gin.Context, previous *crypto.PayloadInfo) (*crypto.PayloadInfo, error) {
+	info := crypto.PayloadInfo{Created: time.Now()}
+	if previous != nil {
+		info = *previous
+	}
+	if author := c.PostForm("author"); author != "" {
+		info.Author = author
+	}
+	if comment := c.PostForm("comment"); comment != "" {
+		info.Comment = comment
+	}
+	if expires := c.PostForm("expires"); expires != "" {
+		expiry, err := time.Parse(time.RFC3339, expires)
+		if err != nil {
+			return nil, errors.New("expires must be an RFC 3339 time")
+		}
+		if !expiry.After(time.Now()) {
+			return nil, errors.New("expires must be in the future")
+		}
+		info.Expires = expiry
+	}
+	return &info, nil
+}
+
+// newHideEncryptor wraps the file key for every recipient public key given,
+// optionally alongside a passphrase. A passphrase on its own derives the
+// key directly, a team master key derives one for the image, and with none
+// of these a random key is returned to the caller.
+func newHideEncryptor(c *gin.Context) (*crypto.Encryptor, error) {
+	passphrase, master := c.PostForm("passphrase"), c.PostForm("master")
+	if master != "" && (passphrase != "" || len(c.PostFormArray("recipient")) > 0) {
+		return nil, errors.New("master cannot be combined with passphrase or recipient")
+	}
+
+	var keys []*ecdh.PublicKey
+	for _, value := range c.PostFormArray("recipient") {
+		recipient, err := crypto.ParseRecipient(value)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, recipient.Key)
+	}
+
+	switch {
+	case len(keys) > 0:
+		encryptor, err := crypto.NewEncryptorForRecipients(keys...)
+		if err != nil || passphrase == "" {
+			return encryptor, err
+		}
+		return encryptor, encryptor.AddPassphrase([]byte(passphrase), crypto.DefaultKDFParams)
+	case passphrase != "":
+		return crypto.NewEncryptorWithPassphrase([]byte(passphrase), crypto.DefaultKDFParams)
+	case master != "":
+		key, err := crypto.ParseKey(master)
+		if err != nil {
+			return nil, errors.New("invalid master key: " + err.Error())
+		}
+		defer crypto.Wipe(key)
+		return crypto.NewEncryptorWithMaster(key)
+	}
+	return crypto.NewEncryptor()
+}
+
+func keyDerivation(encryptor *crypto.Encryptor) string {
+	switch {
+	case encryptor.UsesPassphrase():
+		return "argon2id"
+	case encryptor.UsesRecipients():
+		return "x25519"
+	case encryptor.UsesMaster():
+		return "hkdf"
+	}
+	return ""
+}
+
+// responseKey is the hex key to hand back, empty when the caller's
+// passphrase, master key or the recipient's private key is all that is
+// needed to extract.
+func responseKey(encryptor *crypto.Encryptor) string {
+	if encryptor.UsesPassphrase() || encryptor.UsesRecipients() || encryptor.UsesMaster() {
+		return ""
+	}
+	return hex.EncodeToString(encryptor.GetKey())
+}
+
+func padPlaintext(encoder *steganography.Encoder, encryptor *crypto.Encryptor, data []byte) ([]byte, error) {
+	size, err := encoder.PaddedSize(len(data), encryptor.Overhead)
+	if err != nil || size == len(data) {
+		return data, err
+	}
+	return steganography.Pad(data, size)
+}
+
+func embeddingInfo(stats steganography.EmbedStats) EmbeddingInfo {
+	return EmbeddingInfo{
+		Mode:        stats.Mode.String(),
+		CodeSize:    stats.CodeSize,
+		Threshold:   stats.Threshold,
+		PayloadBits: stats.PayloadBits,
+		Copies:      stats.Copies,
+		Changes:     stats.Changes,
+
+		CorrectionChanges:  stats.CorrectionChanges,
+		RandomFillChanges:  stats.RandomFillChanges,
+		Padding:            stats.Padding.String(),
+		HistogramDeviation: stats.HistogramDeviation,
+	}
 }
 
 func HideText(c *gin.Context) {
@@ -38,6 +249,12 @@ func HideText(c *gin.Context) {
 		return
 	}
 
+	options, err := embedOptions(c)
+	if err != nil {
+		utils.ValidationErrorResponse(c, err.Error())
+		return
+	}
+
 	file, err := c.FormFile("image")
 	if err != nil {
 		utils.ValidationErrorResponse(c, "No image file uploaded")
@@ -50,19 +267,27 @@ func HideText(c *gin.Context) {
 		return
 	}
 
-	encoder, err := steganography.NewEncoder(inputPath)
+	encoder, err := steganography.NewEncoderWithOptions(inputPath, options)
 	if err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to initialize encoder: "+err.Error())
 		return
 	}
 
-	encryptor, err := crypto.NewEncryptor()
+	encryptor, err := hideEncryptor(c)
+	if err != nil {
+		utils.ValidationErrorResponse(c, "Failed to initialize encryption: "+err.Error())
+		return
+	}
+	defer encryptor.Destroy()
+
+	plaintext, err := padPlaintext(encoder, encryptor, []byte(req.Message))
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to initialize encryption: "+err.Error())
+		utils.ErrorResponse(c, http.StatusBadRequest, "Failed to pad message: "+err.Error())
 		return
 	}
+	defer crypto.Wipe(plaintext)
 
-	encrypted, err := encryptor.Encrypt([]byte(req.Message))
+	encrypted, err := encryptor.Encrypt(plaintext)
 	if err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to encrypt message: "+err.Error())
 		return
@@ -83,10 +308,11 @@ func HideText(c *gin.Context) {
 
 	outputURL := "/api/files/" + filepath.Base(outputPath)
 
-	keyHex := hex.EncodeToString(encryptor.GetKey())
-
 	utils.SuccessResponse(c, http.StatusOK, "Message hidden successfully", HideTextResponse{
-		Key:           keyHex,
+		Key:           responseKey(encryptor),
 		OutputFileURL: outputURL,
+		Embedding:     embeddingInfo(encoder.Stats()),
+		KeyDerivation: keyDerivation(encryptor),
+		Cipher:        encryptor.Cipher().Name(),
 	})
 }
@@ -1,7 +1,6 @@
 package handlers
 
 import (
-	"encoding/hex"
 	"io"
 	"net/http"
 	"os"
@@ -14,8 +13,11 @@ import (
 )
 
 type HideFileResponse struct {
-	Key           string `json:"key"`
-	OutputFileURL string `json:"outputFileURL"`
+	Key           string        `json:"key,omitempty"`
+	OutputFileURL string        `json:"outputFileURL"`
+	Embedding     EmbeddingInfo `json:"embedding"`
+	KeyDerivation string        `json:"keyDerivation,omitempty"`
+	Cipher        string        `json:"cipher"`
 	FileDetails   struct {
 		OriginalName string `json:"originalName"`
 		FileType     string `json:"fileType"`
@@ -30,6 +32,12 @@ func HideFile(c *gin.Context) {
 		return
 	}
 
+	options, err := embedOptions(c)
+	if err != nil {
+		utils.ValidationErrorResponse(c, err.Error())
+		return
+	}
+
 	imageFile, err := c.FormFile("image")
 	if err != nil {
 		utils.ValidationErrorResponse(c, "No cover image uploaded")
@@ -53,7 +61,7 @@ func HideFile(c *gin.Context) {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to create temporary file: "+err.Error())
 		return
 	}
-	defer os.Remove(tempFileToHide.Name())
+	defer steganography.WipeFile(tempFileToHide.Name())
 	defer tempFileToHide.Close()
 	src, err := fileToHide.Open()
 	if err != nil {
@@ -73,29 +81,40 @@ func HideFile(c *gin.Context) {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to read file: "+err.Error())
 		return
 	}
+	defer crypto.Wipe(fileData)
 
 	metadata.OriginalName = fileToHide.Filename
 	metadata.FileExt = filepath.Ext(fileToHide.Filename)
 
-	encoder, err := steganography.NewEncoder(imagePath)
+	encoder, err := steganography.NewEncoderWithOptions(imagePath, options)
 	if err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to initialize encoder: "+err.Error())
 		return
 	}
 
-	encryptor, err := crypto.NewEncryptor()
+	encryptor, err := hideEncryptor(c)
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to initialize encryption: "+err.Error())
+		utils.ValidationErrorResponse(c, "Failed to initialize encryption: "+err.Error())
 		return
 	}
+	defer encryptor.Destroy()
 
-	encrypted, err := encryptor.Encrypt(fileData)
+	packed := fileHandler.PackFile(fileData, metadata)
+	defer crypto.Wipe(packed)
+	plaintext, err := padPlaintext(encoder, encryptor, packed)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Failed to pad file: "+err.Error())
+		return
+	}
+	defer crypto.Wipe(plaintext)
+
+	encrypted, err := encryptor.Encrypt(plaintext)
 	if err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to encrypt file: "+err.Error())
 		return
 	}
 
-	if err := encoder.HideFile(encrypted, metadata); err != nil {
+	if err := encoder.HideFile(encrypted); err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to hide file: "+err.Error())
 		return
 	}
@@ -110,11 +129,12 @@ func HideFile(c *gin.Context) {
 
 	outputURL := "/api/files/" + filepath.Base(outputPath)
 
-	keyHex := hex.EncodeToString(encryptor.GetKey())
-
 	response := HideFileResponse{
-		Key:           keyHex,
+		Key:           responseKey(encryptor),
 		OutputFileURL: outputURL,
+		Embedding:     embeddingInfo(encoder.Stats()),
+		KeyDerivation: keyDerivation(encryptor),
+		Cipher:        encryptor.Cipher().Name(),
 	}
 	response.FileDetails.OriginalName = metadata.OriginalName
 	response.FileDetails.FileType = metadata.FileExt
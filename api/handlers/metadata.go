@@ -5,6 +5,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/pranaykumar2/steg-go/api/utils"
+	"github.com/pranaykumar2/steg-go/internal/steganography"
 	"github.com/pranaykumar2/steg-go/pkg/exiftools"
 )
 
@@ -29,6 +30,11 @@ type MetadataResponse struct {
 			Characters int `json:"characters"`
 			Words      int `json:"words"`
 		} `json:"text"`
+		Adaptive struct {
+			Bytes      int     `json:"bytes"`
+			Kilobytes  float64 `json:"kilobytes"`
+			MaxDensity float64 `json:"maxDensity"`
+		} `json:"adaptive"`
 	} `json:"steganoCapacity"`
 }
 
@@ -74,5 +80,11 @@ func AnalyzeMetadata(c *gin.Context) {
 	response.SteganoCapacity.Text.Characters = capacityBytes
 	response.SteganoCapacity.Text.Words = capacityBytes / 5
 
+	if capacity, err := steganography.EstimateCapacity(imagePath); err == nil {
+		response.SteganoCapacity.Adaptive.Bytes = capacity.Adaptive
+		response.SteganoCapacity.Adaptive.Kilobytes = float64(capacity.Adaptive) / 1024
+		response.SteganoCapacity.Adaptive.MaxDensity = capacity.MaxDensity
+	}
+
 	utils.SuccessResponse(c, http.StatusOK, "Metadata analysis completed", response)
 }
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"crypto/ed25519"
+	"net/http"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pranaykumar2/steg-go/api/utils"
+	"github.com/pranaykumar2/steg-go/internal/crypto"
+	"github.com/pranaykumar2/steg-go/internal/steganography"
+)
+
+type RekeyResponse struct {
+	Key              string        `json:"key,omitempty"`
+	OutputFileURL    string        `json:"outputFileURL"`
+	Embedding        EmbeddingInfo `json:"embedding"`
+	KeyDerivation    string        `json:"keyDerivation,omitempty"`
+	Cipher           string        `json:"cipher"`
+	IsFile           bool          `json:"isFile"`
+	IsVault          bool          `json:"isVault,omitempty"`
+	SignatureDropped bool          `json:"signatureDropped,omitempty"`
+}
+
+// rekeyDecryptor opens the image's current payload with whichever of the
+// old credentials the form carries.
+func rekeyDecryptor(c *gin.Context) (*crypto.Encryptor, error) {
+	if identity := c.PostForm("oldIdentity"); identity != "" {
+		privateKey, err := crypto.ParseIdentity(identity)
+		if err != nil {
+			return nil, err
+		}
+		return crypto.NewEncryptorWithIdentity(privateKey)
+	}
+	if passphrase := c.PostForm("oldPassphrase"); passphrase != "" {
+		return crypto.NewEncryptorWithPassphrase([]byte(passphrase), crypto.DefaultKDFParams)
+	}
+	if master := c.PostForm("oldMaster"); master != "" {
+		key, err := crypto.ParseKey(master)
+		if err != nil {
+			return nil, err
+		}
+		defer crypto.Wipe(key)
+		return crypto.NewEncryptorWithMaster(key)
+	}
+
+	key, err := crypto.ParseKey(c.PostForm("oldKey"))
+	if err != nil {
+		return nil, err
+	}
+	defer crypto.Wipe(key)
+	return crypto.NewEncryptorWithKey(key)
+}
+
+// Rekey re-encrypts the payload of a stego image under new credentials and
+// embeds it again over the same image, in the layout it already has.
+func Rekey(c *gin.Context) {
+	if err := c.Request.ParseMultipartForm(utils.MaxFileSize); err != nil {
+		utils.ValidationErrorResponse(c, "Invalid form data: "+err.Error())
+		return
+	}
+
+	decryptor, err := rekeyDecryptor(c)
+	if err != nil {
+		utils.ValidationErrorResponse(c, "Invalid old key: "+err.Error())
+		return
+	}
+	defer decryptor.Destroy()
+
+	encryptor, err := hideEncryptor(c)
+	if err != nil {
+		utils.ValidationErrorResponse(c, "Failed to initialize encryption: "+err.Error())
+		return
+	}
+	defer encryptor.Destroy()
+
+	var signer ed25519.PrivateKey
+	if signingKey := c.PostForm("signingKey"); signingKey != "" {
+		if signer, err = crypto.ParseSigningKey(signingKey); err != nil {
+			utils.ValidationErrorResponse(c, "Invalid signing key: "+err.Error())
+			return
+		}
+	}
+
+	file, err := c.FormFile("image")
+	if err != nil {
+		utils.ValidationErrorResponse(c, "No image file uploaded")
+		return
+	}
+
+	inputPath, err := utils.SaveUploadedFile(file)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Failed to save uploaded file: "+err.Error())
+		return
+	}
+
+	decoder, err := steganography.NewDecoder(inputPath)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to initialize decoder: "+err.Error())
+		return
+	}
+
+	data, isFile, metadata, err := decoder.Extract()
+	if err != nil {
+		payloadErrorResponse(c, http.StatusInternalServerError, "Failed to extract data: ", err)
+		return
+	}
+	if decoder.IsThread() {
+		utils.ValidationErrorResponse(c, "Rekey does not apply to a thread; each message is sealed on its own")
+		return
+	}
+
+	decrypted, err := decryptor.Decrypt(data)
+	for i := 0; err != nil && i < decoder.CopyCount(); i++ {
+		copyData, copyIsFile, copyMetadata, copyErr := decoder.ExtractCopy(i)
+		if copyErr != nil {
+			continue
+		}
+		if plaintext, decryptErr := decryptor.Decrypt(copyData); decryptErr == nil {
+			data, decrypted, isFile, metadata, err = copyData, plaintext, copyIsFile, copyMetadata, nil
+		}
+	}
+	if err != nil {
+		payloadErrorResponse(c, http.StatusBadRequest, "Failed to decrypt data: ", decoder.PayloadError(err))
+		return
+	}
+	defer crypto.Wipe(decrypted)
+	if refuseExpired(c, decryptor.Info()) {
+		return
+	}
+
+	info, err := payloadInfo(c, decryptor.Info())
+	if err == nil {
+		err = encryptor.SetInfo(info)
+	}
+	if err != nil {
+		utils.ValidationErrorResponse(c, "Invalid payload info: "+err.Error())
+		return
+	}
+
+	plaintext, err := decoder.RekeyPlaintext(decrypted, metadata, len(data), encryptor.Overhead)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Failed to prepare payload: "+err.Error())
+		return
+	}
+	defer crypto.Wipe(plaintext)
+
+	encrypted, err := encryptor.Encrypt(plaintext)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to encrypt payload: "+err.Error())
+		return
+	}
+
+	header := decoder.Header()
+	options := decoder.RekeyOptions()
+	options.Signer = signer
+	encoder, err := steganography.NewEncoderWithOptions(inputPath, options)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to initialize encoder: "+err.Error())
+		return
+	}
+
+	switch {
+	case decoder.IsVault():
+		err = encoder.HideVault(encrypted)
+	case isFile:
+		err = encoder.HideFile(encrypted)
+	default:
+		err = encoder.Hide(encrypted)
+	}
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to embed payload: "+err.Error())
+		return
+	}
+
+	uniqueName := utils.GenerateUniqueFilename(file.Filename)
+	outputPath := filepath.Join(utils.TempDir, "stego_"+uniqueName)
+
+	if err := encoder.SaveOutput(outputPath); err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to save output image: "+err.Error())
+		return
+	}
+
+	// The header only records that the payload was padded, not how.
+	embedding := embeddingInfo(encoder.Stats())
+	if header.Padded() {
+		embedding.Padding = "kept"
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Payload re-encrypted successfully", RekeyResponse{
+		Key:              responseKey(encryptor),
+		OutputFileURL:    "/api/files/" + filepath.Base(outputPath),
+		Embedding:        embedding,
+		KeyDerivation:    keyDerivation(encryptor),
+		Cipher:           encryptor.Cipher().Name(),
+		IsFile:           isFile,
+		IsVault:          decoder.IsVault(),
+		SignatureDropped: header.Signed() && signer == nil,
+	})
+}
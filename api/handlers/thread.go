@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pranaykumar2/steg-go/api/utils"
+	"github.com/pranaykumar2/steg-go/internal/crypto"
+	"github.com/pranaykumar2/steg-go/internal/steganography"
+	"github.com/pranaykumar2/steg-go/internal/thread"
+)
+
+type PostResponse struct {
+	Key           string        `json:"key,omitempty"`
+	OutputFileURL string        `json:"outputFileURL"`
+	Seq           int           `json:"seq"`
+	Messages      int           `json:"messages"`
+	Author        string        `json:"author,omitempty"`
+	Embedding     EmbeddingInfo `json:"embedding"`
+	KeyDerivation string        `json:"keyDerivation,omitempty"`
+	Cipher        string        `json:"cipher"`
+}
+
+type ThreadMessage struct {
+	Seq       int           `json:"seq"`
+	Created   string        `json:"created,omitempty"`
+	Message   string        `json:"message,omitempty"`
+	Sealed    bool          `json:"sealed,omitempty"`
+	Signature SignatureInfo `json:"signature"`
+}
+
+// threadEmbeddingFields describe the embedding, which an existing thread
+// keeps.
+var threadEmbeddingFields = []string{"mode", "maxDensity", "preserveHistogram", "randomizeUnused", "copies"}
+
+// threadEncryptor seals the next message of a thread. Besides the
+// credentials /api/hide takes, an existing key can be given; a new key is
+// only generated for a new thread.
+func threadEncryptor(c *gin.Context, exists bool) (*crypto.Encryptor, error) {
+	value := c.PostForm("key")
+	if value == "" {
+		if exists && c.PostForm("passphrase") == "" && c.PostForm("master") == "" && len(c.PostFormArray("recipient")) == 0 {
+			return nil, errors.New("posting to a thread needs the key, passphrase, master key or recipient it is sealed with")
+		}
+		return hideEncryptor(c)
+	}
+
+	payloadCipher, err := crypto.ParseCipher(c.PostForm("cipher"))
+	if err != nil {
+		return nil, err
+	}
+	info, err := payloadInfo(c, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := crypto.ParseKey(value)
+	if err != nil {
+		return nil, errors.New("invalid key: " + err.Error())
+	}
+	defer crypto.Wipe(key)
+	encryptor, err := crypto.NewEncryptorWithKey(key)
+	if err != nil {
+		return nil, err
+	}
+	encryptor.SetCipher(payloadCipher)
+	if err := encryptor.SetInfo(info); err != nil {
+		encryptor.Destroy()
+		return nil, err
+	}
+	return encryptor, nil
+}
+
+// Post appends a message to the thread in the uploaded image, or starts a
+// thread in a cover image.
+func Post(c *gin.Context) {
+	if err := c.Request.ParseMultipartForm(utils.MaxFileSize); err != nil {
+		utils.ValidationErrorResponse(c, "Invalid form data: "+err.Error())
+		return
+	}
+
+	message := c.PostForm("message")
+	if message == "" {
+		utils.ValidationErrorResponse(c, "Message cannot be empty")
+		return
+	}
+	for _, field := range []string{"padding", "expires"} {
+		if c.PostForm(field) != "" {
+			utils.ValidationErrorResponse(c, field+" does not apply to a thread")
+			return
+		}
+	}
+
+	options, err := embedOptions(c)
+	if err != nil {
+		utils.ValidationErrorResponse(c, err.Error())
+		return
+	}
+	// The message is signed rather than the image, whose signature would
+	// not survive the next message.
+	signer := options.Signer
+
+	file, err := c.FormFile("image")
+	if err != nil {
+		utils.ValidationErrorResponse(c, "No image file uploaded")
+		return
+	}
+
+	inputPath, err := utils.SaveUploadedFile(file)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Failed to save uploaded file: "+err.Error())
+		return
+	}
+
+	decoder, err := steganography.NewDecoder(inputPath)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to initialize decoder: "+err.Error())
+		return
+	}
+
+	var conversation *thread.Thread
+	data, _, _, err := decoder.Extract()
+	switch {
+	case errors.Is(err, steganography.ErrNoPayload):
+		conversation = thread.New(options)
+	case err != nil:
+		payloadErrorResponse(c, http.StatusInternalServerError, "Failed to extract data: ", err)
+		return
+	default:
+		if conversation, err = thread.Parse(decoder, data); err != nil {
+			payloadErrorResponse(c, http.StatusInternalServerError, "Failed to read thread: ", err)
+			return
+		}
+		for _, field := range threadEmbeddingFields {
+			if c.PostForm(field) != "" {
+				utils.ValidationErrorResponse(c, field+" does not apply to an existing thread; it keeps its embedding")
+				return
+			}
+		}
+	}
+
+	encryptor, err := threadEncryptor(c, len(conversation.Records) > 0)
+	if err != nil {
+		utils.ValidationErrorResponse(c, "Failed to initialize encryption: "+err.Error())
+		return
+	}
+	defer encryptor.Destroy()
+
+	posted, err := conversation.Append(encryptor, message, signer)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	outputPath := filepath.Join(utils.TempDir, "stego_"+utils.GenerateUniqueFilename(file.Filename))
+	encoder, err := conversation.Save(inputPath, outputPath)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	key := ""
+	if posted.Seq == 1 {
+		key = responseKey(encryptor)
+	}
+	utils.SuccessResponse(c, http.StatusOK, "Message posted successfully", PostResponse{
+		Key:           key,
+		OutputFileURL: "/api/files/" + filepath.Base(outputPath),
+		Seq:           posted.Seq,
+		Messages:      len(conversation.Records),
+		Author:        hex.EncodeToString(posted.Author),
+		Embedding:     embeddingInfo(encoder.Stats()),
+		KeyDerivation: keyDerivation(encryptor),
+		Cipher:        encryptor.Cipher().Name(),
+	})
+}
+
+// extractThread answers an extract request for a thread with every message
+// the credentials open, oldest first.
+func extractThread(c *gin.Context, decoder *steganography.Decoder, data []byte, encryptor *crypto.Encryptor) {
+	conversation, err := thread.Parse(decoder, data)
+	if err != nil {
+		payloadErrorResponse(c, http.StatusInternalServerError, "Failed to read thread: ", err)
+		return
+	}
+
+	messages, err := conversation.Messages(encryptor)
+	if err != nil {
+		payloadErrorResponse(c, http.StatusInternalServerError, "Failed to decrypt data: ", err)
+		return
+	}
+
+	trusted, err := crypto.LoadTrustedSigners(crypto.DefaultTrustedSignersPath())
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to load trusted signers: "+err.Error())
+		return
+	}
+
+	payloadCipher, err := crypto.PayloadCipher(conversation.Records[0])
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to read payload cipher: "+err.Error())
+		return
+	}
+
+	response := ExtractResponse{
+		Cipher:    payloadCipher.Name(),
+		Signature: SignatureInfo{Status: string(crypto.SignatureUnsigned)},
+		Thread:    make([]ThreadMessage, len(messages)),
+	}
+	for i, message := range messages {
+		if message == nil {
+			response.Thread[i] = ThreadMessage{Seq: i + 1, Sealed: true, Signature: SignatureInfo{Status: string(crypto.SignatureUnsigned)}}
+			continue
+		}
+		response.Thread[i] = ThreadMessage{
+			Seq:       message.Seq,
+			Created:   message.Created.UTC().Format(time.RFC3339),
+			Message:   message.Text,
+			Signature: messageSignature(trusted, message),
+		}
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Thread extracted successfully", response)
+}
+
+func messageSignature(trusted []crypto.TrustedSigner, message *thread.Message) SignatureInfo {
+	if message.Signature == nil {
+		return SignatureInfo{Status: string(crypto.SignatureUnsigned)}
+	}
+	status, name := crypto.VerifySignature(trusted, message.Author, message.SignedData(), message.Signature)
+	return SignatureInfo{
+		Status: string(status),
+		Signer: name,
+		KeyID:  hex.EncodeToString(message.Author),
+	}
+}
@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pranaykumar2/steg-go/api/utils"
+	"github.com/pranaykumar2/steg-go/internal/crypto"
+	"github.com/pranaykumar2/steg-go/internal/steganography"
+	"github.com/pranaykumar2/steg-go/internal/vault"
+)
+
+type VaultResponse struct {
+	Key           string        `json:"key,omitempty"`
+	OutputFileURL string        `json:"outputFileURL"`
+	Embedding     EmbeddingInfo `json:"embedding"`
+	KeyDerivation string        `json:"keyDerivation,omitempty"`
+	Cipher        string        `json:"cipher"`
+}
+
+type VaultEntry struct {
+	Title    string `json:"title"`
+	Username string `json:"username,omitempty"`
+	Secret   string `json:"secret,omitempty"`
+	Notes    string `json:"notes,omitempty"`
+	Created  string `json:"created"`
+}
+
+func vaultEntry(entry *vault.Entry) VaultEntry {
+	return VaultEntry{
+		Title:    entry.Title,
+		Username: entry.Username,
+		Notes:    entry.Notes,
+		Created:  entry.Created.UTC().Format(time.RFC3339),
+	}
+}
+
+// vaultDecryptor opens a vault with whichever credentials the form carries.
+// Vaults are never sealed for recipients, so there is no identity to try.
+func vaultDecryptor(c *gin.Context) (*crypto.Encryptor, error) {
+	if passphrase := c.PostForm("passphrase"); passphrase != "" {
+		return crypto.NewEncryptorWithPassphrase([]byte(passphrase), crypto.DefaultKDFParams)
+	}
+	if master := c.PostForm("master"); master != "" {
+		key, err := crypto.ParseKey(master)
+		if err != nil {
+			return nil, err
+		}
+		defer crypto.Wipe(key)
+		return crypto.NewEncryptorWithMaster(key)
+	}
+
+	key, err := crypto.ParseKey(c.PostForm("key"))
+	if err != nil {
+		return nil, err
+	}
+	defer crypto.Wipe(key)
+	return crypto.NewEncryptorWithKey(key)
+}
+
+// openVault opens the vault in the uploaded image. On failure it has
+// already answered the request; otherwise the caller destroys the
+// encryptor, which seals the vault again after a change.
+func openVault(c *gin.Context) (*vault.Vault, *crypto.Encryptor, string, bool) {
+	if err := c.Request.ParseMultipartForm(utils.MaxFileSize); err != nil {
+		utils.ValidationErrorResponse(c, "Invalid form data: "+err.Error())
+		return nil, nil, "", false
+	}
+
+	encryptor, err := vaultDecryptor(c)
+	if err != nil {
+		utils.ValidationErrorResponse(c, "Invalid key: "+err.Error())
+		return nil, nil, "", false
+	}
+
+	file, err := c.FormFile("image")
+	if err != nil {
+		encryptor.Destroy()
+		utils.ValidationErrorResponse(c, "No image file uploaded")
+		return nil, nil, "", false
+	}
+
+	imagePath, err := utils.SaveUploadedFile(file)
+	if err != nil {
+		encryptor.Destroy()
+		utils.ErrorResponse(c, http.StatusBadRequest, "Failed to save uploaded file: "+err.Error())
+		return nil, nil, "", false
+	}
+
+	decoder, err := steganography.NewDecoder(imagePath)
+	if err != nil {
+		encryptor.Destroy()
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to initialize decoder: "+err.Error())
+		return nil, nil, "", false
+	}
+
+	data, _, _, err := decoder.Extract()
+	if err != nil {
+		encryptor.Destroy()
+		payloadErrorResponse(c, http.StatusInternalServerError, "Failed to extract data: ", err)
+		return nil, nil, "", false
+	}
+
+	v, err := vault.Open(decoder, data, encryptor)
+	if err != nil {
+		encryptor.Destroy()
+		payloadErrorResponse(c, http.StatusBadRequest, "Failed to open vault: ", err)
+		return nil, nil, "", false
+	}
+	return v, encryptor, imagePath, true
+}
+
+// saveVault writes the changed vault over a copy of the uploaded image and
+// answers with its URL.
+func saveVault(c *gin.Context, v *vault.Vault, encryptor *crypto.Encryptor, imagePath, message string) {
+	outputPath := filepath.Join(utils.TempDir, "stego_"+utils.GenerateUniqueFilename(filepath.Base(imagePath)))
+	encoder, err := v.Save(encryptor, imagePath, outputPath)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to save vault: "+err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, message, VaultResponse{
+		OutputFileURL: "/api/files/" + filepath.Base(outputPath),
+		Embedding:     embeddingInfo(encoder.Stats()),
+		KeyDerivation: keyDerivation(encryptor),
+		Cipher:        encryptor.Cipher().Name(),
+	})
+}
+
+// VaultInit embeds an empty vault in the uploaded image. It takes the
+// embedding and key options of /api/hide, except recipients, signing and
+// expiry, which a vault could not keep across changes.
+func VaultInit(c *gin.Context) {
+	if err := c.Request.ParseMultipartForm(utils.MaxFileSize); err != nil {
+		utils.ValidationErrorResponse(c, "Invalid form data: "+err.Error())
+		return
+	}
+
+	for _, field := range []string{"recipient", "signingKey", "expires"} {
+		if c.PostForm(field) != "" {
+			utils.ValidationErrorResponse(c, field+" does not apply to a vault")
+			return
+		}
+	}
+
+	options, err := embedOptions(c)
+	if err != nil {
+		utils.ValidationErrorResponse(c, err.Error())
+		return
+	}
+
+	file, err := c.FormFile("image")
+	if err != nil {
+		utils.ValidationErrorResponse(c, "No image file uploaded")
+		return
+	}
+
+	inputPath, err := utils.SaveUploadedFile(file)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Failed to save uploaded file: "+err.Error())
+		return
+	}
+
+	encryptor, err := hideEncryptor(c)
+	if err != nil {
+		utils.ValidationErrorResponse(c, "Failed to initialize encryption: "+err.Error())
+		return
+	}
+	defer encryptor.Destroy()
+
+	outputPath := filepath.Join(utils.TempDir, "stego_"+utils.GenerateUniqueFilename(file.Filename))
+	encoder, err := vault.New(options).Save(encryptor, inputPath, outputPath)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to create vault: "+err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Vault created successfully", VaultResponse{
+		Key:           responseKey(encryptor),
+		OutputFileURL: "/api/files/" + filepath.Base(outputPath),
+		Embedding:     embeddingInfo(encoder.Stats()),
+		KeyDerivation: keyDerivation(encryptor),
+		Cipher:        encryptor.Cipher().Name(),
+	})
+}
+
+// VaultList lists the entries of a vault without their secrets.
+func VaultList(c *gin.Context) {
+	v, encryptor, _, ok := openVault(c)
+	if !ok {
+		return
+	}
+	defer encryptor.Destroy()
+	defer v.Wipe()
+
+	entries := make([]VaultEntry, len(v.Entries))
+	for i, entry := range v.Entries {
+		entries[i] = vaultEntry(entry)
+	}
+	utils.SuccessResponse(c, http.StatusOK, "Vault opened successfully", gin.H{"entries": entries})
+}
+
+func VaultGet(c *gin.Context) {
+	v, encryptor, _, ok := openVault(c)
+	if !ok {
+		return
+	}
+	defer encryptor.Destroy()
+	defer v.Wipe()
+
+	entry := v.Find(c.PostForm("title"))
+	if entry == nil {
+		utils.NotFoundResponse(c, "No entry titled "+c.PostForm("title"))
+		return
+	}
+	response := vaultEntry(entry)
+	response.Secret = string(entry.Secret)
+	utils.SuccessResponse(c, http.StatusOK, "Entry found", response)
+}
+
+func VaultAdd(c *gin.Context) {
+	v, encryptor, imagePath, ok := openVault(c)
+	if !ok {
+		return
+	}
+	defer encryptor.Destroy()
+	defer v.Wipe()
+
+	entry := &vault.Entry{
+		Title:    c.PostForm("title"),
+		Username: c.PostForm("username"),
+		Secret:   []byte(c.PostForm("secret")),
+		Notes:    c.PostForm("notes"),
+	}
+	if err := v.Add(entry); err != nil {
+		utils.ValidationErrorResponse(c, err.Error())
+		return
+	}
+	saveVault(c, v, encryptor, imagePath, "Entry added successfully")
+}
+
+func VaultRemove(c *gin.Context) {
+	v, encryptor, imagePath, ok := openVault(c)
+	if !ok {
+		return
+	}
+	defer encryptor.Destroy()
+	defer v.Wipe()
+
+	if err := v.Remove(c.PostForm("title")); err != nil {
+		utils.NotFoundResponse(c, err.Error())
+		return
+	}
+	saveVault(c, v, encryptor, imagePath, "Entry removed successfully")
+}
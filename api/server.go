@@ -67,7 +67,15 @@ func (s *Server) setupRoutes() {
 		v1.POST("/hide", handlers.HideText)
 		v1.POST("/hideFile", handlers.HideFile)
 		v1.POST("/extract", handlers.Extract)
+		v1.POST("/rekey", handlers.Rekey)
+		v1.POST("/post", handlers.Post)
+		v1.POST("/vault/init", handlers.VaultInit)
+		v1.POST("/vault/list", handlers.VaultList)
+		v1.POST("/vault/get", handlers.VaultGet)
+		v1.POST("/vault/add", handlers.VaultAdd)
+		v1.POST("/vault/remove", handlers.VaultRemove)
 		v1.POST("/metadata", handlers.AnalyzeMetadata)
+		v1.POST("/analyze", handlers.Analyze)
 
 		// File serving endpoint
 		v1.GET("/files/:filename", handlers.ServeFile)
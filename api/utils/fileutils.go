@@ -12,6 +12,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/pranaykumar2/steg-go/internal/steganography"
 )
 
 var supportedImageTypes = map[string]bool{
@@ -103,7 +105,11 @@ func CleanupTempFiles(maxAge time.Duration) error {
 		}
 
 		if now.Sub(info.ModTime()) > maxAge {
-			if err := os.Remove(path); err != nil {
+			remove := steganography.WipeFile
+			if info.IsDir() {
+				remove = os.Remove
+			}
+			if err := remove(path); err != nil {
 				return err
 			}
 		}
@@ -11,8 +11,24 @@ type Response struct {
 	Message string      `json:"message,omitempty"`
 	Data    interface{} `json:"data,omitempty"`
 	Error   string      `json:"error,omitempty"`
+	Code    string      `json:"code,omitempty"`
+
+	// FailedSegment is the zero-based index of the first segment of a
+	// payload that failed to authenticate.
+	FailedSegment *int `json:"failedSegment,omitempty"`
 }
 
+// Error codes let clients tell why extracting failed without parsing the
+// error message.
+const (
+	CodeNoPayload      = "NO_PAYLOAD"
+	CodeWrongKey       = "WRONG_KEY"
+	CodeCorruptPayload = "CORRUPT_PAYLOAD"
+	CodeExpired        = "EXPIRED"
+	CodeNotVault       = "NOT_VAULT"
+	CodeNotThread      = "NOT_THREAD"
+)
+
 func SuccessResponse(c *gin.Context, statusCode int, message string, data interface{}) {
 	c.JSON(statusCode, Response{
 		Success: true,
@@ -28,6 +44,25 @@ func ErrorResponse(c *gin.Context, statusCode int, message string) {
 	})
 }
 
+func CodedErrorResponse(c *gin.Context, statusCode int, code, message string) {
+	c.JSON(statusCode, Response{
+		Success: false,
+		Error:   message,
+		Code:    code,
+	})
+}
+
+// CorruptSegmentResponse is the CORRUPT_PAYLOAD error for a payload known
+// to be damaged from a given segment on.
+func CorruptSegmentResponse(c *gin.Context, segment int, message string) {
+	c.JSON(http.StatusUnprocessableEntity, Response{
+		Success:       false,
+		Error:         message,
+		Code:          CodeCorruptPayload,
+		FailedSegment: &segment,
+	})
+}
+
 func ValidationErrorResponse(c *gin.Context, message string) {
 	ErrorResponse(c, http.StatusBadRequest, message)
 }
@@ -1,18 +1,31 @@
 package main
 
 import (
+  "bytes"
+  "crypto/ecdh"
+  "crypto/ed25519"
   "encoding/hex"
+  "errors"
+  "flag"
   "fmt"
+  "io"
   "os"
   "os/user"
+  "path/filepath"
+  "strconv"
   "strings"
+  "time"
+  "unicode/utf8"
   _ "image/jpeg"
   _ "image/png"
   "github.com/fatih/color"
   "github.com/pranaykumar2/steg-go/internal/crypto"
   "github.com/pranaykumar2/steg-go/internal/steganography"
+  "github.com/pranaykumar2/steg-go/internal/thread"
   "github.com/pranaykumar2/steg-go/internal/ui"
+  "github.com/pranaykumar2/steg-go/internal/vault"
   "github.com/pranaykumar2/steg-go/pkg/exiftools"
+  "github.com/pranaykumar2/steg-go/pkg/steganalysis"
 )
 
 const (
@@ -38,22 +51,72 @@ func main() {
   case "hide":
     if err := handleHideCommand(userInterface); err != nil {
       userInterface.ShowError(fmt.Sprintf("%v", err))
-      os.Exit(1)
+      os.Exit(exitCode(err))
     }
   case "hideFile":
     if err := handleHideFileCommand(userInterface); err != nil {
       userInterface.ShowError(fmt.Sprintf("%v", err))
-      os.Exit(1)
+      os.Exit(exitCode(err))
     }
   case "extract":
     if err := handleExtractCommand(userInterface); err != nil {
       userInterface.ShowError(fmt.Sprintf("%v", err))
-      os.Exit(1)
+      os.Exit(exitCode(err))
     }
     case "metadata":
     if err := handleMetadataCommand(userInterface); err != nil {
       userInterface.ShowError(fmt.Sprintf("%v", err))
-      os.Exit(1)
+      os.Exit(exitCode(err))
+    }
+  case "keygen":
+    if err := handleKeygenCommand(userInterface); err != nil {
+      userInterface.ShowError(fmt.Sprintf("%v", err))
+      os.Exit(exitCode(err))
+    }
+  case "pubkey":
+    if err := handlePubkeyCommand(userInterface); err != nil {
+      userInterface.ShowError(fmt.Sprintf("%v", err))
+      os.Exit(exitCode(err))
+    }
+  case "trust":
+    if err := handleTrustCommand(userInterface); err != nil {
+      userInterface.ShowError(fmt.Sprintf("%v", err))
+      os.Exit(exitCode(err))
+    }
+  case "selftest":
+    if err := handleSelfTestCommand(userInterface); err != nil {
+      userInterface.ShowError(fmt.Sprintf("%v", err))
+      os.Exit(exitCode(err))
+    }
+  case "keys":
+    if err := handleKeysCommand(userInterface); err != nil {
+      userInterface.ShowError(fmt.Sprintf("%v", err))
+      os.Exit(exitCode(err))
+    }
+  case "rekey":
+    if err := handleRekeyCommand(userInterface); err != nil {
+      userInterface.ShowError(fmt.Sprintf("%v", err))
+      os.Exit(exitCode(err))
+    }
+  case "post":
+    if err := handlePostCommand(userInterface); err != nil {
+      userInterface.ShowError(fmt.Sprintf("%v", err))
+      os.Exit(exitCode(err))
+    }
+  case "vault":
+    if err := handleVaultCommand(userInterface); err != nil {
+      userInterface.ShowError(fmt.Sprintf("%v", err))
+      os.Exit(exitCode(err))
+    }
+  case "analyze":
+    if err := handleAnalyzeCommand(userInterface); err != nil {
+      userInterface.ShowError(fmt.Sprintf("%v", err))
+      os.Exit(exitCode(err))
+    }
+  case "split":
+    if err := handleSplitCommand(userInterface); err != nil {
+      userInterface.ShowError(fmt.Sprintf("%v", err))
+      os.Exit(exitCode(err))
     }
   case "info":
     showInfo(userInterface)
@@ -70,6 +133,31 @@ func main() {
   }
 }
 
+// Exit codes let scripts tell why extracting failed.
+const (
+  exitError          = 1
+  exitNoPayload      = 2
+  exitWrongKey       = 3
+  exitCorruptPayload = 4
+  exitExpired        = 5
+)
+
+var errNoContent = errors.New("no hidden content found in this image")
+
+func exitCode(err error) int {
+  switch {
+  case errors.Is(err, errNoContent), errors.Is(err, steganography.ErrNoPayload):
+    return exitNoPayload
+  case errors.Is(err, steganography.ErrCorruptPayload), errors.Is(err, crypto.ErrCorruptPayload):
+    return exitCorruptPayload
+  case errors.Is(err, crypto.ErrWrongKey):
+    return exitWrongKey
+  case errors.Is(err, crypto.ErrExpired):
+    return exitExpired
+  }
+  return exitError
+}
+
 func printUsage(ui *ui.UI) {
   ui.PrintCommandHeader("USAGE INFORMATION")
 
@@ -78,377 +166,2541 @@ func printUsage(ui *ui.UI) {
   ui.PrintFeatureList("Available Commands", []string{
     "hide        Hide a secret message in an image",
     "hideFile    Hide a file (PDF, document, audio, etc.) in an image",
+    "            --mode lsb|matrix|adaptive  embedding mode (matrix changes fewer",
+    "            pixels, adaptive only touches textured regions)",
+    "            --max-density N  adaptive mode: max share of textured slots used",
+    "            --preserve-histogram  restore the cover's value histogram afterwards",
+    "            --pad none|bucket|full  hide the payload length behind padding",
+    "            --randomize  fill unused LSBs with random bits",
+    "            --copies N|auto  lsb mode: embed redundant scattered copies",
+    "            --passphrase  encrypt with a passphrase instead of a random key",
+    "            --kdf-time N --kdf-memory MiB --kdf-threads N  Argon2id cost",
+    "            (at most 16 passes, 1024 MiB and 16 threads)",
+    "            --recipient [NAME=]KEY  encrypt to a public key (see keygen);",
+    "            repeat for several recipients, any of whom can extract",
+    "            --recipients-file PATH  read recipients from a file, one per line",
+    "            (--passphrase may be combined with recipients)",
+    "            --sign PATH  sign the payload with an Ed25519 signing key",
+    "            --cipher aes-256-gcm|chacha20-poly1305|xchacha20-poly1305",
+    "            --key-name NAME  use (or create) the named key in your keyring",
+    "            --qr  also show the generated key as a terminal QR code",
+    "            --qr-png PATH  save the generated key's QR code as a PNG",
+    "            --shares N --threshold K  print N key shares instead of the key,",
+    "            any K of which can extract",
+    "            --author NAME --comment TEXT  sealed with the content and shown on",
+    "            extract, together with when it was hidden",
+    "            --expires DATE|DURATION  refuse to extract after this date",
+    "            (YYYY-MM-DD or RFC 3339) or once this long has passed (72h, 30d)",
     "extract     Extract hidden content from an image",
+    "            --trusted PATH  trusted signers list used to check signatures",
+    "            --key-name NAME  open with the named key from your keyring",
+    "            --try-keyring  try every keyring key and report which one opens it",
+    "            --shares  rebuild the key from key shares",
+    "            --force  extract content even if it has expired",
+    "rekey       Re-encrypt the content of a stego image in place under a new key",
+    "            takes the key options of hide (--passphrase, --recipient, --key-name,",
+    "            --shares, --cipher, --sign ...); --old-key-name NAME opens it from",
+    "            the keyring, --output PATH writes a new image instead; the author,",
+    "            comment and expiry are kept unless given again, --force rekeys",
+    "            expired content",
     "metadata    Display detailed metadata from an image",
+    "analyze     Look for LSB embedding with the chi-square attack and show",
+    "            the embedding probability along the image in both scan orders",
+    "            --windows N  number of windows along each scan (default 100)",
+    "keygen      Generate an X25519 keypair for recipient encryption",
+    "            --signing  generate an Ed25519 signing key instead",
+    "pubkey      Print the public key of a private or signing key file",
+    "trust       Add a signer's public key to the trusted signers list",
+    "selftest    Check every cipher against its known-answer vectors",
+    "keys        Manage the keyring: list, add [--identity|--master] NAME,",
+    "            import [--master] NAME,",
+    "            export NAME, qr NAME [--png PATH], delete NAME, rename NAME NEW_NAME",
+    "post        Append a message to the thread in an image, or start one in a",
+    "            cover image; takes the key options of hide, and --sign makes",
+    "            the author's key ID part of the message. An existing thread",
+    "            keeps its embedding, is updated in place unless --output PATH",
+    "            is given and, without key options, asks for its credentials.",
+    "            extract lists the whole thread",
+    "vault       Keep credentials in an encrypted vault inside an image:",
+    "            init takes the embedding and key options of hide, except",
+    "            --recipient, --sign and --expires; list IMAGE, get IMAGE TITLE,",
+    "            add IMAGE [TITLE] and remove IMAGE TITLE open it with",
+    "            --key-name NAME or the key or passphrase it was made with, and",
+    "            add and remove rewrite it in place unless --output PATH is given",
+    "split       Split an existing key into shares: --shares N --threshold K",
     "info        Show information about this application",
   })
 
   ui.PrintFeatureList("Examples", []string{
     fmt.Sprintf("%s hide", os.Args[0]),
     fmt.Sprintf("%s hideFile", os.Args[0]),
+    fmt.Sprintf("%s hide --mode matrix", os.Args[0]),
+    fmt.Sprintf("%s hideFile --mode adaptive --max-density 0.3", os.Args[0]),
+    fmt.Sprintf("%s hide --pad full --randomize", os.Args[0]),
+    fmt.Sprintf("%s hide --copies auto", os.Args[0]),
+    fmt.Sprintf("%s hide --passphrase --kdf-memory 256", os.Args[0]),
+    fmt.Sprintf("%s hideFile --recipient stegpub:...", os.Args[0]),
+    fmt.Sprintf("%s hide --recipient alice=stegpub:... --recipient bob=stegpub:...", os.Args[0]),
+    fmt.Sprintf("%s keygen", os.Args[0]),
+    fmt.Sprintf("%s hide --sign signing.key", os.Args[0]),
+    fmt.Sprintf("%s hide --cipher xchacha20-poly1305", os.Args[0]),
+    fmt.Sprintf("%s hide --key-name holiday", os.Args[0]),
+    fmt.Sprintf("%s keys list", os.Args[0]),
+    fmt.Sprintf("%s keys add --master team", os.Args[0]),
+    fmt.Sprintf("%s hide --key-name team", os.Args[0]),
+    fmt.Sprintf("%s keys qr --png holiday.png holiday", os.Args[0]),
+    fmt.Sprintf("%s hide --shares 5 --threshold 3", os.Args[0]),
+    fmt.Sprintf("%s extract --shares", os.Args[0]),
+    fmt.Sprintf("%s hide --author alice --comment \"for bob\" --expires 30d", os.Args[0]),
+    fmt.Sprintf("%s extract --try-keyring", os.Args[0]),
     fmt.Sprintf("%s extract", os.Args[0]),
+    fmt.Sprintf("%s rekey --recipient stegpub:...", os.Args[0]),
+    fmt.Sprintf("%s post --passphrase --sign signing.key", os.Args[0]),
+    fmt.Sprintf("%s vault init --passphrase --pad full", os.Args[0]),
+    fmt.Sprintf("%s vault add vault.png \"mail server\"", os.Args[0]),
+    fmt.Sprintf("%s vault get vault.png \"mail server\"", os.Args[0]),
     fmt.Sprintf("%s metadata", os.Args[0]),
+    fmt.Sprintf("%s analyze --windows 50", os.Args[0]),
+  })
+
+  ui.PrintFeatureList("Exit Codes", []string{
+    "0  success",
+    "1  any other error",
+    "2  no hidden content in the image",
+    "3  the key, passphrase or private key does not open the content",
+    "4  the hidden content is damaged",
+    "5  the hidden content has expired (extract --force opens it anyway)",
   })
 }
 
-func handleMetadataCommand(ui *ui.UI) error {
-  ui.PrintCommandHeader("IMAGE METADATA ANALYSIS")
+type hideOptions struct {
+  embed      steganography.Options
+  passphrase bool
+  kdf        crypto.KDFParams
+  recipients []crypto.Recipient
+  cipher     crypto.Cipher
+  keyName    string
+  key        []byte
+  master     []byte
+  qr         bool
+  qrPath     string
+  shares     int
+  threshold  int
+  info       crypto.PayloadInfo
+}
 
-  imagePath := ui.PromptInput("Enter image path to analyze")
-  if !fileExists(imagePath) {
-    return fmt.Errorf("file does not exist: %s", imagePath)
+func parseHideOptions(flags *flag.FlagSet, args []string) (hideOptions, error) {
+  mode := flags.String("mode", "lsb", "embedding mode: lsb, matrix or adaptive")
+  maxDensity := flags.Float64("max-density", steganography.DefaultMaxDensity,
+    "adaptive mode: maximum share of textured slots carrying payload bits")
+  preserveHistogram := flags.Bool("preserve-histogram", false,
+    "flip unused LSBs afterwards to restore the cover's value histogram")
+  padding := flags.String("pad", "none", "pad the payload: none, bucket or full")
+  randomize := flags.Bool("randomize", false, "fill unused LSBs with random bits")
+  copies := flags.String("copies", "1", "lsb mode: number of redundant copies, or auto")
+  passphrase := flags.Bool("passphrase", false, "derive the encryption key from a passphrase")
+  kdfTime := flags.Uint("kdf-time", uint(crypto.DefaultKDFParams.Time), "Argon2id passes")
+  kdfMemory := flags.Uint("kdf-memory", uint(crypto.DefaultKDFParams.Memory/1024), "Argon2id memory in MiB")
+  kdfThreads := flags.Uint("kdf-threads", uint(crypto.DefaultKDFParams.Threads), "Argon2id parallelism")
+  var recipients []crypto.Recipient
+  flags.Func("recipient", "encrypt to this X25519 public key (repeatable)", func(value string) error {
+    recipient, err := crypto.ParseRecipient(value)
+    if err != nil {
+      return err
+    }
+    recipients = append(recipients, recipient)
+    return nil
+  })
+  recipientsFile := flags.String("recipients-file", "", "read recipient public keys from a file")
+  signingKeyPath := flags.String("sign", "", "sign the payload with this Ed25519 signing key file")
+  cipherName := flags.String("cipher", crypto.DefaultCipher.Name(), "payload cipher")
+  keyName := flags.String("key-name", "", "use, or generate and store, this keyring key")
+  qr := flags.Bool("qr", false, "also show the generated key as a QR code")
+  qrPath := flags.String("qr-png", "", "save the generated key's QR code as a PNG")
+  shares := flags.Int("shares", 0, "split the generated key into this many shares")
+  threshold := flags.Int("threshold", 0, "number of key shares needed to extract")
+  author := flags.String("author", "", "author label sealed with the content")
+  comment := flags.String("comment", "", "comment sealed with the content")
+  expires := flags.String("expires", "", "refuse to extract after this date or duration")
+  if err := flags.Parse(args); err != nil {
+    return hideOptions{}, err
   }
 
-  ui.StartProgress("Analyzing image metadata")
+  embedMode, err := steganography.ParseEmbedMode(*mode)
+  if err != nil {
+    return hideOptions{}, err
+  }
+  if *maxDensity <= 0 || *maxDensity > 1 {
+    return hideOptions{}, fmt.Errorf("max density must be between 0 and 1")
+  }
 
-  metadata, err := exiftools.GetImageMetadata(imagePath)
+  paddingMode, err := steganography.ParsePaddingMode(*padding)
   if err != nil {
-    ui.StopProgress()
-    return fmt.Errorf("failed to extract metadata: %v", err)
+    return hideOptions{}, err
   }
 
-  ui.StopProgress()
+  copyCount, err := parseCopies(*copies)
+  if err != nil {
+    return hideOptions{}, err
+  }
 
-  ui.PrintMetadata(metadata)
+  if *kdfTime > crypto.MaxKDFTime || *kdfMemory > crypto.MaxKDFMemory/1024 || *kdfThreads > crypto.MaxKDFThreads {
+    return hideOptions{}, fmt.Errorf("key derivation cost out of range: at most %d passes, %d MiB and %d threads",
+      crypto.MaxKDFTime, crypto.MaxKDFMemory/1024, crypto.MaxKDFThreads)
+  }
 
-  if metadata.ImageWidth > 0 && metadata.ImageHeight > 0 {
-    analyzeCapacity := ui.PromptConfirmation("Would you like a detailed steganography capacity analysis?")
-    if analyzeCapacity {
-      fmt.Println()
-      color.New(color.FgHiCyan).Println("  ┌─ Steganography Capacity Analysis ─────────────┐")
+  if *recipientsFile != "" {
+    fromFile, err := crypto.LoadRecipientsFile(*recipientsFile)
+    if err != nil {
+      return hideOptions{}, err
+    }
+    recipients = append(recipients, fromFile...)
+  }
 
-      totalPixels := metadata.ImageWidth * metadata.ImageHeight
+  if *keyName != "" && (*passphrase || len(recipients) > 0) {
+    return hideOptions{}, fmt.Errorf("--key-name cannot be combined with --passphrase or recipients")
+  }
+  if (*qr || *qrPath != "") && (*keyName != "" || *passphrase || len(recipients) > 0) {
+    return hideOptions{}, fmt.Errorf("--qr and --qr-png only apply to a generated key")
+  }
+  if *qrPath != "" && fileExists(*qrPath) {
+    return hideOptions{}, fmt.Errorf("refusing to overwrite existing file: %s", *qrPath)
+  }
+  if *shares != 0 || *threshold != 0 {
+    if *keyName != "" || *passphrase || len(recipients) > 0 {
+      return hideOptions{}, fmt.Errorf("--shares and --threshold only apply to a generated key")
+    }
+    if *qr || *qrPath != "" {
+      return hideOptions{}, fmt.Errorf("--shares cannot be combined with --qr or --qr-png")
+    }
+    if err := checkShares(*shares, *threshold); err != nil {
+      return hideOptions{}, err
+    }
+  }
 
-      color.New(color.FgCyan).Printf("  │ • Image dimensions: %d × %d pixels            │\n",
-        metadata.ImageWidth, metadata.ImageHeight)
-      color.New(color.FgCyan).Printf("  │ • Total pixels: %-33d │\n", totalPixels)
+  payloadCipher, err := crypto.ParseCipher(*cipherName)
+  if err != nil {
+    return hideOptions{}, err
+  }
 
-      lsbBytes := (totalPixels * 3) / 8
-      color.New(color.FgCyan).Printf("  │ • LSB capacity (1-bit): %-23s │\n", formatBytes(lsbBytes))
-      textChars := int(float64(lsbBytes) * 8 / 5.1)
-      color.New(color.FgCyan).Printf("  │ • Estimated text capacity: ~%-20d │\n", textChars)
-      color.New(color.FgCyan).Printf("  │   (characters)                               │\n")
-      color.New(color.FgCyan).Println("  │                                                 │")
-      color.New(color.FgCyan).Println("  │ This image could potentially store:             │")
+  var expiry time.Time
+  if *expires != "" {
+    if expiry, err = parseExpiry(*expires, time.Now()); err != nil {
+      return hideOptions{}, err
+    }
+  }
 
-      pdfPages := lsbBytes / 100000
-      if pdfPages < 1 {
-        color.New(color.FgCyan).Println("  │ • A small portion of a PDF document             │")
-      } else {
-        color.New(color.FgCyan).Printf("  │ • A PDF document of ~%d pages                  │\n", pdfPages)
-      }
+  var signer ed25519.PrivateKey
+  if *signingKeyPath != "" {
+    if signer, err = crypto.LoadSigningKeyFile(*signingKeyPath); err != nil {
+      return hideOptions{}, fmt.Errorf("failed to load signing key: %v", err)
+    }
+  }
 
-      mp3Seconds := lsbBytes / 16000
-      if mp3Seconds < 1 {
-        color.New(color.FgCyan).Println("  │ • Less than a second of MP3 audio               │")
-      } else if mp3Seconds < 60 {
-        color.New(color.FgCyan).Printf("  │ • ~%d seconds of MP3 audio                     │\n", mp3Seconds)
-      } else {
-        color.New(color.FgCyan).Printf("  │ • ~%.1f minutes of MP3 audio                   │\n", float64(mp3Seconds)/60)
-      }
+  return hideOptions{
+    embed: steganography.Options{
+      Mode:              embedMode,
+      MaxDensity:        *maxDensity,
+      PreserveHistogram: *preserveHistogram,
+      Padding:           paddingMode,
+      RandomizeUnused:   *randomize,
+      Copies:            copyCount,
+      Signer:            signer,
+    },
+    passphrase: *passphrase,
+    recipients: recipients,
+    cipher:     payloadCipher,
+    keyName:    *keyName,
+    qr:         *qr,
+    qrPath:     *qrPath,
+    shares:     *shares,
+    threshold:  *threshold,
+    info:       crypto.PayloadInfo{Author: *author, Comment: *comment, Expires: expiry},
+    kdf: crypto.KDFParams{
+      Time:    uint32(*kdfTime),
+      Memory:  uint32(*kdfMemory) * 1024,
+      Threads: uint8(*kdfThreads),
+    },
+  }, nil
+}
 
-      if lsbBytes > 50000 {
-        color.New(color.FgCyan).Println("  │ • A small JPG image                             │")
-      }
+// promptNewPassphrase asks for the passphrase twice and shows its estimated
+// strength, asking before going ahead with a weak one.
+func promptNewPassphrase(ui *ui.UI) ([]byte, error) {
+  passphrase := ui.PromptSecret("Enter passphrase")
+  if len(passphrase) == 0 {
+    return nil, fmt.Errorf("passphrase cannot be empty")
+  }
+  confirmation := ui.PromptSecret("Confirm passphrase")
+  defer crypto.Wipe(confirmation)
+  if !bytes.Equal(confirmation, passphrase) {
+    crypto.Wipe(passphrase)
+    return nil, fmt.Errorf("passphrases do not match")
+  }
 
-      color.New(color.FgHiCyan).Println("  └─────────────────────────────────────────────┘")
+  bits, label := crypto.PassphraseStrength(string(passphrase))
+  message := fmt.Sprintf("Passphrase strength: %s (~%.0f bits)", label, bits)
+  if label == "weak" {
+    ui.ShowWarning(message)
+    if !ui.PromptConfirmation("Continue with a weak passphrase?") {
+      crypto.Wipe(passphrase)
+      return nil, fmt.Errorf("aborted: choose a stronger passphrase")
+    }
+  } else {
+    ui.ShowInfo(message)
+  }
 
-      fmt.Println()
-      color.New(color.FgYellow).Println("  Note: Actual capacity may be lower due to overhead")
-      color.New(color.FgYellow).Println("  and limitations of the steganography algorithm.")
+  return passphrase, nil
+}
+
+func newHideEncryptor(passphrase []byte, options hideOptions) (*crypto.Encryptor, error) {
+  var encryptor *crypto.Encryptor
+  var err error
+  switch {
+  case len(options.recipients) > 0:
+    keys := make([]*ecdh.PublicKey, len(options.recipients))
+    for i, recipient := range options.recipients {
+      keys[i] = recipient.Key
     }
+
+    encryptor, err = crypto.NewEncryptorForRecipients(keys...)
+    if err == nil && passphrase != nil {
+      err = encryptor.AddPassphrase(passphrase, options.kdf)
+    }
+  case passphrase != nil:
+    encryptor, err = crypto.NewEncryptorWithPassphrase(passphrase, options.kdf)
+  case options.key != nil:
+    encryptor, err = crypto.NewEncryptorWithKey(options.key)
+  case options.master != nil:
+    encryptor, err = crypto.NewEncryptorWithMaster(options.master)
+  default:
+    encryptor, err = crypto.NewEncryptor()
+  }
+  if err != nil {
+    return nil, err
   }
 
-  return nil
+  encryptor.SetCipher(options.cipher)
+  if err := encryptor.SetInfo(payloadInfo(options, nil)); err != nil {
+    return nil, err
+  }
+  return encryptor, nil
 }
 
-func formatBytes(bytes int) string {
-  if bytes >= 1048576 {
-    return fmt.Sprintf("%.2f MB", float64(bytes)/1048576)
-  } else if bytes >= 1024 {
-    return fmt.Sprintf("%.2f KB", float64(bytes)/1024)
-  } else {
-    return fmt.Sprintf("%d bytes", bytes)
+// payloadInfo is the info sealed with a new payload: the author, comment
+// and expiry given as flags over those of the payload it replaces, if any.
+func payloadInfo(options hideOptions, previous *crypto.PayloadInfo) *crypto.PayloadInfo {
+  info := crypto.PayloadInfo{Created: time.Now()}
+  if previous != nil {
+    info = *previous
+  }
+  if options.info.Author != "" {
+    info.Author = options.info.Author
+  }
+  if options.info.Comment != "" {
+    info.Comment = options.info.Comment
   }
+  if !options.info.Expires.IsZero() {
+    info.Expires = options.info.Expires
+  }
+  return &info
 }
 
+// parseExpiry reads an expiry as a duration from now, with d for days, a
+// date, which expires at the end of that day, or an RFC 3339 time.
+func parseExpiry(value string, now time.Time) (time.Time, error) {
+  var expiry time.Time
+  if days, ok := strings.CutSuffix(value, "d"); ok {
+    if n, err := strconv.Atoi(days); err == nil {
+      expiry = now.AddDate(0, 0, n)
+    }
+  } else if duration, err := time.ParseDuration(value); err == nil {
+    expiry = now.Add(duration)
+  } else if date, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+    expiry = date.AddDate(0, 0, 1)
+  } else if t, err := time.Parse(time.RFC3339, value); err == nil {
+    expiry = t
+  }
 
-func handleHideCommand(ui *ui.UI) error {
-  ui.PrintCommandHeader("HIDE TEXT MESSAGE")
+  if expiry.IsZero() {
+    return time.Time{}, fmt.Errorf("invalid expiry %q: use a date (YYYY-MM-DD), an RFC 3339 time or a duration (72h, 30d)", value)
+  }
+  if !expiry.After(now) {
+    return time.Time{}, fmt.Errorf("expiry %s is not in the future", expiry.Format(time.RFC3339))
+  }
+  return expiry, nil
+}
 
-  inputPath := ui.PromptInput("Enter input image path (PNG or JPG)")
-  if !fileExists(inputPath) {
-    return fmt.Errorf("input file does not exist: %s", inputPath)
+// checkExpiry refuses expired content unless force is set, and warns when
+// it is.
+func checkExpiry(ui *ui.UI, info *crypto.PayloadInfo, force bool) error {
+  if info == nil || !info.Expired(time.Now()) {
+    return nil
+  }
+  expired := info.Expires.Local().Format("2006-01-02 15:04 MST")
+  if !force {
+    return fmt.Errorf("%w on %s; use --force to open it anyway", crypto.ErrExpired, expired)
   }
+  ui.ShowWarning(fmt.Sprintf("The content expired on %s; opening it anyway because of --force", expired))
+  return nil
+}
 
-  outputPath := ui.PromptInput("Enter output image path (will be saved as PNG)")
-  if !strings.HasSuffix(strings.ToLower(outputPath), ".png") {
-    outputPath += ".png"
+// describeInfo adds the details of a payload's info block.
+func describeInfo(details map[string]string, info *crypto.PayloadInfo) {
+  if info == nil {
+    return
+  }
+  if !info.Created.IsZero() {
+    details["Created"] = info.Created.Local().Format("2006-01-02 15:04 MST")
+  }
+  if info.Author != "" {
+    details["Author"] = info.Author
+  }
+  if info.Comment != "" {
+    details["Comment"] = info.Comment
   }
+  if !info.Expires.IsZero() {
+    details["Expires"] = info.Expires.Local().Format("2006-01-02 15:04 MST")
+  }
+}
 
-  message := ui.PromptInput("Enter the secret message")
-  message = strings.TrimSpace(message)
-  if message == "" {
-    return fmt.Errorf("message cannot be empty")
+// openKeyring asks for the keyring passphrase, or for a new one when the
+// keyring does not exist yet and create is set.
+func openKeyring(ui *ui.UI, create bool) (*crypto.Keyring, error) {
+  path := crypto.DefaultKeyringPath()
+  if fileExists(path) {
+    return crypto.OpenKeyring(path, ui.PromptSecret("Enter keyring passphrase"))
+  }
+  if !create {
+    return nil, fmt.Errorf("no keyring found at %s", path)
   }
 
-  ui.StartProgress("Initializing encoder")
-  encoder, err := steganography.NewEncoder(inputPath)
+  ui.ShowInfo(fmt.Sprintf("Creating a new keyring at %s", path))
+  passphrase, err := promptNewPassphrase(ui)
   if err != nil {
-    ui.StopProgress()
-    return fmt.Errorf("failed to initialize encoder: %v", err)
+    return nil, err
   }
+  return crypto.OpenKeyring(path, passphrase)
+}
 
-  ui.UpdateProgress("Generating encryption key")
-  encryptor, err := crypto.NewEncryptor()
-  if err != nil {
-    ui.StopProgress()
-    return fmt.Errorf("failed to initialize encryption: %v", err)
+// useKeyName points the options at the --key-name key, generating and
+// adding a new key under that name if the keyring has none. A keyring
+// private key is used by encrypting to its public key.
+func useKeyName(ui *ui.UI, options *hideOptions) (*crypto.Keyring, error) {
+  if options.keyName == "" {
+    return nil, nil
   }
 
-  ui.UpdateProgress("Encrypting message")
-  encrypted, err := encryptor.Encrypt([]byte(message))
+  keyring, err := openKeyring(ui, true)
   if err != nil {
-    ui.StopProgress()
-    return fmt.Errorf("failed to encrypt message: %v", err)
+    return nil, err
   }
 
-  ui.UpdateProgress("Hiding message in image")
-  if err := encoder.Hide(encrypted); err != nil {
-    ui.StopProgress()
-    return fmt.Errorf("failed to hide message: %v", err)
+  entry := keyring.Find(options.keyName)
+  if entry == nil {
+    generated, err := crypto.NewEncryptor()
+    if err != nil {
+      return nil, err
+    }
+    entry = crypto.NewKeyringKey(options.keyName, generated.GetKey())
+    generated.Destroy()
+    if err := keyring.Add(entry); err != nil {
+      return nil, err
+    }
+    ui.ShowInfo(fmt.Sprintf("Generated a new key %q", options.keyName))
   }
 
-  ui.UpdateProgress("Saving output image")
-  if err := encoder.SaveOutput(outputPath); err != nil {
-    ui.StopProgress()
-    return fmt.Errorf("failed to save output image: %v", err)
+  switch entry.Type {
+  case crypto.KeyringIdentity:
+    identity, err := entry.Identity()
+    if err != nil {
+      return nil, err
+    }
+    options.recipients = []crypto.Recipient{{Name: entry.Name, Key: identity.PublicKey()}}
+  case crypto.KeyringMaster:
+    options.master = bytes.Clone(entry.Key)
+  default:
+    options.key = bytes.Clone(entry.Key)
   }
-  ui.StopProgress()
+  return keyring, nil
+}
 
-  details := map[string]string{
-    "Input Image": inputPath,
-    "Output Image": outputPath,
-    "Message Length": fmt.Sprintf("%d characters", len(message)),
-    "Encrypted Size": fmt.Sprintf("%.2f KB", float64(len(encrypted))/1024),
+// wipeKeys clears the key useKeyName copied from the keyring, once the
+// encryptor has its own copy.
+func (o *hideOptions) wipeKeys() {
+  crypto.Wipe(o.key)
+  crypto.Wipe(o.master)
+}
+
+// noteKeyringImage records the image against the --key-name key and saves
+// the keyring.
+func noteKeyringImage(keyring *crypto.Keyring, name, imagePath string) error {
+  if entry := keyring.Find(name); entry != nil {
+    entry.NoteImage(imagePath)
   }
-  ui.PrintDataDetails(details)
+  return keyring.Save()
+}
 
-  ui.ShowSuccess("Message hidden successfully in the image")
-  ui.PrintKeyBox(hex.EncodeToString(encryptor.GetKey()))
+// printKey shows a new key as hex, then as Base58Check and recovery words.
+func printKey(ui *ui.UI, key []byte) {
+  ui.PrintKeyBox(hex.EncodeToString(key))
+  if mnemonic, err := crypto.EncodeKeyMnemonic(key); err == nil {
+    ui.PrintKeyEncodings(crypto.EncodeKeyBase58(key), strings.Fields(mnemonic))
+  }
+}
+
+// showExtractCredentials tells the user what will extract the content they
+// just hid, printing the key when it is a new random one.
+func showExtractCredentials(ui *ui.UI, options hideOptions, encryptor *crypto.Encryptor, keyring *crypto.Keyring, keyringErr error) {
+  if keyringErr != nil {
+    ui.ShowWarning(fmt.Sprintf("Failed to update the keyring: %v", keyringErr))
+  }
+  if keyring != nil && keyringErr == nil {
+    ui.ShowInfo(fmt.Sprintf("Extract with --key-name %s; the key is kept in %s", options.keyName, keyring.Path()))
+  } else if encryptor.UsesPassphrase() {
+    ui.ShowInfo("Use your passphrase to extract the hidden content")
+  } else if encryptor.UsesRecipients() {
+    ui.ShowInfo("Any listed recipient's private key can extract the hidden content")
+  } else if options.shares > 0 {
+    if err := printKeyShares(ui, encryptor.GetKey(), options.shares, options.threshold); err != nil {
+      ui.ShowWarning(fmt.Sprintf("Failed to split the key, so here it is whole: %v", err))
+      printKey(ui, encryptor.GetKey())
+    }
+  } else {
+    printKey(ui, encryptor.GetKey())
+    if err := showKeyQR(ui, options, crypto.EncodeKeyBase58(encryptor.GetKey())); err != nil {
+      ui.ShowWarning(fmt.Sprintf("Failed to create the QR code: %v", err))
+    }
+  }
+}
 
+func checkShares(shares, threshold int) error {
+  if threshold < 2 || shares < threshold || shares > crypto.MaxKeyShares {
+    return fmt.Errorf("--threshold must be at least 2 and --shares between it and %d", crypto.MaxKeyShares)
+  }
   return nil
 }
 
-func handleHideFileCommand(ui *ui.UI) error {
-  ui.PrintCommandHeader("HIDE FILE IN IMAGE")
+// printKeyShares splits a key and shows each share, in place of the key.
+func printKeyShares(ui *ui.UI, key []byte, shares, threshold int) error {
+  split, err := crypto.SplitKey(key, shares, threshold)
+  if err != nil {
+    return err
+  }
+  for _, share := range split {
+    ui.PrintKeyShare(fmt.Sprintf("KEY SHARE %d OF %d", share.Index, shares), share.Hex(), strings.Fields(share.Mnemonic()))
+  }
+  ui.ShowInfo(fmt.Sprintf("Give each share to a different custodian; any %d of them can extract with --shares", threshold))
+  return nil
+}
 
-  // Collect input information
-  inputPath := ui.PromptInput("Enter input image path (PNG or JPG)")
-  if !fileExists(inputPath) {
-    return fmt.Errorf("input file does not exist: %s", inputPath)
+// promptKeyShares asks for shares until the threshold recorded in the first
+// is reached and rebuilds the key from them.
+func promptKeyShares(ui *ui.UI) ([]byte, error) {
+  var shares []crypto.KeyShare
+  for len(shares) == 0 || len(shares) < shares[0].Threshold {
+    prompt := "Enter key share 1 (hex or words)"
+    if len(shares) > 0 {
+      prompt = fmt.Sprintf("Enter key share %d of %d", len(shares)+1, shares[0].Threshold)
+    }
+    share, err := crypto.ParseKeyShare(ui.PromptInput(prompt))
+    if err != nil {
+      return nil, fmt.Errorf("key share %d: %v", len(shares)+1, err)
+    }
+    shares = append(shares, share)
   }
+  return crypto.CombineKeyShares(shares)
+}
 
-  outputPath := ui.PromptInput("Enter output image path (will be saved as PNG)")
-  if !strings.HasSuffix(strings.ToLower(outputPath), ".png") {
-    outputPath += ".png"
+// showKeyQR shows the QR code and saves it as requested by --qr and
+// --qr-png.
+func showKeyQR(ui *ui.UI, options hideOptions, content string) error {
+  if options.qr {
+    if err := ui.PrintQRCode("Scan to copy the key", content); err != nil {
+      return err
+    }
+  }
+  if options.qrPath != "" {
+    if err := ui.WriteQRCodePNG(content, options.qrPath); err != nil {
+      return err
+    }
+    ui.ShowInfo(fmt.Sprintf("QR code saved to: %s", options.qrPath))
   }
+  return nil
+}
 
-  filePath := ui.PromptInput("Enter path to the file you want to hide")
-  if !fileExists(filePath) {
-    return fmt.Errorf("file does not exist: %s", filePath)
+func describeRecipients(recipients []crypto.Recipient) string {
+  names := make([]string, len(recipients))
+  for i, recipient := range recipients {
+    names[i] = recipient.String()
   }
+  return strings.Join(names, ", ")
+}
 
-  ui.StartProgress("Checking file compatibility")
-  fileHandler := steganography.NewFileHandler()
-  supported, ext := fileHandler.IsFileSupported(filePath)
-  if !supported {
-    ui.ShowWarning(fmt.Sprintf("File type %s is not in the standard supported list, but we'll try anyway", ext))
+func describeKDF(params crypto.KDFParams) string {
+  return fmt.Sprintf("Argon2id (t=%d, m=%d MiB, p=%d)", params.Time, params.Memory/1024, params.Threads)
+}
+
+func promptPassphraseDecryptor(ui *ui.UI) (*crypto.Encryptor, error) {
+  passphrase := ui.PromptSecret("Enter passphrase")
+  defer crypto.Wipe(passphrase)
+  if len(passphrase) == 0 {
+    return nil, fmt.Errorf("passphrase cannot be empty")
   }
+  return crypto.NewEncryptorWithPassphrase(passphrase, crypto.DefaultKDFParams)
+}
 
-  ui.UpdateProgress("Reading file data")
-  fileData, metadata, err := fileHandler.ReadFileContent(filePath)
-  if err != nil {
-    ui.StopProgress()
-    return fmt.Errorf("failed to read file: %v", err)
+// promptDecryptor asks for whatever the extracted data was sealed with: a
+// passphrase, a private key file or the key printed when it was hidden.
+func promptDecryptor(ui *ui.UI, data []byte) (*crypto.Encryptor, error) {
+  if crypto.NeedsIdentity(data) {
+    prompt := "Enter path to your private key file"
+    if crypto.NeedsPassphrase(data) {
+      prompt += " (or press Enter to use the passphrase)"
+    }
+
+    identityPath := ui.PromptInput(prompt)
+    if identityPath == "" && crypto.NeedsPassphrase(data) {
+      return promptPassphraseDecryptor(ui)
+    }
+    identity, err := crypto.LoadIdentityFile(identityPath)
+    if err != nil {
+      return nil, fmt.Errorf("failed to load private key: %v", err)
+    }
+    return crypto.NewEncryptorWithIdentity(identity)
   }
 
-  ui.UpdateProgress("Initializing encoder")
-  encoder, err := steganography.NewEncoder(inputPath)
-  if err != nil {
-    ui.StopProgress()
-    return fmt.Errorf("failed to initialize encoder: %v", err)
+  if crypto.NeedsPassphrase(data) {
+    return promptPassphraseDecryptor(ui)
   }
 
-  ui.UpdateProgress("Generating encryption key")
-  encryptor, err := crypto.NewEncryptor()
-  if err != nil {
-    ui.StopProgress()
-    return fmt.Errorf("failed to initialize encryption: %v", err)
+  if crypto.NeedsMaster(data) {
+    master, err := crypto.ParseKey(ui.PromptInput("Enter the team master key (hex, Base58 or recovery words)"))
+    if err != nil {
+      return nil, err
+    }
+    defer crypto.Wipe(master)
+    return crypto.NewEncryptorWithMaster(master)
   }
 
-  ui.UpdateProgress("Encrypting file data")
-  encrypted, err := encryptor.Encrypt(fileData)
+  key, err := crypto.ParseKey(ui.PromptInput("Enter encryption key (hex, Base58 or recovery words)"))
   if err != nil {
-    ui.StopProgress()
-    return fmt.Errorf("failed to encrypt file data: %v", err)
+    return nil, err
   }
+  defer crypto.Wipe(key)
+  return crypto.NewEncryptorWithKey(key)
+}
 
-  ui.UpdateProgress("Hiding file in image")
-  if err := encoder.HideFile(encrypted, metadata); err != nil {
-    ui.StopProgress()
-    return fmt.Errorf("failed to hide file: %v", err)
+func parseCopies(value string) (int, error) {
+  if strings.ToLower(value) == "auto" {
+    return steganography.AutoCopies, nil
   }
 
-  ui.UpdateProgress("Saving output image")
-  if err := encoder.SaveOutput(outputPath); err != nil {
-    ui.StopProgress()
-    return fmt.Errorf("failed to save output image: %v", err)
+  copies, err := strconv.Atoi(value)
+  if err != nil || copies < 1 || copies > 255 {
+    return 0, fmt.Errorf("copies must be auto or a number between 1 and 255")
   }
-  ui.StopProgress()
+  return copies, nil
+}
 
+func padPlaintext(encoder *steganography.Encoder, encryptor *crypto.Encryptor, data []byte) ([]byte, error) {
+  size, err := encoder.PaddedSize(len(data), encryptor.Overhead)
+  if err != nil || size == len(data) {
+    return data, err
+  }
+  return steganography.Pad(data, size)
+}
+
+// hideFileStream pipes the encrypted file straight into the image, so
+// neither the file nor its ciphertext is held in memory in full.
+func hideFileStream(encoder *steganography.Encoder, encryptor *crypto.Encryptor, plaintext io.Reader, size int) error {
+  reader, writer := io.Pipe()
+  go func() {
+    writer.CloseWithError(encryptor.EncryptStream(writer, plaintext))
+  }()
+
+  err := encoder.HideFileStream(reader, size)
+  reader.CloseWithError(err)
+  return err
+}
+
+func describeEmbedding(header *steganography.Header) string {
+  switch header.Mode {
+  case steganography.ModeMatrix:
+    return fmt.Sprintf("Matrix (Hamming k=%d)", header.Param)
+  case steganography.ModeAdaptive:
+    return fmt.Sprintf("Adaptive (texture >= %d)", header.Param)
+  default:
+    return "LSB"
+  }
+}
+
+func handleKeygenCommand(ui *ui.UI) error {
+  flags := flag.NewFlagSet("keygen", flag.ContinueOnError)
+  signing := flags.Bool("signing", false, "generate an Ed25519 signing key")
+  if err := flags.Parse(os.Args[2:]); err != nil {
+    return err
+  }
+
+  ui.PrintCommandHeader("GENERATE KEYPAIR")
+
+  defaultPath := "steg-identity.key"
+  if *signing {
+    defaultPath = "steg-signing.key"
+  }
+
+  outputPath := ui.PromptInput(fmt.Sprintf("Enter path to save the private key (default: %s)", defaultPath))
+  if outputPath == "" {
+    outputPath = defaultPath
+  }
+  if fileExists(outputPath) {
+    return fmt.Errorf("refusing to overwrite existing file: %s", outputPath)
+  }
+
+  if *signing {
+    key, err := crypto.GenerateSigningKey()
+    if err != nil {
+      return fmt.Errorf("failed to generate signing key: %v", err)
+    }
+    if err := crypto.WriteSigningKeyFile(outputPath, key); err != nil {
+      return fmt.Errorf("failed to save signing key: %v", err)
+    }
+
+    ui.ShowSuccess(fmt.Sprintf("Signing key saved to: %s", outputPath))
+    ui.ShowWarning("Keep the signing key secret; anyone holding it can sign images as you")
+    ui.PrintSigningKeyBox(crypto.EncodeSigningPublicKey(key.Public().(ed25519.PublicKey)))
+    return nil
+  }
+
+  identity, err := crypto.GenerateIdentity()
+  if err != nil {
+    return fmt.Errorf("failed to generate keypair: %v", err)
+  }
+  if err := crypto.WriteIdentityFile(outputPath, identity); err != nil {
+    return fmt.Errorf("failed to save private key: %v", err)
+  }
+
+  ui.ShowSuccess(fmt.Sprintf("Private key saved to: %s", outputPath))
+  ui.ShowWarning("Keep the private key secret; anyone holding it can extract content hidden for you")
+  ui.PrintPublicKeyBox(crypto.EncodePublicKey(identity.PublicKey()))
+
+  return nil
+}
+
+func handlePubkeyCommand(ui *ui.UI) error {
+  ui.PrintCommandHeader("EXPORT PUBLIC KEY")
+
+  keyPath := ui.PromptInput("Enter path to the private key file")
+  if signingKey, err := crypto.LoadSigningKeyFile(keyPath); err == nil {
+    ui.PrintSigningKeyBox(crypto.EncodeSigningPublicKey(signingKey.Public().(ed25519.PublicKey)))
+    return nil
+  }
+
+  identity, err := crypto.LoadIdentityFile(keyPath)
+  if err != nil {
+    return fmt.Errorf("failed to load private key: %v", err)
+  }
+
+  ui.PrintPublicKeyBox(crypto.EncodePublicKey(identity.PublicKey()))
+  return nil
+}
+
+func handleSelfTestCommand(ui *ui.UI) error {
+  ui.PrintCommandHeader("CIPHER SELF-TEST")
+
+  failed := 0
+  for _, c := range crypto.Ciphers {
+    if err := crypto.SelfTest(c); err != nil {
+      ui.ShowError(err.Error())
+      failed++
+      continue
+    }
+    ui.ShowSuccess(fmt.Sprintf("%s matches its known-answer vectors", c.Name()))
+  }
+
+  if failed > 0 {
+    return fmt.Errorf("%d of %d ciphers failed the self-test", failed, len(crypto.Ciphers))
+  }
+  return nil
+}
+
+func handleTrustCommand(ui *ui.UI) error {
+  ui.PrintCommandHeader("TRUST SIGNER")
+
+  name := ui.PromptInput("Enter a name for the signer")
+  key, err := crypto.ParseSigningPublicKey(ui.PromptInput("Enter the signer's public key (stegsign:...)"))
+  if err != nil {
+    return err
+  }
+
+  path := crypto.DefaultTrustedSignersPath()
+  if err := crypto.AddTrustedSigner(path, name, key); err != nil {
+    return fmt.Errorf("failed to update trusted signers: %v", err)
+  }
+
+  ui.ShowSuccess(fmt.Sprintf("Added %s (key ID %x) to %s", name, crypto.SigningKeyID(key), path))
+  return nil
+}
+
+func handleSplitCommand(ui *ui.UI) error {
+  flags := flag.NewFlagSet("split", flag.ContinueOnError)
+  shares := flags.Int("shares", 0, "number of shares to create")
+  threshold := flags.Int("threshold", 0, "number of shares needed to rebuild the key")
+  if err := flags.Parse(os.Args[2:]); err != nil {
+    return err
+  }
+  if err := checkShares(*shares, *threshold); err != nil {
+    return err
+  }
+
+  ui.PrintCommandHeader("SPLIT ENCRYPTION KEY")
+
+  key, err := crypto.ParseKey(ui.PromptInput("Enter encryption key (hex, Base58 or recovery words)"))
+  if err != nil {
+    return err
+  }
+  defer crypto.Wipe(key)
+  return printKeyShares(ui, key, *shares, *threshold)
+}
+
+func handleKeysCommand(ui *ui.UI) error {
+  if len(os.Args) < 3 {
+    return fmt.Errorf("keys needs an action: list, add, import, export, qr, delete or rename")
+  }
+  action := os.Args[2]
+
+  flags := flag.NewFlagSet("keys "+action, flag.ContinueOnError)
+  identity := flags.Bool("identity", false, "add: generate an X25519 keypair instead of an encryption key")
+  master := flags.Bool("master", false, "add, import: a team master key that derives a key for every image")
+  qrPath := flags.String("png", "", "qr: also save the QR code as a PNG")
+  if err := flags.Parse(os.Args[3:]); err != nil {
+    return err
+  }
+  args := flags.Args()
+  arg := func(i int, prompt string) string {
+    if i < len(args) {
+      return args[i]
+    }
+    return ui.PromptInput(prompt)
+  }
+
+  ui.PrintCommandHeader("KEYRING")
+
+  switch action {
+  case "list":
+    keyring, err := openKeyring(ui, false)
+    if err != nil {
+      return err
+    }
+    defer keyring.Wipe()
+    if len(keyring.Entries) == 0 {
+      ui.ShowInfo("The keyring is empty")
+      return nil
+    }
+
+    var lines []string
+    for _, entry := range keyring.Entries {
+      lines = append(lines, fmt.Sprintf("%-16s %-8s created %s, used for %d image(s)",
+        entry.Name, entry.Type, entry.Created.Format("2006-01-02"), len(entry.Images)))
+      for _, image := range entry.Images {
+        lines = append(lines, "    "+image)
+      }
+    }
+    ui.PrintFeatureList(fmt.Sprintf("Keys in %s", keyring.Path()), lines)
+    return nil
+
+  case "add":
+    name := arg(0, "Enter a name for the new key")
+    keyring, err := openKeyring(ui, true)
+    if err != nil {
+      return err
+    }
+    defer keyring.Wipe()
+
+    var entry *crypto.KeyringEntry
+    if *identity {
+      privateKey, err := crypto.GenerateIdentity()
+      if err != nil {
+        return fmt.Errorf("failed to generate keypair: %v", err)
+      }
+      entry = crypto.NewKeyringIdentity(name, privateKey)
+    } else if *master {
+      generated, err := crypto.NewEncryptor()
+      if err != nil {
+        return fmt.Errorf("failed to generate key: %v", err)
+      }
+      entry = crypto.NewKeyringMaster(name, generated.GetKey())
+      generated.Destroy()
+    } else {
+      generated, err := crypto.NewEncryptor()
+      if err != nil {
+        return fmt.Errorf("failed to generate key: %v", err)
+      }
+      entry = crypto.NewKeyringKey(name, generated.GetKey())
+      generated.Destroy()
+    }
+    if err := keyring.Add(entry); err != nil {
+      return err
+    }
+    if err := keyring.Save(); err != nil {
+      return fmt.Errorf("failed to save keyring: %v", err)
+    }
+
+    ui.ShowSuccess(fmt.Sprintf("Added %s %q to the keyring", entry.Type, name))
+    if *identity {
+      privateKey, _ := entry.Identity()
+      ui.PrintPublicKeyBox(crypto.EncodePublicKey(privateKey.PublicKey()))
+    }
+    return nil
+
+  case "import":
+    name := arg(0, "Enter a name for the key")
+    value := ""
+    if len(args) > 1 {
+      value = args[1]
+    } else {
+      value = ui.PromptPassword("Enter the key (hex, Base58, recovery words, stegsec:... or a private key file)")
+    }
+
+    var entry *crypto.KeyringEntry
+    if fileExists(value) {
+      privateKey, err := crypto.LoadIdentityFile(value)
+      if err != nil {
+        return fmt.Errorf("failed to load private key: %v", err)
+      }
+      entry = crypto.NewKeyringIdentity(name, privateKey)
+    } else {
+      var err error
+      if entry, err = crypto.ParseKeyringEntry(name, value); err != nil {
+        return err
+      }
+    }
+    if *master {
+      if entry.Type != crypto.KeyringKey {
+        return fmt.Errorf("a private key cannot be used as a master key")
+      }
+      entry.Type = crypto.KeyringMaster
+    }
+
+    keyring, err := openKeyring(ui, true)
+    if err != nil {
+      return err
+    }
+    defer keyring.Wipe()
+    if err := keyring.Add(entry); err != nil {
+      return err
+    }
+    if err := keyring.Save(); err != nil {
+      return fmt.Errorf("failed to save keyring: %v", err)
+    }
+    ui.ShowSuccess(fmt.Sprintf("Imported %s %q into the keyring", entry.Type, name))
+    return nil
+
+  case "export":
+    name := arg(0, "Enter the name of the key")
+    keyring, err := openKeyring(ui, false)
+    if err != nil {
+      return err
+    }
+    defer keyring.Wipe()
+    entry := keyring.Find(name)
+    if entry == nil {
+      return fmt.Errorf("no key named %q", name)
+    }
+
+    if entry.Type == crypto.KeyringIdentity {
+      privateKey, err := entry.Identity()
+      if err != nil {
+        return err
+      }
+      ui.PrintPrivateKeyBox(crypto.EncodeIdentity(privateKey))
+      ui.PrintPublicKeyBox(crypto.EncodePublicKey(privateKey.PublicKey()))
+      return nil
+    }
+    printKey(ui, entry.Key)
+    return nil
+
+  case "qr":
+    name := arg(0, "Enter the name of the key")
+    keyring, err := openKeyring(ui, false)
+    if err != nil {
+      return err
+    }
+    defer keyring.Wipe()
+    entry := keyring.Find(name)
+    if entry == nil {
+      return fmt.Errorf("no key named %q", name)
+    }
+
+    var content string
+    if entry.Type == crypto.KeyringIdentity {
+      privateKey, err := entry.Identity()
+      if err != nil {
+        return err
+      }
+      content = crypto.EncodeIdentity(privateKey)
+    } else {
+      content = crypto.EncodeKeyBase58(entry.Key)
+    }
+
+    ui.ShowWarning("Anyone who scans this code can extract content hidden with the key")
+    return showKeyQR(ui, hideOptions{qr: true, qrPath: *qrPath}, content)
+
+  case "delete":
+    name := arg(0, "Enter the name of the key to delete")
+    keyring, err := openKeyring(ui, false)
+    if err != nil {
+      return err
+    }
+    defer keyring.Wipe()
+    if keyring.Find(name) == nil {
+      return fmt.Errorf("no key named %q", name)
+    }
+    if !ui.PromptConfirmation(fmt.Sprintf("Delete %q? Images hidden with it cannot be extracted without a copy", name)) {
+      return fmt.Errorf("aborted")
+    }
+    if err := keyring.Delete(name); err != nil {
+      return err
+    }
+    if err := keyring.Save(); err != nil {
+      return fmt.Errorf("failed to save keyring: %v", err)
+    }
+    ui.ShowSuccess(fmt.Sprintf("Deleted %q from the keyring", name))
+    return nil
+
+  case "rename":
+    name := arg(0, "Enter the name of the key to rename")
+    newName := arg(1, "Enter the new name")
+    keyring, err := openKeyring(ui, false)
+    if err != nil {
+      return err
+    }
+    defer keyring.Wipe()
+    if err := keyring.Rename(name, newName); err != nil {
+      return err
+    }
+    if err := keyring.Save(); err != nil {
+      return fmt.Errorf("failed to save keyring: %v", err)
+    }
+    ui.ShowSuccess(fmt.Sprintf("Renamed %q to %q", name, newName))
+    return nil
+  }
+
+  return fmt.Errorf("unknown keys action: %s", action)
+}
+
+// describeSignature checks the signature of the last extracted body against
+// the trusted signers list.
+func describeSignature(decoder *steganography.Decoder, trusted []crypto.TrustedSigner) (crypto.SignatureStatus, string) {
+  signature := decoder.Signature()
+  if signature == nil {
+    return crypto.SignatureUnsigned, "unsigned"
+  }
+
+  return describeSigner(trusted, signature.KeyID, signature.Message, signature.Value)
+}
+
+func describeSigner(trusted []crypto.TrustedSigner, keyID, message, value []byte) (crypto.SignatureStatus, string) {
+  status, name := crypto.VerifySignature(trusted, keyID, message, value)
+  switch status {
+  case crypto.SignatureVerified:
+    return status, fmt.Sprintf("verified (%s)", name)
+  case crypto.SignatureInvalid:
+    return status, fmt.Sprintf("INVALID (claims to be %s)", name)
+  default:
+    return status, fmt.Sprintf("unverified (unknown key ID %x)", keyID)
+  }
+}
+
+func handleMetadataCommand(ui *ui.UI) error {
+  ui.PrintCommandHeader("IMAGE METADATA ANALYSIS")
+
+  imagePath := ui.PromptInput("Enter image path to analyze")
+  if !fileExists(imagePath) {
+    return fmt.Errorf("file does not exist: %s", imagePath)
+  }
+
+  ui.StartProgress("Analyzing image metadata")
+
+  metadata, err := exiftools.GetImageMetadata(imagePath)
+  if err != nil {
+    ui.StopProgress()
+    return fmt.Errorf("failed to extract metadata: %v", err)
+  }
+
+  ui.StopProgress()
+
+  ui.PrintMetadata(metadata)
+
+  if metadata.ImageWidth > 0 && metadata.ImageHeight > 0 {
+    analyzeCapacity := ui.PromptConfirmation("Would you like a detailed steganography capacity analysis?")
+    if analyzeCapacity {
+      fmt.Println()
+      color.New(color.FgHiCyan).Println("  ┌─ Steganography Capacity Analysis ─────────────┐")
+
+      totalPixels := metadata.ImageWidth * metadata.ImageHeight
+
+      color.New(color.FgCyan).Printf("  │ • Image dimensions: %d × %d pixels            │\n",
+        metadata.ImageWidth, metadata.ImageHeight)
+      color.New(color.FgCyan).Printf("  │ • Total pixels: %-33d │\n", totalPixels)
+
+      lsbBytes := (totalPixels * 3) / 8
+      color.New(color.FgCyan).Printf("  │ • LSB capacity (1-bit): %-23s │\n", formatBytes(lsbBytes))
+      if capacity, err := steganography.EstimateCapacity(imagePath); err == nil {
+        color.New(color.FgCyan).Printf("  │ • Adaptive capacity:    %-23s │\n", formatBytes(capacity.Adaptive))
+        color.New(color.FgCyan).Printf("  │   (textured regions, %.0f%% density cap)        │\n", capacity.MaxDensity*100)
+      }
+      textChars := int(float64(lsbBytes) * 8 / 5.1)
+      color.New(color.FgCyan).Printf("  │ • Estimated text capacity: ~%-20d │\n", textChars)
+      color.New(color.FgCyan).Printf("  │   (characters)                               │\n")
+      color.New(color.FgCyan).Println("  │                                                 │")
+      color.New(color.FgCyan).Println("  │ This image could potentially store:             │")
+
+      pdfPages := lsbBytes / 100000
+      if pdfPages < 1 {
+        color.New(color.FgCyan).Println("  │ • A small portion of a PDF document             │")
+      } else {
+        color.New(color.FgCyan).Printf("  │ • A PDF document of ~%d pages                  │\n", pdfPages)
+      }
+
+      mp3Seconds := lsbBytes / 16000
+      if mp3Seconds < 1 {
+        color.New(color.FgCyan).Println("  │ • Less than a second of MP3 audio               │")
+      } else if mp3Seconds < 60 {
+        color.New(color.FgCyan).Printf("  │ • ~%d seconds of MP3 audio                     │\n", mp3Seconds)
+      } else {
+        color.New(color.FgCyan).Printf("  │ • ~%.1f minutes of MP3 audio                   │\n", float64(mp3Seconds)/60)
+      }
+
+      if lsbBytes > 50000 {
+        color.New(color.FgCyan).Println("  │ • A small JPG image                             │")
+      }
+
+      color.New(color.FgHiCyan).Println("  └─────────────────────────────────────────────┘")
+
+      fmt.Println()
+      color.New(color.FgYellow).Println("  Note: Actual capacity may be lower due to overhead")
+      color.New(color.FgYellow).Println("  and limitations of the steganography algorithm.")
+    }
+  }
+
+  return nil
+}
+
+func handleAnalyzeCommand(ui *ui.UI) error {
+  flags := flag.NewFlagSet("analyze", flag.ContinueOnError)
+  windows := flags.Int("windows", steganalysis.DefaultWindows, "number of windows along each scan")
+  if err := flags.Parse(os.Args[2:]); err != nil {
+    return err
+  }
+
+  ui.PrintCommandHeader("CHI-SQUARE STEGANALYSIS")
+
+  imagePath := ui.PromptInput("Enter image path to analyze")
+  if !fileExists(imagePath) {
+    return fmt.Errorf("file does not exist: %s", imagePath)
+  }
+
+  ui.StartProgress("Running the chi-square attack")
+  report, err := steganalysis.AnalyzeFile(imagePath, *windows)
+  ui.StopProgress()
+  if err != nil {
+    return fmt.Errorf("failed to analyze image: %v", err)
+  }
+
+  ui.PrintDataDetails(map[string]string{
+    "Image size":   fmt.Sprintf("%d × %d", report.Width, report.Height),
+    "Window size":  fmt.Sprintf("%d values", report.WindowSize),
+    "Whole image":  fmt.Sprintf("%.1f%%", report.Probability*100),
+    "Row-major":    fmt.Sprintf("%.0f%% (leading %.0f%%)", report.RowMajor.Embedded()*100, report.RowMajor.Leading()*100),
+    "Column-major": fmt.Sprintf("%.0f%% (leading %.0f%%)", report.ColumnMajor.Embedded()*100, report.ColumnMajor.Leading()*100),
+  })
+
+  for _, c := range []*steganalysis.Curve{&report.ColumnMajor, &report.RowMajor} {
+    values := make([]float64, len(c.Points))
+    for i, point := range c.Points {
+      values[i] = point.Probability
+    }
+    ui.PrintCurve(fmt.Sprintf("Embedding probability, %s", c.Order), values)
+  }
+  fmt.Println()
+
+  // A run from the start of a scan is what sequential LSB embedding leaves;
+  // windows above one half elsewhere are as often smooth or noisy regions.
+  likely := report.Likely()
+  switch {
+  case likely.Leading() > 0:
+    ui.ShowWarning(fmt.Sprintf("LSB embedding likely: the first %.0f%% of the %s scan looks embedded", likely.Leading()*100, likely.Order))
+  case likely.Embedded() > 0:
+    ui.ShowInfo(fmt.Sprintf("%.0f%% of the %s windows look embedded, but not from the start of the scan", likely.Embedded()*100, likely.Order))
+  default:
+    ui.ShowSuccess("No sign of sequential LSB embedding")
+  }
+  if likely.Embedded() > 0 {
+    ui.ShowInfo("Smooth or saturated regions can score high without any hidden data")
+  }
+  ui.ShowInfo("Scattered, matrix and adaptive embedding are not detected by this attack")
+  return nil
+}
+
+func formatBytes(bytes int) string {
+  if bytes >= 1048576 {
+    return fmt.Sprintf("%.2f MB", float64(bytes)/1048576)
+  } else if bytes >= 1024 {
+    return fmt.Sprintf("%.2f KB", float64(bytes)/1024)
+  } else {
+    return fmt.Sprintf("%d bytes", bytes)
+  }
+}
+
+
+func handleHideCommand(ui *ui.UI) error {
+  options, err := parseHideOptions(flag.NewFlagSet("hide", flag.ContinueOnError), os.Args[2:])
+  if err != nil {
+    return err
+  }
+
+  ui.PrintCommandHeader("HIDE TEXT MESSAGE")
+
+  inputPath := ui.PromptInput("Enter input image path (PNG or JPG)")
+  if !fileExists(inputPath) {
+    return fmt.Errorf("input file does not exist: %s", inputPath)
+  }
+
+  outputPath := ui.PromptInput("Enter output image path (will be saved as PNG)")
+  if !strings.HasSuffix(strings.ToLower(outputPath), ".png") {
+    outputPath += ".png"
+  }
+
+  message := ui.PromptInput("Enter the secret message")
+  message = strings.TrimSpace(message)
+  if message == "" {
+    return fmt.Errorf("message cannot be empty")
+  }
+
+  var passphrase []byte
+  if options.passphrase {
+    if passphrase, err = promptNewPassphrase(ui); err != nil {
+      return err
+    }
+    defer crypto.Wipe(passphrase)
+  }
+
+  keyring, err := useKeyName(ui, &options)
+  if err != nil {
+    return fmt.Errorf("failed to use keyring: %v", err)
+  }
+  defer options.wipeKeys()
+  defer keyring.Wipe()
+
+  ui.StartProgress("Initializing encoder")
+  encoder, err := steganography.NewEncoderWithOptions(inputPath, options.embed)
+  if err != nil {
+    ui.StopProgress()
+    return fmt.Errorf("failed to initialize encoder: %v", err)
+  }
+
+  ui.UpdateProgress("Generating encryption key")
+  encryptor, err := newHideEncryptor(passphrase, options)
+  if err != nil {
+    ui.StopProgress()
+    return fmt.Errorf("failed to initialize encryption: %v", err)
+  }
+  defer encryptor.Destroy()
+
+  ui.UpdateProgress("Encrypting message")
+  plaintext, err := padPlaintext(encoder, encryptor, []byte(message))
+  if err != nil {
+    ui.StopProgress()
+    return fmt.Errorf("failed to pad message: %v", err)
+  }
+  defer crypto.Wipe(plaintext)
+
+  encrypted, err := encryptor.Encrypt(plaintext)
+  if err != nil {
+    ui.StopProgress()
+    return fmt.Errorf("failed to encrypt message: %v", err)
+  }
+
+  ui.UpdateProgress("Hiding message in image")
+  if err := encoder.Hide(encrypted); err != nil {
+    ui.StopProgress()
+    return fmt.Errorf("failed to hide message: %v", err)
+  }
+
+  ui.UpdateProgress("Saving output image")
+  if err := encoder.SaveOutput(outputPath); err != nil {
+    ui.StopProgress()
+    return fmt.Errorf("failed to save output image: %v", err)
+  }
+  ui.StopProgress()
+
+  var keyringErr error
+  if keyring != nil {
+    keyringErr = noteKeyringImage(keyring, options.keyName, outputPath)
+  }
+
+  stats := encoder.Stats()
+  details := map[string]string{
+    "Input Image": inputPath,
+    "Output Image": outputPath,
+    "Message Length": fmt.Sprintf("%d characters", len(message)),
+    "Encrypted Size": fmt.Sprintf("%.2f KB", float64(len(encrypted))/1024),
+    "Embedding Mode": describeEmbedding(encoder.Header()),
+    "Cipher": encryptor.Cipher().Name(),
+    "Pixel Changes": fmt.Sprintf("%d for %d bits", stats.Changes, stats.PayloadBits),
+    "Histogram Deviation": fmt.Sprintf("%.4f%%", stats.HistogramDeviation*100),
+  }
+  if stats.CorrectionChanges > 0 {
+    details["Correction Changes"] = fmt.Sprintf("%d", stats.CorrectionChanges)
+  }
+  if options.embed.Padding != steganography.PaddingNone {
+    details["Padding"] = fmt.Sprintf("%s (%d bytes embedded)", options.embed.Padding, len(encrypted))
+  }
+  if stats.RandomFillChanges > 0 {
+    details["Random Fill Changes"] = fmt.Sprintf("%d", stats.RandomFillChanges)
+  }
+  if stats.Copies > 0 {
+    details["Redundant Copies"] = fmt.Sprintf("%d", stats.Copies)
+  }
+  if encryptor.UsesPassphrase() {
+    details["Key Derivation"] = describeKDF(options.kdf)
+  }
+  if encryptor.UsesRecipients() {
+    details["Recipients"] = describeRecipients(options.recipients)
+  }
+  if keyring != nil {
+    details["Keyring Key"] = options.keyName
+  }
+  if encryptor.UsesMaster() {
+    details["Image ID"] = hex.EncodeToString(encryptor.ImageID())
+  }
+  if signer := options.embed.Signer; signer != nil {
+    details["Signed By"] = fmt.Sprintf("%x", crypto.SigningKeyID(signer.Public().(ed25519.PublicKey)))
+  }
+  ui.PrintDataDetails(details)
+
+  ui.ShowSuccess("Message hidden successfully in the image")
+  showExtractCredentials(ui, options, encryptor, keyring, keyringErr)
+  return nil
+}
+
+func handleHideFileCommand(ui *ui.UI) error {
+  options, err := parseHideOptions(flag.NewFlagSet("hideFile", flag.ContinueOnError), os.Args[2:])
+  if err != nil {
+    return err
+  }
+
+  ui.PrintCommandHeader("HIDE FILE IN IMAGE")
+
+  // Collect input information
+  inputPath := ui.PromptInput("Enter input image path (PNG or JPG)")
+  if !fileExists(inputPath) {
+    return fmt.Errorf("input file does not exist: %s", inputPath)
+  }
+
+  outputPath := ui.PromptInput("Enter output image path (will be saved as PNG)")
+  if !strings.HasSuffix(strings.ToLower(outputPath), ".png") {
+    outputPath += ".png"
+  }
+
+  filePath := ui.PromptInput("Enter path to the file you want to hide")
+  if !fileExists(filePath) {
+    return fmt.Errorf("file does not exist: %s", filePath)
+  }
+
+  var passphrase []byte
+  if options.passphrase {
+    if passphrase, err = promptNewPassphrase(ui); err != nil {
+      return err
+    }
+    defer crypto.Wipe(passphrase)
+  }
+
+  keyring, err := useKeyName(ui, &options)
+  if err != nil {
+    return fmt.Errorf("failed to use keyring: %v", err)
+  }
+  defer options.wipeKeys()
+  defer keyring.Wipe()
+
+  ui.StartProgress("Checking file compatibility")
+  fileHandler := steganography.NewFileHandler()
+  supported, ext := fileHandler.IsFileSupported(filePath)
+  if !supported {
+    ui.ShowWarning(fmt.Sprintf("File type %s is not in the standard supported list, but we'll try anyway", ext))
+  }
+
+  ui.UpdateProgress("Opening file")
+  file, metadata, err := fileHandler.OpenFileContent(filePath)
+  if err != nil {
+    ui.StopProgress()
+    return fmt.Errorf("failed to read file: %v", err)
+  }
+  defer file.Close()
+
+  ui.UpdateProgress("Initializing encoder")
+  encoder, err := steganography.NewEncoderWithOptions(inputPath, options.embed)
+  if err != nil {
+    ui.StopProgress()
+    return fmt.Errorf("failed to initialize encoder: %v", err)
+  }
+
+  ui.UpdateProgress("Generating encryption key")
+  encryptor, err := newHideEncryptor(passphrase, options)
+  if err != nil {
+    ui.StopProgress()
+    return fmt.Errorf("failed to initialize encryption: %v", err)
+  }
+  defer encryptor.Destroy()
+
+  ui.UpdateProgress("Encrypting and hiding file")
+  plainLen := steganography.MetadataSize + int(metadata.FileSize)
+  size, err := encoder.PaddedSize(plainLen, encryptor.Overhead)
+  if err != nil {
+    ui.StopProgress()
+    return fmt.Errorf("failed to pad file data: %v", err)
+  }
+
+  plaintext := io.MultiReader(
+    bytes.NewReader(fileHandler.SerializeMetadata(metadata)),
+    io.LimitReader(file, int64(metadata.FileSize)),
+  )
+  if size != plainLen {
+    if plaintext, err = steganography.PadReader(plaintext, plainLen, size); err != nil {
+      ui.StopProgress()
+      return fmt.Errorf("failed to pad file data: %v", err)
+    }
+  }
+
+  encryptedSize := size + encryptor.Overhead(size)
+  if err := hideFileStream(encoder, encryptor, plaintext, encryptedSize); err != nil {
+    ui.StopProgress()
+    return fmt.Errorf("failed to hide file: %v", err)
+  }
+
+  ui.UpdateProgress("Saving output image")
+  if err := encoder.SaveOutput(outputPath); err != nil {
+    ui.StopProgress()
+    return fmt.Errorf("failed to save output image: %v", err)
+  }
+  ui.StopProgress()
+
+  var keyringErr error
+  if keyring != nil {
+    keyringErr = noteKeyringImage(keyring, options.keyName, outputPath)
+  }
+
+  stats := encoder.Stats()
+  details := map[string]string{
+    "Input Image": inputPath,
+    "Output Image": outputPath,
+    "File Name": metadata.OriginalName,
+    "File Type": metadata.FileExt,
+    "File Size": fmt.Sprintf("%.2f KB", float64(metadata.FileSize)/1024),
+    "Encrypted Size": fmt.Sprintf("%.2f KB", float64(encryptedSize)/1024),
+    "Embedding Mode": describeEmbedding(encoder.Header()),
+    "Cipher": encryptor.Cipher().Name(),
+    "Pixel Changes": fmt.Sprintf("%d for %d bits", stats.Changes, stats.PayloadBits),
+    "Histogram Deviation": fmt.Sprintf("%.4f%%", stats.HistogramDeviation*100),
+  }
+  if stats.CorrectionChanges > 0 {
+    details["Correction Changes"] = fmt.Sprintf("%d", stats.CorrectionChanges)
+  }
+  if options.embed.Padding != steganography.PaddingNone {
+    details["Padding"] = fmt.Sprintf("%s (%d bytes embedded)", options.embed.Padding, encryptedSize)
+  }
+  if stats.RandomFillChanges > 0 {
+    details["Random Fill Changes"] = fmt.Sprintf("%d", stats.RandomFillChanges)
+  }
+  if stats.Copies > 0 {
+    details["Redundant Copies"] = fmt.Sprintf("%d", stats.Copies)
+  }
+  if encryptor.UsesPassphrase() {
+    details["Key Derivation"] = describeKDF(options.kdf)
+  }
+  if encryptor.UsesRecipients() {
+    details["Recipients"] = describeRecipients(options.recipients)
+  }
+  if keyring != nil {
+    details["Keyring Key"] = options.keyName
+  }
+  if encryptor.UsesMaster() {
+    details["Image ID"] = hex.EncodeToString(encryptor.ImageID())
+  }
+  if signer := options.embed.Signer; signer != nil {
+    details["Signed By"] = fmt.Sprintf("%x", crypto.SigningKeyID(signer.Public().(ed25519.PublicKey)))
+  }
+  ui.PrintDataDetails(details)
+
+  ui.ShowSuccess("File hidden successfully in the image")
+  showExtractCredentials(ui, options, encryptor, keyring, keyringErr)
+  return nil
+}
+
+const saveFilePrompt = "Enter path to save the extracted file (or press Enter to use original filename)"
+
+func handleExtractCommand(ui *ui.UI) error {
+  flags := flag.NewFlagSet("extract", flag.ContinueOnError)
+  trustedPath := flags.String("trusted", crypto.DefaultTrustedSignersPath(), "trusted signers list")
+  keyName := flags.String("key-name", "", "open with this keyring key")
+  tryKeyring := flags.Bool("try-keyring", false, "try every key in the keyring")
+  useShares := flags.Bool("shares", false, "rebuild the key from key shares")
+  force := flags.Bool("force", false, "extract content even if it has expired")
+  if err := flags.Parse(os.Args[2:]); err != nil {
+    return err
+  }
+  if *keyName != "" && *tryKeyring {
+    return fmt.Errorf("--key-name cannot be combined with --try-keyring")
+  }
+  if *useShares && (*keyName != "" || *tryKeyring) {
+    return fmt.Errorf("--shares cannot be combined with the keyring")
+  }
+
+  trusted, err := crypto.LoadTrustedSigners(*trustedPath)
+  if err != nil {
+    return fmt.Errorf("failed to load trusted signers: %v", err)
+  }
+
+  ui.PrintCommandHeader("EXTRACT HIDDEN CONTENT")
+
+  inputPath := ui.PromptInput("Enter image path")
+  if !fileExists(inputPath) {
+    return fmt.Errorf("file does not exist: %s", inputPath)
+  }
+
+  ui.StartProgress("Initializing decoder")
+  decoder, err := steganography.NewDecoder(inputPath)
+  if err != nil {
+    ui.StopProgress()
+    return fmt.Errorf("failed to initialize decoder: %v", err)
+  }
+
+  ui.UpdateProgress("Extracting hidden content")
+  body, isFile, metadata, err := decoder.ExtractReader()
+  if err != nil {
+    ui.StopProgress()
+    if errors.Is(err, steganography.ErrNoPayload) && *tryKeyring {
+      return fmt.Errorf("%w; keyring keys are only tried on the payload a header points to, and headers are never hidden under a key",
+        errNoContent)
+    }
+    if errors.Is(err, steganography.ErrNoPayload) {
+      return errNoContent
+    }
+    return fmt.Errorf("failed to extract content: %v", err)
+  }
+
+  // An encrypted file is decrypted from the image straight to disk, so only
+  // its envelope header is read up front. Trying keyring keys needs the
+  // whole payload.
+  streamFile := isFile && metadata == nil && !*tryKeyring
+  var data []byte
+  if streamFile {
+    data, err = crypto.ReadEnvelopeHeader(body)
+  } else {
+    data, err = io.ReadAll(body)
+  }
+  if err != nil {
+    ui.StopProgress()
+    return fmt.Errorf("failed to extract content: %v", err)
+  }
+
+  ui.StopProgress()
+  if decoder.IsVault() {
+    return fmt.Errorf("the image holds a vault; open it with %s vault list or vault get", os.Args[0])
+  }
+
+  // A thread is opened with the credentials of its first message.
+  var conversation *thread.Thread
+  sealed := data
+  if decoder.IsThread() {
+    if conversation, err = thread.Parse(decoder, data); err != nil {
+      return fmt.Errorf("failed to read thread: %w", err)
+    }
+    sealed = conversation.Records[0]
+  }
+
+  var keyring *crypto.Keyring
+  var entry *crypto.KeyringEntry
+  if *keyName != "" || *tryKeyring {
+    if keyring, err = openKeyring(ui, false); err != nil {
+      return err
+    }
+    defer keyring.Wipe()
+  }
+
+  switch {
+  case *keyName != "":
+    if entry = keyring.Find(*keyName); entry == nil {
+      return fmt.Errorf("no key named %q in the keyring", *keyName)
+    }
+  case *tryKeyring:
+    ui.StartProgress(fmt.Sprintf("Trying %d keyring keys", len(keyring.Entries)))
+    entry, err = keyring.Match(sealed)
+    for i := 0; err != nil && conversation == nil && i < decoder.CopyCount(); i++ {
+      if copyData, _, _, copyErr := decoder.ExtractCopy(i); copyErr == nil {
+        entry, err = keyring.Match(copyData)
+      }
+    }
+    ui.StopProgress()
+    if err != nil {
+      return err
+    }
+    ui.ShowSuccess(fmt.Sprintf("Keyring key %q opens this image", entry.Name))
+  }
+
+  var encryptor *crypto.Encryptor
+  if entry != nil {
+    encryptor, err = entry.Encryptor()
+  } else if *useShares {
+    var key []byte
+    if key, err = promptKeyShares(ui); err == nil {
+      encryptor, err = crypto.NewEncryptorWithKey(key)
+      crypto.Wipe(key)
+    }
+  } else {
+    encryptor, err = promptDecryptor(ui, sealed)
+  }
+  if err != nil {
+    return fmt.Errorf("failed to initialize decryption: %v", err)
+  }
+  defer encryptor.Destroy()
+
+  if conversation != nil {
+    if err := showThread(ui, inputPath, decoder.Header(), conversation, encryptor, trusted); err != nil {
+      return err
+    }
+    if keyring != nil {
+      if err := noteKeyringImage(keyring, entry.Name, inputPath); err != nil {
+        ui.ShowWarning(fmt.Sprintf("Failed to update the keyring: %v", err))
+      }
+    }
+    return nil
+  }
+
+  // A streamed file is decrypted next to where it is saved, so moving it
+  // into place does not copy the plaintext again.
+  var outputPath, outputDir string
+  if streamFile {
+    outputPath = ui.PromptInput(saveFilePrompt)
+    outputDir = filepath.Dir(outputPath)
+    if info, err := os.Stat(outputPath); err == nil && info.IsDir() {
+      outputDir = outputPath
+    }
+  }
+
+  ui.StartProgress("Decrypting content")
+  recoveredFrom := "single copy"
+  if decoder.CopyCount() > 0 {
+    recoveredFrom = fmt.Sprintf("majority vote of %d copies", decoder.CopyCount())
+  }
+
+  var decrypted []byte
+  var extracted *steganography.ExtractedFile
+  if streamFile {
+    extracted, err = decoder.DecryptFile(io.MultiReader(bytes.NewReader(data), body), encryptor, outputDir,
+      func(info *crypto.PayloadInfo) error {
+        return checkExpiry(ui, info, *force)
+      })
+    if errors.Is(err, crypto.ErrExpired) {
+      ui.StopProgress()
+      return err
+    }
+    if err == nil {
+      defer steganography.WipeFile(extracted.Path)
+      metadata = extracted.Metadata
+      if extracted.Copy > 0 {
+        recoveredFrom = fmt.Sprintf("copy %d of %d", extracted.Copy, decoder.CopyCount())
+      }
+    }
+  } else {
+    decrypted, err = encryptor.Decrypt(data)
+    for i := 0; err != nil && i < decoder.CopyCount(); i++ {
+      copyData, copyIsFile, copyMetadata, copyErr := decoder.ExtractCopy(i)
+      if copyErr != nil {
+        continue
+      }
+      if plaintext, decryptErr := encryptor.Decrypt(copyData); decryptErr == nil {
+        decrypted, isFile, metadata, err = plaintext, copyIsFile, copyMetadata, nil
+        recoveredFrom = fmt.Sprintf("copy %d of %d", i+1, decoder.CopyCount())
+      }
+    }
+  }
+  if err != nil {
+    ui.StopProgress()
+    reportSegmentError(ui, err)
+    return fmt.Errorf("failed to decrypt content: %w", decoder.PayloadError(err))
+  }
+  defer crypto.Wipe(decrypted)
+  ui.StopProgress()
+  if extracted == nil {
+    if err := checkExpiry(ui, encryptor.Info(), *force); err != nil {
+      return err
+    }
+  }
+
+  header := decoder.Header()
+  if extracted == nil {
+    ui.StartProgress("Unpacking content")
+    if header.Padded() {
+      decrypted, err = steganography.Unpad(decrypted)
+      if err != nil {
+        ui.StopProgress()
+        return fmt.Errorf("failed to remove padding: %v", err)
+      }
+    }
+    if isFile && metadata == nil {
+      decrypted, metadata, err = steganography.NewFileHandler().UnpackFile(decrypted)
+      if err != nil {
+        ui.StopProgress()
+        return fmt.Errorf("failed to read file metadata: %v", err)
+      }
+    }
+    ui.StopProgress()
+  }
+
+  payloadCipher, err := crypto.PayloadCipher(data)
+  if err != nil {
+    return fmt.Errorf("failed to read payload cipher: %v", err)
+  }
+
+  if keyring != nil {
+    if err := noteKeyringImage(keyring, entry.Name, inputPath); err != nil {
+      ui.ShowWarning(fmt.Sprintf("Failed to update the keyring: %v", err))
+    }
+  }
+
+  signatureStatus, signature := describeSignature(decoder, trusted)
+  if signatureStatus == crypto.SignatureInvalid {
+    ui.ShowWarning("The signature does not match the trusted key it claims; do not trust this content")
+  }
+
+  if isFile && metadata != nil {
+    size := int64(len(decrypted))
+    if extracted != nil {
+      size = extracted.Size
+    }
+    details := map[string]string{
+      "Content Type": "File",
+      "File Name": metadata.OriginalName,
+      "File Type": metadata.FileExt,
+      "File Size": fmt.Sprintf("%.2f KB", float64(size)/1024),
+      "Embedding Mode": describeEmbedding(header),
+      "Cipher": payloadCipher.Name(),
+      "Recovered From": recoveredFrom,
+      "Signature": signature,
+    }
+    if entry != nil {
+      details["Keyring Key"] = entry.Name
+    }
+    describeInfo(details, encryptor.Info())
+    ui.PrintDataDetails(details)
+
+    if extracted == nil {
+      outputPath = ui.PromptInput(saveFilePrompt)
+    }
+    if outputPath == "" {
+      outputPath = metadata.OriginalName
+    }
+
+    ui.StartProgress("Saving extracted file")
+    fileHandler := steganography.NewFileHandler()
+    if extracted != nil {
+      err = fileHandler.MoveFileContent(extracted.Path, metadata, outputPath)
+    } else {
+      err = fileHandler.SaveFileContent(decrypted, metadata, outputPath)
+    }
+    if err != nil {
+      ui.StopProgress()
+      return fmt.Errorf("failed to save extracted file: %v", err)
+    }
+    ui.StopProgress()
+
+    ui.ShowSuccess(fmt.Sprintf("File extracted and saved to: %s", outputPath))
+  } else {
+    details := map[string]string{
+      "Content Type": "Text Message",
+      "Length": fmt.Sprintf("%d characters", len(decrypted)),
+      "Input Image": inputPath,
+      "Embedding Mode": describeEmbedding(header),
+      "Cipher": payloadCipher.Name(),
+      "Recovered From": recoveredFrom,
+      "Signature": signature,
+    }
+    if entry != nil {
+      details["Keyring Key"] = entry.Name
+    }
+    describeInfo(details, encryptor.Info())
+    ui.PrintDataDetails(details)
+
+    ui.ShowSuccess("Message extracted successfully!")
+    printMessageBox("Extracted Message", decrypted)
+  }
+
+  return nil
+}
+
+// reportSegmentError points out where a payload is damaged, once the key is
+// known to be right and the damage is down to a segment.
+func reportSegmentError(ui *ui.UI, err error) {
+  var segmentErr *crypto.SegmentError
+  if !errors.As(err, &segmentErr) || !errors.Is(err, crypto.ErrCorruptPayload) {
+    return
+  }
+  if segmentErr.Index == 0 {
+    ui.ShowWarning("The first segment of the payload is damaged")
+    return
+  }
+  ui.ShowWarning(fmt.Sprintf("Segment %d of the payload is damaged; the segments before it were intact",
+    segmentErr.Index))
+}
+
+func printMessageBox(title string, message []byte) {
+  fmt.Println()
+  color.New(color.FgHiCyan).Printf("  ┌─ %s %s┐\n", title, strings.Repeat("─", max(44-utf8.RuneCountInString(title), 1)))
+  messageLines := splitMessage(message, 45)
+  for _, line := range messageLines {
+    color.New(color.FgHiCyan).Print("  │ ")
+    color.New(color.FgHiWhite).Printf("%s", line)
+
+    padding := 45 - len(line)
+    if padding > 0 {
+      fmt.Print(strings.Repeat(" ", padding))
+    }
+    crypto.Wipe(line)
+
+    color.New(color.FgHiCyan).Println(" │")
+  }
+  color.New(color.FgHiCyan).Println("  └─────────────────────────────────────────────┘")
+  fmt.Println()
+}
+
+// showThread prints every message of a thread the credentials open, oldest
+// first.
+func showThread(ui *ui.UI, inputPath string, header *steganography.Header, t *thread.Thread, encryptor *crypto.Encryptor, trusted []crypto.TrustedSigner) error {
+  ui.StartProgress("Decrypting thread")
+  messages, err := t.Messages(encryptor)
+  ui.StopProgress()
+  if err != nil {
+    return err
+  }
+
+  opened := 0
+  for _, message := range messages {
+    if message != nil {
+      opened++
+    }
+  }
+  ui.PrintDataDetails(map[string]string{
+    "Content Type": "Thread",
+    "Input Image": inputPath,
+    "Messages": fmt.Sprintf("%d (%d opened)", len(messages), opened),
+    "Embedding Mode": describeEmbedding(header),
+  })
+
+  for i, message := range messages {
+    if message == nil {
+      ui.ShowInfo(fmt.Sprintf("Message %d is sealed with other credentials", i+1))
+      continue
+    }
+    if message.Seq != i+1 {
+      ui.ShowWarning(fmt.Sprintf("Message %d claims to be message %d; the thread was reordered or cut", i+1, message.Seq))
+    }
+
+    author := "unsigned"
+    if message.Signature != nil {
+      var status crypto.SignatureStatus
+      status, author = describeSigner(trusted, message.Author, message.SignedData(), message.Signature)
+      if status == crypto.SignatureInvalid {
+        ui.ShowWarning(fmt.Sprintf("The signature on message %d does not match the trusted key it claims", message.Seq))
+      }
+    }
+    title := fmt.Sprintf("#%d %s, %s", message.Seq, message.Created.Local().Format("2006-01-02 15:04"), author)
+    printMessageBox(title, []byte(message.Text))
+  }
+  return nil
+}
+
+// threadExcludedFlags are the hide flags a thread cannot use: padding
+// would leave no room for the next message, and messages do not expire.
+var threadExcludedFlags = map[string]bool{"pad": true, "expires": true}
+
+func handlePostCommand(ui *ui.UI) error {
+  flags := flag.NewFlagSet("post", flag.ContinueOnError)
+  outputPath := flags.String("output", "", "write the thread here instead of updating the input")
+  options, err := parseHideOptions(flags, os.Args[2:])
+  if err != nil {
+    return err
+  }
+  credentials := false
+  var embedding string
+  flags.Visit(func(f *flag.Flag) {
+    if threadExcludedFlags[f.Name] && err == nil {
+      err = fmt.Errorf("--%s does not apply to a thread", f.Name)
+    }
+    switch f.Name {
+    case "passphrase", "recipient", "recipients-file", "key-name":
+      credentials = true
+    }
+    if embeddingFlags[f.Name] {
+      embedding = f.Name
+    }
+  })
+  if err != nil {
+    return err
+  }
+  // The image signature covers one body and would not survive the next
+  // message; --sign signs the message instead.
+  signer := options.embed.Signer
+  options.embed.Signer = nil
+
+  ui.PrintCommandHeader("POST TO THREAD")
+
+  inputPath := ui.PromptInput("Enter image path (a thread, or a cover image to start one)")
+  if !fileExists(inputPath) {
+    return fmt.Errorf("input file does not exist: %s", inputPath)
+  }
+
+  ui.StartProgress("Reading thread")
+  conversation, err := readThread(inputPath)
+  ui.StopProgress()
+  if err != nil {
+    return err
+  }
+  if conversation == nil {
+    conversation = thread.New(options.embed)
+    if *outputPath == "" {
+      *outputPath = ui.PromptInput("Enter output image path (will be saved as PNG)")
+    }
+    if !strings.HasSuffix(strings.ToLower(*outputPath), ".png") {
+      *outputPath += ".png"
+    }
+  } else {
+    if embedding != "" {
+      return fmt.Errorf("--%s does not apply to an existing thread; it keeps its embedding", embedding)
+    }
+    if *outputPath == "" {
+      *outputPath = inputPath
+    }
+  }
+
+  message := strings.TrimSpace(ui.PromptInput("Enter the message"))
+  if message == "" {
+    return fmt.Errorf("message cannot be empty")
+  }
+
+  var encryptor *crypto.Encryptor
+  var keyring *crypto.Keyring
+  if len(conversation.Records) > 0 && !credentials {
+    first := conversation.Records[0]
+    if crypto.NeedsIdentity(first) && !crypto.NeedsPassphrase(first) {
+      return fmt.Errorf("the thread is sealed for recipients; post to it with --recipient")
+    }
+    if encryptor, err = promptDecryptor(ui, first); err != nil {
+      return fmt.Errorf("failed to initialize encryption: %v", err)
+    }
+    defer encryptor.Destroy()
+
+    ui.StartProgress("Checking credentials")
+    decrypted, err := encryptor.Decrypt(first)
+    ui.StopProgress()
+    if err != nil {
+      return fmt.Errorf("failed to open the thread: %w", err)
+    }
+    crypto.Wipe(decrypted)
+    encryptor.SetCipher(options.cipher)
+    if err := encryptor.SetInfo(payloadInfo(options, nil)); err != nil {
+      return err
+    }
+  } else {
+    var passphrase []byte
+    if options.passphrase {
+      if passphrase, err = promptNewPassphrase(ui); err != nil {
+        return err
+      }
+      defer crypto.Wipe(passphrase)
+    }
+
+    if keyring, err = useKeyName(ui, &options); err != nil {
+      return fmt.Errorf("failed to use keyring: %v", err)
+    }
+    defer options.wipeKeys()
+    defer keyring.Wipe()
+
+    if encryptor, err = newHideEncryptor(passphrase, options); err != nil {
+      return fmt.Errorf("failed to initialize encryption: %v", err)
+    }
+    defer encryptor.Destroy()
+  }
+
+  ui.StartProgress("Encrypting message")
+  posted, err := conversation.Append(encryptor, message, signer)
+  if err != nil {
+    ui.StopProgress()
+    return err
+  }
+
+  ui.UpdateProgress("Embedding thread")
+  encoder, err := conversation.Save(inputPath, *outputPath)
+  ui.StopProgress()
+  if err != nil {
+    return err
+  }
+
+  var keyringErr error
+  if keyring != nil {
+    keyringErr = noteKeyringImage(keyring, options.keyName, *outputPath)
+  }
+
+  stats := encoder.Stats()
   details := map[string]string{
     "Input Image": inputPath,
-    "Output Image": outputPath,
-    "File Name": metadata.OriginalName,
-    "File Type": metadata.FileExt,
-    "File Size": fmt.Sprintf("%.2f KB", float64(metadata.FileSize)/1024),
-    "Encrypted Size": fmt.Sprintf("%.2f KB", float64(len(encrypted))/1024),
+    "Output Image": *outputPath,
+    "Message": fmt.Sprintf("%d of %d", posted.Seq, len(conversation.Records)),
+    "Posted": posted.Created.Local().Format("2006-01-02 15:04:05"),
+    "Embedding Mode": describeEmbedding(encoder.Header()),
+    "Cipher": encryptor.Cipher().Name(),
+    "Pixel Changes": fmt.Sprintf("%d for %d bits", stats.Changes, stats.PayloadBits),
+  }
+  if stats.Copies > 0 {
+    details["Redundant Copies"] = fmt.Sprintf("%d", stats.Copies)
+  }
+  if posted.Author != nil {
+    details["Signed By"] = fmt.Sprintf("%x", posted.Author)
+  }
+  if keyring != nil {
+    details["Keyring Key"] = options.keyName
   }
   ui.PrintDataDetails(details)
 
-  ui.ShowSuccess("File hidden successfully in the image")
-  ui.PrintKeyBox(hex.EncodeToString(encryptor.GetKey()))
-
+  ui.ShowSuccess(fmt.Sprintf("Message %d posted to the thread", posted.Seq))
+  if credentials || posted.Seq == 1 {
+    showExtractCredentials(ui, options, encryptor, keyring, keyringErr)
+  }
   return nil
 }
 
-func handleExtractCommand(ui *ui.UI) error {
-  ui.PrintCommandHeader("EXTRACT HIDDEN CONTENT")
+// readThread reads the thread in imagePath, nil for an image with no hidden
+// content. Anything else hidden in the image would be lost by posting.
+func readThread(imagePath string) (*thread.Thread, error) {
+  decoder, err := steganography.NewDecoder(imagePath)
+  if err != nil {
+    return nil, fmt.Errorf("failed to initialize decoder: %v", err)
+  }
+  data, _, _, err := decoder.Extract()
+  if errors.Is(err, steganography.ErrNoPayload) {
+    return nil, nil
+  }
+  if err != nil {
+    return nil, fmt.Errorf("failed to extract thread: %v", err)
+  }
+  if !decoder.IsThread() {
+    return nil, fmt.Errorf("%w; posting would overwrite it", thread.ErrNotThread)
+  }
+  return thread.Parse(decoder, data)
+}
+
+// embeddingFlags are the hide flags that describe the embedding, which
+// rekey takes from the image instead.
+var embeddingFlags = map[string]bool{
+  "mode": true, "max-density": true, "preserve-histogram": true,
+  "pad": true, "randomize": true, "copies": true,
+}
+
+func handleRekeyCommand(ui *ui.UI) error {
+  flags := flag.NewFlagSet("rekey", flag.ContinueOnError)
+  oldKeyName := flags.String("old-key-name", "", "open with this keyring key")
+  outputPath := flags.String("output", "", "write the rekeyed image here instead of replacing the input")
+  force := flags.Bool("force", false, "rekey content even if it has expired")
+  options, err := parseHideOptions(flags, os.Args[2:])
+  if err != nil {
+    return err
+  }
+  flags.Visit(func(f *flag.Flag) {
+    if embeddingFlags[f.Name] && err == nil {
+      err = fmt.Errorf("--%s does not apply to rekey; the image keeps its embedding", f.Name)
+    }
+  })
+  if err != nil {
+    return err
+  }
+
+  ui.PrintCommandHeader("REKEY HIDDEN CONTENT")
 
   inputPath := ui.PromptInput("Enter image path")
   if !fileExists(inputPath) {
     return fmt.Errorf("file does not exist: %s", inputPath)
   }
+  if *outputPath == "" {
+    *outputPath = inputPath
+  }
+
+  ui.StartProgress("Extracting hidden content")
+  decoder, err := steganography.NewDecoder(inputPath)
+  if err != nil {
+    ui.StopProgress()
+    return fmt.Errorf("failed to initialize decoder: %v", err)
+  }
+  data, isFile, metadata, err := decoder.Extract()
+  ui.StopProgress()
+  if err != nil {
+    if errors.Is(err, steganography.ErrNoPayload) {
+      return errNoContent
+    }
+    return fmt.Errorf("failed to extract content: %v", err)
+  }
+  if decoder.IsThread() {
+    return fmt.Errorf("rekey does not apply to a thread; each message is sealed on its own")
+  }
 
-  keyStr := ui.PromptInput("Enter encryption key (hex)")
-  keyStr = strings.TrimSpace(keyStr)
-  if len(keyStr) != 64 {
-    return fmt.Errorf("invalid key length. Expected 64 hexadecimal characters")
+  var passphrase []byte
+  if options.passphrase {
+    ui.ShowInfo("Choose the new passphrase")
+    if passphrase, err = promptNewPassphrase(ui); err != nil {
+      return err
+    }
+    defer crypto.Wipe(passphrase)
   }
 
-  ui.StartProgress("Validating encryption key")
-  key, err := hex.DecodeString(keyStr)
+  keyring, err := useKeyName(ui, &options)
+  if err != nil {
+    return fmt.Errorf("failed to use keyring: %v", err)
+  }
+  defer options.wipeKeys()
+  defer keyring.Wipe()
+
+  var oldEncryptor *crypto.Encryptor
+  if *oldKeyName != "" {
+    if keyring == nil {
+      if keyring, err = openKeyring(ui, false); err != nil {
+        return err
+      }
+      defer keyring.Wipe()
+    }
+    entry := keyring.Find(*oldKeyName)
+    if entry == nil {
+      return fmt.Errorf("no key named %q in the keyring", *oldKeyName)
+    }
+    oldEncryptor, err = entry.Encryptor()
+  } else {
+    ui.ShowInfo("Enter what the content is protected with now")
+    oldEncryptor, err = promptDecryptor(ui, data)
+  }
+  if err != nil {
+    return fmt.Errorf("failed to initialize decryption: %v", err)
+  }
+  defer oldEncryptor.Destroy()
+
+  ui.StartProgress("Decrypting content")
+  decrypted, err := oldEncryptor.Decrypt(data)
+  for i := 0; err != nil && i < decoder.CopyCount(); i++ {
+    copyData, copyIsFile, copyMetadata, copyErr := decoder.ExtractCopy(i)
+    if copyErr != nil {
+      continue
+    }
+    if plaintext, decryptErr := oldEncryptor.Decrypt(copyData); decryptErr == nil {
+      data, decrypted, isFile, metadata, err = copyData, plaintext, copyIsFile, copyMetadata, nil
+    }
+  }
   if err != nil {
     ui.StopProgress()
-    return fmt.Errorf("invalid encryption key format: must be hexadecimal")
+    return fmt.Errorf("failed to decrypt content: %w", decoder.PayloadError(err))
+  }
+  defer crypto.Wipe(decrypted)
+  ui.StopProgress()
+  if err := checkExpiry(ui, oldEncryptor.Info(), *force); err != nil {
+    return err
   }
 
-  ui.UpdateProgress("Initializing decoder")
-  decoder, err := steganography.NewDecoder(inputPath)
+  ui.StartProgress("Encrypting under the new key")
+  encryptor, err := newHideEncryptor(passphrase, options)
+  if err == nil {
+    err = encryptor.SetInfo(payloadInfo(options, oldEncryptor.Info()))
+  }
   if err != nil {
     ui.StopProgress()
-    return fmt.Errorf("failed to initialize decoder: %v", err)
+    return fmt.Errorf("failed to initialize encryption: %v", err)
   }
+  defer encryptor.Destroy()
 
-  ui.UpdateProgress("Extracting hidden content")
-  data, isFile, metadata, err := decoder.Extract()
+  plaintext, err := decoder.RekeyPlaintext(decrypted, metadata, len(data), encryptor.Overhead)
   if err != nil {
     ui.StopProgress()
-    if err.Error() == "no steganographic data found" {
-      return fmt.Errorf("no hidden content found in this image")
-    }
-    return fmt.Errorf("failed to extract content: %v", err)
+    return err
   }
+  defer crypto.Wipe(plaintext)
 
-  ui.UpdateProgress("Initializing decryption")
-  encryptor, err := crypto.NewEncryptorWithKey(key)
+  encrypted, err := encryptor.Encrypt(plaintext)
   if err != nil {
     ui.StopProgress()
-    return fmt.Errorf("failed to initialize decryption: %v", err)
+    return fmt.Errorf("failed to encrypt content: %v", err)
   }
 
-  ui.UpdateProgress("Decrypting content")
-  decrypted, err := encryptor.Decrypt(data)
+  ui.UpdateProgress("Embedding the new payload")
+  header := decoder.Header()
+  embed := decoder.RekeyOptions()
+  embed.Signer = options.embed.Signer
+  encoder, err := steganography.NewEncoderWithOptions(inputPath, embed)
+  if err != nil {
+    ui.StopProgress()
+    return fmt.Errorf("failed to initialize encoder: %v", err)
+  }
+  switch {
+  case decoder.IsVault():
+    err = encoder.HideVault(encrypted)
+  case isFile:
+    err = encoder.HideFile(encrypted)
+  default:
+    err = encoder.Hide(encrypted)
+  }
   if err != nil {
     ui.StopProgress()
-    return fmt.Errorf("failed to decrypt content: %v", err)
+    return fmt.Errorf("failed to embed content: %v", err)
+  }
+
+  // Replacing the only copy of the content, so never leave it half written.
+  temp := *outputPath + ".tmp"
+  if err := encoder.SaveOutput(temp); err != nil {
+    ui.StopProgress()
+    os.Remove(temp)
+    return fmt.Errorf("failed to save output image: %v", err)
+  }
+  if err := os.Rename(temp, *outputPath); err != nil {
+    ui.StopProgress()
+    os.Remove(temp)
+    return fmt.Errorf("failed to save output image: %v", err)
   }
   ui.StopProgress()
 
-  if isFile && metadata != nil {
+  var keyringErr error
+  if keyring != nil && options.keyName != "" {
+    keyringErr = noteKeyringImage(keyring, options.keyName, *outputPath)
+  }
+
+  details := map[string]string{
+    "Image": *outputPath,
+    "Content Type": "Message",
+    "Encrypted Size": fmt.Sprintf("%.2f KB", float64(len(encrypted))/1024),
+    "Embedding Mode": describeEmbedding(encoder.Header()),
+    "Cipher": encryptor.Cipher().Name(),
+  }
+  if isFile {
+    details["Content Type"] = "File"
+  }
+  if decoder.IsVault() {
+    details["Content Type"] = "Vault"
+  }
+  if header.Padded() {
+    details["Padding"] = fmt.Sprintf("kept (%d bytes embedded, was %d)", len(encrypted), len(data))
+  }
+  if copies := encoder.Stats().Copies; copies > 0 {
+    details["Redundant Copies"] = fmt.Sprintf("%d", copies)
+  }
+  if encryptor.UsesPassphrase() {
+    details["Key Derivation"] = describeKDF(options.kdf)
+  }
+  if encryptor.UsesRecipients() {
+    details["Recipients"] = describeRecipients(options.recipients)
+  }
+  if options.keyName != "" {
+    details["Keyring Key"] = options.keyName
+  }
+  if encryptor.UsesMaster() {
+    details["Image ID"] = hex.EncodeToString(encryptor.ImageID())
+  }
+  if signer := options.embed.Signer; signer != nil {
+    details["Signed By"] = fmt.Sprintf("%x", crypto.SigningKeyID(signer.Public().(ed25519.PublicKey)))
+  }
+  ui.PrintDataDetails(details)
+
+  ui.ShowSuccess("Content re-encrypted; the old key no longer opens this image")
+  if header.Signed() && options.embed.Signer == nil {
+    ui.ShowWarning("The old signature covered the old payload and was dropped; use --sign to sign the new one")
+  }
+  if options.keyName == "" {
+    keyring = nil
+  }
+  showExtractCredentials(ui, options, encryptor, keyring, keyringErr)
+  return nil
+}
+
+// vaultExcludedFlags are the hide flags a vault could not keep when it is
+// rewritten: recipients cannot seal it again, and a signature or expiry
+// would not survive the next change.
+var vaultExcludedFlags = map[string]bool{
+  "recipient": true, "recipients-file": true, "sign": true, "expires": true,
+}
+
+func handleVaultCommand(ui *ui.UI) error {
+  if len(os.Args) < 3 {
+    return fmt.Errorf("vault needs an action: init, list, get, add or remove")
+  }
+  action := os.Args[2]
+  switch action {
+  case "init":
+    return handleVaultInit(ui)
+  case "list", "get", "add", "remove":
+  default:
+    return fmt.Errorf("unknown vault action %q: use init, list, get, add or remove", action)
+  }
+
+  flags := flag.NewFlagSet("vault "+action, flag.ContinueOnError)
+  keyName := flags.String("key-name", "", "open with this keyring key")
+  outputPath := flags.String("output", "", "add, remove: write the changed vault here instead of replacing the input")
+  if err := flags.Parse(os.Args[3:]); err != nil {
+    return err
+  }
+  args := flags.Args()
+  arg := func(i int, prompt string) string {
+    if i < len(args) {
+      return args[i]
+    }
+    return ui.PromptInput(prompt)
+  }
+
+  ui.PrintCommandHeader("VAULT")
+
+  imagePath := arg(0, "Enter vault image path")
+  if !fileExists(imagePath) {
+    return fmt.Errorf("file does not exist: %s", imagePath)
+  }
+  if *outputPath == "" {
+    *outputPath = imagePath
+  }
+
+  v, encryptor, err := openVault(ui, imagePath, *keyName)
+  if err != nil {
+    return err
+  }
+  defer encryptor.Destroy()
+  defer v.Wipe()
+
+  switch action {
+  case "list":
+    if len(v.Entries) == 0 {
+      ui.ShowInfo("The vault is empty")
+      return nil
+    }
+    var lines []string
+    for _, entry := range v.Entries {
+      lines = append(lines, fmt.Sprintf("%-24s %-20s added %s", entry.Title, entry.Username, entry.Created.Format("2006-01-02")))
+    }
+    ui.PrintFeatureList(fmt.Sprintf("Entries in %s", imagePath), lines)
+    return nil
+
+  case "get":
+    title := arg(1, "Enter entry title")
+    entry := v.Find(title)
+    if entry == nil {
+      return fmt.Errorf("no entry titled %q in the vault", title)
+    }
     details := map[string]string{
-      "Content Type": "File",
-      "File Name": metadata.OriginalName,
-      "File Type": metadata.FileExt,
-      "File Size": fmt.Sprintf("%.2f KB", float64(len(decrypted))/1024),
+      "Title": entry.Title,
+      "Added": entry.Created.Local().Format("2006-01-02 15:04"),
+    }
+    if entry.Username != "" {
+      details["Username"] = entry.Username
+    }
+    if entry.Notes != "" {
+      details["Notes"] = entry.Notes
     }
     ui.PrintDataDetails(details)
+    ui.PrintSecretBox(entry.Secret)
+    return nil
 
-    outputPath := ui.PromptInput("Enter path to save the extracted file (or press Enter to use original filename)")
-    if outputPath == "" {
-      outputPath = metadata.OriginalName
+  case "add":
+    title := arg(1, "Enter entry title")
+    if v.Find(title) != nil {
+      return fmt.Errorf("an entry titled %q already exists", title)
+    }
+    entry := &vault.Entry{
+      Title:    title,
+      Username: ui.PromptInput("Enter username (optional)"),
+    }
+    entry.Secret = ui.PromptSecret("Enter secret")
+    entry.Notes = ui.PromptInput("Enter notes (optional)")
+    if err := v.Add(entry); err != nil {
+      return err
+    }
+    if err := saveVault(ui, v, encryptor, imagePath, *outputPath); err != nil {
+      return err
     }
+    ui.ShowSuccess(fmt.Sprintf("Added %q to the vault in %s", entry.Title, *outputPath))
 
-    ui.StartProgress("Saving extracted file")
-    fileHandler := steganography.NewFileHandler()
-    if err := fileHandler.SaveFileContent(decrypted, metadata, outputPath); err != nil {
-      ui.StopProgress()
-      return fmt.Errorf("failed to save extracted file: %v", err)
+  case "remove":
+    title := arg(1, "Enter entry title")
+    if err := v.Remove(title); err != nil {
+      return err
     }
-    ui.StopProgress()
+    if err := saveVault(ui, v, encryptor, imagePath, *outputPath); err != nil {
+      return err
+    }
+    ui.ShowSuccess(fmt.Sprintf("Removed %q from the vault in %s", title, *outputPath))
+  }
+  return nil
+}
 
-    ui.ShowSuccess(fmt.Sprintf("File extracted and saved to: %s", outputPath))
-  } else {
-    details := map[string]string{
-      "Content Type": "Text Message",
-      "Length": fmt.Sprintf("%d characters", len(decrypted)),
-      "Input Image": inputPath,
+func handleVaultInit(ui *ui.UI) error {
+  flags := flag.NewFlagSet("vault init", flag.ContinueOnError)
+  options, err := parseHideOptions(flags, os.Args[3:])
+  if err != nil {
+    return err
+  }
+  flags.Visit(func(f *flag.Flag) {
+    if vaultExcludedFlags[f.Name] && err == nil {
+      err = fmt.Errorf("--%s does not apply to a vault", f.Name)
     }
-    ui.PrintDataDetails(details)
+  })
+  if err != nil {
+    return err
+  }
 
-    ui.ShowSuccess("Message extracted successfully!")
+  ui.PrintCommandHeader("CREATE VAULT")
 
-    fmt.Println()
-    color.New(color.FgHiCyan).Println("  ┌─ Extracted Message ───────────────────────────┐")
-    messageLines := splitMessage(string(decrypted), 45)
-    for _, line := range messageLines {
-      color.New(color.FgHiCyan).Print("  │ ")
-      color.New(color.FgHiWhite).Printf("%s", line)
+  inputPath := ui.PromptInput("Enter input image path (PNG or JPG)")
+  if !fileExists(inputPath) {
+    return fmt.Errorf("input file does not exist: %s", inputPath)
+  }
 
-      padding := 45 - len(line)
-      if padding > 0 {
-        fmt.Print(strings.Repeat(" ", padding))
-      }
+  outputPath := ui.PromptInput("Enter output image path (will be saved as PNG)")
+  if !strings.HasSuffix(strings.ToLower(outputPath), ".png") {
+    outputPath += ".png"
+  }
 
-      color.New(color.FgHiCyan).Println(" │")
+  var passphrase []byte
+  if options.passphrase {
+    if passphrase, err = promptNewPassphrase(ui); err != nil {
+      return err
     }
-    color.New(color.FgHiCyan).Println("  └─────────────────────────────────────────────┘")
-    fmt.Println()
+    defer crypto.Wipe(passphrase)
+  }
+
+  keyring, err := useKeyName(ui, &options)
+  if err != nil {
+    return fmt.Errorf("failed to use keyring: %v", err)
+  }
+  defer options.wipeKeys()
+  defer keyring.Wipe()
+
+  encryptor, err := newHideEncryptor(passphrase, options)
+  if err != nil {
+    return fmt.Errorf("failed to initialize encryption: %v", err)
+  }
+  defer encryptor.Destroy()
+
+  ui.StartProgress("Embedding empty vault")
+  encoder, err := vault.New(options.embed).Save(encryptor, inputPath, outputPath)
+  ui.StopProgress()
+  if err != nil {
+    return err
+  }
+
+  var keyringErr error
+  if keyring != nil {
+    keyringErr = noteKeyringImage(keyring, options.keyName, outputPath)
   }
 
+  details := map[string]string{
+    "Input Image": inputPath,
+    "Output Image": outputPath,
+    "Embedding Mode": describeEmbedding(encoder.Header()),
+    "Cipher": encryptor.Cipher().Name(),
+  }
+  if options.embed.Padding != steganography.PaddingNone {
+    details["Padding"] = options.embed.Padding.String()
+  }
+  if encryptor.UsesPassphrase() {
+    details["Key Derivation"] = describeKDF(options.kdf)
+  }
+  ui.PrintDataDetails(details)
+
+  ui.ShowSuccess("Vault created; add entries with vault add")
+  showExtractCredentials(ui, options, encryptor, keyring, keyringErr)
   return nil
 }
 
-func splitMessage(message string, maxLength int) []string {
-  var lines []string
+// openVault extracts the vault hidden in imagePath and opens it with the
+// keyring key named keyName, or with whatever the user says it is sealed
+// with. The encryptor is kept to seal the vault again after a change.
+func openVault(ui *ui.UI, imagePath, keyName string) (*vault.Vault, *crypto.Encryptor, error) {
+  ui.StartProgress("Extracting vault")
+  decoder, err := steganography.NewDecoder(imagePath)
+  if err != nil {
+    ui.StopProgress()
+    return nil, nil, fmt.Errorf("failed to initialize decoder: %v", err)
+  }
+  data, _, _, err := decoder.Extract()
+  ui.StopProgress()
+  if err != nil {
+    if errors.Is(err, steganography.ErrNoPayload) {
+      return nil, nil, errNoContent
+    }
+    return nil, nil, fmt.Errorf("failed to extract vault: %v", err)
+  }
+  if !decoder.IsVault() {
+    return nil, nil, vault.ErrNotVault
+  }
+
+  var encryptor *crypto.Encryptor
+  if keyName != "" {
+    keyring, err := openKeyring(ui, false)
+    if err != nil {
+      return nil, nil, err
+    }
+    defer keyring.Wipe()
+    entry := keyring.Find(keyName)
+    if entry == nil {
+      return nil, nil, fmt.Errorf("no key named %q in the keyring", keyName)
+    }
+    encryptor, err = entry.Encryptor()
+  } else {
+    encryptor, err = promptDecryptor(ui, data)
+  }
+  if err != nil {
+    return nil, nil, fmt.Errorf("failed to initialize decryption: %v", err)
+  }
+
+  ui.StartProgress("Decrypting vault")
+  v, err := vault.Open(decoder, data, encryptor)
+  ui.StopProgress()
+  if err != nil {
+    encryptor.Destroy()
+    return nil, nil, err
+  }
+  return v, encryptor, nil
+}
+
+func saveVault(ui *ui.UI, v *vault.Vault, encryptor *crypto.Encryptor, imagePath, outputPath string) error {
+  ui.StartProgress("Rewriting vault")
+  _, err := v.Save(encryptor, imagePath, outputPath)
+  ui.StopProgress()
+  return err
+}
+
+// splitMessage wraps a message into lines of whole words. The lines are
+// built in byte slices rather than strings, so they can be wiped once shown.
+func splitMessage(message []byte, maxLength int) [][]byte {
+  var lines [][]byte
 
-  words := strings.Fields(message)
+  words := bytes.Fields(message)
   if len(words) == 0 {
-    return []string{""}
+    return [][]byte{nil}
   }
 
-  currentLine := words[0]
-  for i := 1; i < len(words); i++ {
-    if len(currentLine)+1+len(words[i]) <= maxLength {
-      currentLine += " " + words[i]
+  // Words and the single spaces between them never outgrow the message.
+  buffer := append(make([]byte, 0, len(message)), words[0]...)
+  start := 0
+  for _, word := range words[1:] {
+    if len(buffer)-start+1+len(word) <= maxLength {
+      buffer = append(buffer, ' ')
     } else {
-      lines = append(lines, currentLine)
-      currentLine = words[i]
+      lines = append(lines, buffer[start:])
+      start = len(buffer)
     }
+    buffer = append(buffer, word...)
   }
 
-  lines = append(lines, currentLine)
+  lines = append(lines, buffer[start:])
   return lines
 }
 
@@ -0,0 +1,55 @@
+package crypto
+
+import (
+  "bytes"
+  "testing"
+)
+
+func TestCipherKnownAnswers(t *testing.T) {
+  for _, c := range Ciphers {
+    t.Run(c.Name(), func(t *testing.T) {
+      if len(knownAnswers[c.ID()]) == 0 {
+        t.Fatal("no known-answer vectors")
+      }
+      if err := SelfTest(c); err != nil {
+        t.Fatal(err)
+      }
+    })
+  }
+}
+
+func TestCipherRoundTrip(t *testing.T) {
+  plaintext := bytes.Repeat([]byte("round trip "), 100)
+
+  for _, c := range Ciphers {
+    t.Run(c.Name(), func(t *testing.T) {
+      e, err := NewEncryptor()
+      if err != nil {
+        t.Fatal(err)
+      }
+      e.SetCipher(c)
+
+      ciphertext, err := e.Encrypt(plaintext)
+      if err != nil {
+        t.Fatal(err)
+      }
+      if got, err := PayloadCipher(ciphertext); err != nil || got.ID() != c.ID() {
+        t.Fatalf("payload cipher is %v (%v), want %s", got, err, c.Name())
+      }
+
+      decrypted, err := e.Decrypt(ciphertext)
+      if err != nil {
+        t.Fatal(err)
+      }
+      if !bytes.Equal(decrypted, plaintext) {
+        t.Fatal("decrypted data differs from the plaintext")
+      }
+
+      tampered := append([]byte(nil), ciphertext...)
+      tampered[len(tampered)-1] ^= 0x01
+      if _, err := e.Decrypt(tampered); err == nil {
+        t.Error("Decrypt accepted a tampered tag")
+      }
+    })
+  }
+}
@@ -1,8 +1,8 @@
 package crypto
 
 import (
-  "crypto/aes"
-  "crypto/cipher"
+  "bytes"
+  "crypto/ecdh"
   "crypto/rand"
   "errors"
   "io"
@@ -11,54 +11,234 @@ import (
 const (
   keySize   = 32 
   nonceSize = 12 
+  tagSize   = 16
+)
+
+var (
+  ErrPassphraseRequired = wrongKey("data is protected with a passphrase, not a key")
+  ErrKeyRequired        = wrongKey("data is protected with an encryption key")
 )
 
 type Encryptor struct {
-  key []byte
+  key    []byte
+  cipher Cipher
+
+  passphrase []byte
+  params     KDFParams
+  salt       []byte
+  derived    map[string][]byte
+
+  recipients []*ecdh.PublicKey
+  identity   *ecdh.PrivateKey
+
+  master  []byte
+  imageID []byte
+
+  info      *PayloadInfo
+  opened    *PayloadInfo
+  checkInfo func(*PayloadInfo) error
 }
 
 func NewEncryptor() (*Encryptor, error) {
   key := make([]byte, keySize)
+  lockMemory(key)
   if _, err := io.ReadFull(rand.Reader, key); err != nil {
     return nil, err
   }
   return &Encryptor{key: key}, nil
 }
 
+// NewEncryptorWithKey keeps its own copy of key, so the caller may wipe
+// theirs.
 func NewEncryptorWithKey(key []byte) (*Encryptor, error) {
   if len(key) != keySize {
     return nil, errors.New("invalid key size")
   }
-  return &Encryptor{key: key}, nil
+  return &Encryptor{key: secretCopy(key)}, nil
 }
 
-func (e *Encryptor) Encrypt(data []byte) ([]byte, error) {
-  block, err := aes.NewCipher(e.key)
-  if err != nil {
+// NewEncryptorWithPassphrase derives the key from a passphrase with
+// Argon2id. The params only apply to encryption; decryption uses the salt
+// and cost parameters recorded in the envelope.
+func NewEncryptorWithPassphrase(passphrase []byte, params KDFParams) (*Encryptor, error) {
+  e := &Encryptor{}
+  if err := e.setPassphrase(passphrase, params); err != nil {
     return nil, err
   }
+  return e, nil
+}
+
+// NewEncryptorForRecipients seals data under a random file key that is
+// wrapped once for every recipient, so the holder of any of their private
+// keys can open it.
+func NewEncryptorForRecipients(recipients ...*ecdh.PublicKey) (*Encryptor, error) {
+  if len(recipients) == 0 {
+    return nil, errors.New("at least one recipient is required")
+  }
 
-  gcm, err := cipher.NewGCM(block)
+  e, err := NewEncryptor()
   if err != nil {
     return nil, err
   }
+  e.recipients = recipients
+  return e, nil
+}
+
+// AddPassphrase lets a recipient encryptor also be opened with a
+// passphrase, by adding a stanza with the file key wrapped under it.
+func (e *Encryptor) AddPassphrase(passphrase []byte, params KDFParams) error {
+  if !e.UsesRecipients() {
+    return errors.New("a passphrase can only be added to recipient encryption")
+  }
+  return e.setPassphrase(passphrase, params)
+}
+
+// SetKDFParams changes the Argon2id cost the passphrase is derived with
+// when encrypting.
+func (e *Encryptor) SetKDFParams(params KDFParams) error {
+  if !e.UsesPassphrase() {
+    return errors.New("no passphrase to derive a key from")
+  }
+  if err := params.validate(); err != nil {
+    return err
+  }
+  e.params = params
+  return nil
+}
+
+func (e *Encryptor) setPassphrase(passphrase []byte, params KDFParams) error {
+  if len(passphrase) == 0 {
+    return errors.New("passphrase cannot be empty")
+  }
+  if err := params.validate(); err != nil {
+    return err
+  }
+
+  salt := make([]byte, saltSize)
+  if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+    return err
+  }
 
-  nonce := make([]byte, gcm.NonceSize())
-  if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+  e.passphrase = secretCopy(passphrase)
+  e.params = params
+  e.salt = salt
+  return nil
+}
+
+func NewEncryptorWithIdentity(identity *ecdh.PrivateKey) (*Encryptor, error) {
+  if identity == nil {
+    return nil, errors.New("private key cannot be empty")
+  }
+  return &Encryptor{identity: identity}, nil
+}
+
+// SetCipher selects the AEAD used by Encrypt. Decrypt always uses the cipher
+// recorded in the envelope.
+func (e *Encryptor) SetCipher(c Cipher) {
+  e.cipher = c
+}
+
+func (e *Encryptor) Cipher() Cipher {
+  if e.cipher == nil {
+    return DefaultCipher
+  }
+  return e.cipher
+}
+
+func (e *Encryptor) UsesRecipients() bool {
+  return len(e.recipients) > 0
+}
+
+func (e *Encryptor) UsesPassphrase() bool {
+  return e.passphrase != nil
+}
+
+// passphraseKey caches derived keys, since Argon2id is deliberately slow
+// and the same envelope may be opened more than once.
+func (e *Encryptor) passphraseKey(params KDFParams, salt []byte) []byte {
+  id := string(kdfRecord(params, salt).value)
+  if key, ok := e.derived[id]; ok {
+    return key
+  }
+
+  if e.derived == nil {
+    e.derived = make(map[string][]byte)
+  }
+  key := deriveKey(e.passphrase, salt, params)
+  lockMemory(key)
+  e.derived[id] = key
+  return key
+}
+
+func (e *Encryptor) Encrypt(data []byte) ([]byte, error) {
+  var ciphertext bytes.Buffer
+  ciphertext.Grow(len(data) + e.Overhead(len(data)))
+  if err := e.EncryptStream(&ciphertext, bytes.NewReader(data)); err != nil {
     return nil, err
   }
+  return ciphertext.Bytes(), nil
+}
 
-  ciphertext := gcm.Seal(nonce, nonce, data, nil)
-  return ciphertext, nil
+// sealingKey returns the header records describing how the key is wrapped or
+// derived, and the key the payload is sealed with.
+func (e *Encryptor) sealingKey() ([]record, []byte, error) {
+  if e.UsesRecipients() {
+    records := make([]record, 0, len(e.recipients)+1)
+    for _, recipient := range e.recipients {
+      stanza, err := recipientStanza(e.key, recipient)
+      if err != nil {
+        return nil, nil, err
+      }
+      records = append(records, stanza)
+    }
+    if e.UsesPassphrase() {
+      records = append(records, passphraseStanza(e.key, e.params, e.salt, e.passphraseKey(e.params, e.salt)))
+    }
+    return records, e.key, nil
+  }
+  if e.UsesPassphrase() {
+    return []record{kdfRecord(e.params, e.salt)}, e.passphraseKey(e.params, e.salt), nil
+  }
+  if e.UsesMaster() {
+    return []record{e.masterRecord()}, e.key, nil
+  }
+  return nil, e.key, nil
 }
 
+// Decrypt opens any format this package has written: segmented streams,
+// single-shot envelopes from before segmenting, and bare legacy payloads.
 func (e *Encryptor) Decrypt(ciphertext []byte) ([]byte, error) {
-  block, err := aes.NewCipher(e.key)
+  env, err := parseEnvelope(ciphertext)
+  if err != nil {
+    return e.decryptLegacy(ciphertext)
+  }
+  if env.find(tagStream) != nil {
+    return e.decryptStream(ciphertext)
+  }
+
+  key, scratch, err := e.envelopeKey(env)
   if err != nil {
     return nil, err
   }
+  if scratch {
+    defer Wipe(key)
+  }
 
-  gcm, err := cipher.NewGCM(block)
+  plaintext, err := open(env, key)
+  if err != nil {
+    return nil, authError(env, err)
+  }
+  return plaintext, nil
+}
+
+// decryptLegacy opens the original bare nonce | ciphertext format, which is
+// always AES-256-GCM.
+func (e *Encryptor) decryptLegacy(ciphertext []byte) ([]byte, error) {
+  if e.key == nil || e.UsesMaster() {
+    return nil, ErrKeyRequired
+  }
+
+  gcm, err := newGCM(e.key)
   if err != nil {
     return nil, err
   }
@@ -78,6 +258,131 @@ func (e *Encryptor) Decrypt(ciphertext []byte) ([]byte, error) {
   return plaintext, nil
 }
 
+// envelopeKey finds the key that opens env with this encryptor's
+// credentials. A scratch key was unwrapped or derived for env alone, and
+// the caller wipes it once done; any other key belongs to the encryptor.
+func (e *Encryptor) envelopeKey(env *envelope) (key []byte, scratch bool, err error) {
+  // Every passphrase record costs a key derivation, and Encrypt never
+  // writes more than one.
+  if env.count(tagKDF)+env.count(tagPassphraseStanza) > 1 {
+    return nil, false, errors.New("malformed envelope: more than one passphrase record")
+  }
+
+  if kdf := env.find(tagKDF); kdf != nil {
+    if !e.UsesPassphrase() {
+      return nil, false, ErrPassphraseRequired
+    }
+
+    params, salt, err := parseKDFRecord(kdf)
+    if err != nil {
+      return nil, false, err
+    }
+    return e.passphraseKey(params, salt), false, nil
+  }
+  if env.find(tagRecipient) != nil || env.find(tagPassphraseStanza) != nil {
+    key, err = e.unwrapFileKey(env)
+    return key, true, err
+  }
+
+  // A master encryptor's own key is only for the image it seals; anyone
+  // else holding an image key can open just that image.
+  master := env.find(tagMaster)
+  switch {
+  case master != nil && e.UsesMaster():
+    key, err = e.masterImageKey(master)
+    return key, true, err
+  case master != nil && e.key == nil:
+    return nil, false, ErrMasterRequired
+  case e.key == nil || e.UsesMaster():
+    return nil, false, ErrKeyRequired
+  }
+  return e.key, false, nil
+}
+
+// authError explains the payload failing to authenticate when there is no
+// key check value. A derived key is then only checked by opening the
+// payload, so a wrong passphrase looks the same as corruption; a file key
+// from a stanza is already known to be right.
+func authError(env *envelope, err error) error {
+  if env.find(tagKDF) != nil {
+    return errors.New("incorrect passphrase or corrupted data")
+  }
+  if env.find(tagStream) == nil && (env.find(tagRecipient) != nil || env.find(tagPassphraseStanza) != nil) {
+    return ErrCorruptPayload
+  }
+  return err
+}
+
+// unwrapFileKey looks for a stanza this encryptor's private key or
+// passphrase can unwrap and returns the file key inside.
+func (e *Encryptor) unwrapFileKey(env *envelope) ([]byte, error) {
+  if e.identity == nil && !e.UsesPassphrase() {
+    return nil, ErrIdentityRequired
+  }
+
+  for _, r := range env.records {
+    var fileKey []byte
+    switch {
+    case r.tag == tagRecipient && e.identity != nil:
+      fileKey = unwrapStanza(r.value, e.identity)
+    case r.tag == tagPassphraseStanza && e.UsesPassphrase():
+      fileKey = e.unwrapPassphraseStanza(r.value)
+    }
+    if fileKey != nil {
+      return fileKey, nil
+    }
+  }
+
+  if e.identity != nil {
+    return nil, wrongKey("data is not encrypted to this private key")
+  }
+  return nil, wrongKey("incorrect passphrase or data is not encrypted to a passphrase")
+}
+
+func open(env *envelope, key []byte) ([]byte, error) {
+  c, err := env.cipher()
+  if err != nil {
+    return nil, err
+  }
+
+  aead, err := c.New(key)
+  if err != nil {
+    return nil, err
+  }
+
+  if len(env.body) < aead.NonceSize() {
+    return nil, errors.New("ciphertext too short")
+  }
+
+  nonce := env.body[:aead.NonceSize()]
+  return aead.Open(nil, nonce, env.body[aead.NonceSize():], env.header)
+}
+
+// Overhead is how many bytes Encrypt adds to plainLen bytes of plaintext.
+// The info record goes in the envelope header, the info itself is sealed
+// ahead of the plaintext.
+func (e *Encryptor) Overhead(plainLen int) int {
+  infoRecordSize, infoSize := 0, 0
+  if e.info != nil {
+    infoRecordSize, infoSize = 3+1, e.info.size()
+  }
+
+  overhead := envelopePrefix + 3 + 1 + 3 + streamRecordSize + 3 + keyCheckSize + infoRecordSize + infoSize +
+    e.Cipher().NonceSize() - streamCounterSize + streamSegments(plainLen+infoSize)*tagSize
+  switch {
+  case e.UsesRecipients():
+    overhead += len(e.recipients) * (3 + stanzaSize)
+    if e.UsesPassphrase() {
+      overhead += 3 + passphraseStanzaSize
+    }
+  case e.UsesPassphrase():
+    overhead += 3 + kdfRecordSize
+  case e.UsesMaster():
+    overhead += 3 + masterRecordSize
+  }
+  return overhead
+}
+
 func (e *Encryptor) GetKey() []byte {
   return e.key
 }
@@ -0,0 +1,51 @@
+package crypto
+
+import (
+  "fmt"
+  "testing"
+)
+
+// Padding sizes payloads from Overhead, so it has to be exact, including
+// where the sealed info pushes the plaintext over a segment boundary.
+func TestOverheadMatchesEncrypt(t *testing.T) {
+  info := &PayloadInfo{Author: "alice", Comment: "overhead"}
+  sizes := func(infoSize int) []int {
+    var sizes []int
+    for _, boundary := range []int{0, DefaultSegmentSize, 2 * DefaultSegmentSize} {
+      for _, edge := range []int{boundary, boundary - infoSize} {
+        for delta := -1; delta <= 1; delta++ {
+          if edge+delta >= 0 {
+            sizes = append(sizes, edge+delta)
+          }
+        }
+      }
+    }
+    return sizes
+  }
+
+  for _, withInfo := range []bool{false, true} {
+    e, err := NewEncryptor()
+    if err != nil {
+      t.Fatal(err)
+    }
+    infoSize := 0
+    if withInfo {
+      if err := e.SetInfo(info); err != nil {
+        t.Fatal(err)
+      }
+      infoSize = info.size()
+    }
+
+    for _, size := range sizes(infoSize) {
+      t.Run(fmt.Sprintf("info=%v/%d", withInfo, size), func(t *testing.T) {
+        ciphertext, err := e.Encrypt(make([]byte, size))
+        if err != nil {
+          t.Fatal(err)
+        }
+        if got, want := len(ciphertext), size+e.Overhead(size); got != want {
+          t.Errorf("len(Encrypt) = %d, want %d", got, want)
+        }
+      })
+    }
+  }
+}
@@ -0,0 +1,134 @@
+package crypto
+
+import (
+  "bytes"
+  "encoding/binary"
+  "errors"
+)
+
+// Encrypted payloads are wrapped in an envelope whose header describes how
+// to open them:
+//
+//   "SGE" | version | header length (2) | records... | body
+//
+// Each record is tag (1) | length (2) | value. The whole header is bound
+// to the ciphertext as associated data, so it cannot be altered without
+// failing authentication. With a stream record the body is a sequence of
+// segments (see stream.go), otherwise it is a single nonce | ciphertext.
+// Payloads without the magic are the original bare nonce | ciphertext format.
+const (
+  envelopeMagic   = "SGE"
+  envelopeVersion = byte(1)
+  envelopePrefix  = len(envelopeMagic) + 1 + 2
+
+  tagKDF              byte = 0x01
+  tagRecipient        byte = 0x02
+  tagPassphraseStanza byte = 0x03
+  tagCipher           byte = 0x04
+  tagStream           byte = 0x05
+  tagMaster           byte = 0x06
+  tagKeyCheck         byte = 0x07
+  tagInfo             byte = 0x08
+)
+
+type record struct {
+  tag   byte
+  value []byte
+}
+
+type envelope struct {
+  header  []byte
+  records []record
+  body    []byte
+}
+
+func recordsSize(records []record) int {
+  size := 0
+  for _, r := range records {
+    size += 3 + len(r.value)
+  }
+  return size
+}
+
+func appendRecords(dst []byte, records []record) []byte {
+  for _, r := range records {
+    dst = append(dst, r.tag)
+    dst = binary.BigEndian.AppendUint16(dst, uint16(len(r.value)))
+    dst = append(dst, r.value...)
+  }
+  return dst
+}
+
+func parseRecords(data []byte) ([]record, error) {
+  var records []record
+  for rest := data; len(rest) > 0; {
+    if len(rest) < 3 {
+      return nil, errors.New("malformed records")
+    }
+    length := int(binary.BigEndian.Uint16(rest[1:3]))
+    if 3+length > len(rest) {
+      return nil, errors.New("malformed records")
+    }
+    records = append(records, record{tag: rest[0], value: rest[3 : 3+length]})
+    rest = rest[3+length:]
+  }
+  return records, nil
+}
+
+func marshalHeader(records []record) []byte {
+  size := envelopePrefix + recordsSize(records)
+
+  header := make([]byte, 0, size)
+  header = append(header, envelopeMagic...)
+  header = append(header, envelopeVersion)
+  header = binary.BigEndian.AppendUint16(header, uint16(size-envelopePrefix))
+  return appendRecords(header, records)
+}
+
+func isEnvelope(data []byte) bool {
+  return len(data) >= envelopePrefix && bytes.HasPrefix(data, []byte(envelopeMagic))
+}
+
+func parseEnvelope(data []byte) (*envelope, error) {
+  if !isEnvelope(data) {
+    return nil, errors.New("not an encrypted envelope")
+  }
+  if data[len(envelopeMagic)] != envelopeVersion {
+    return nil, errors.New("unsupported envelope version")
+  }
+
+  size := envelopePrefix + int(binary.BigEndian.Uint16(data[len(envelopeMagic)+1:]))
+  if size > len(data) {
+    return nil, errors.New("truncated envelope header")
+  }
+
+  records, err := parseRecords(data[envelopePrefix:size])
+  if err != nil {
+    return nil, errors.New("malformed envelope header")
+  }
+
+  return &envelope{
+    header:  data[:size],
+    records: records,
+    body:    data[size:],
+  }, nil
+}
+
+func (env *envelope) count(tag byte) int {
+  n := 0
+  for _, r := range env.records {
+    if r.tag == tag {
+      n++
+    }
+  }
+  return n
+}
+
+func (env *envelope) find(tag byte) []byte {
+  for _, r := range env.records {
+    if r.tag == tag {
+      return r.value
+    }
+  }
+  return nil
+}
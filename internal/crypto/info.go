@@ -0,0 +1,197 @@
+package crypto
+
+import (
+  "bytes"
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "io"
+  "time"
+  "unicode/utf8"
+)
+
+// A payload can carry an info block sealed in front of its plaintext, so
+// when it was made, by whom and until when it may be opened are encrypted
+// and authenticated with the content. The envelope header only records
+// that there is one; the block itself is a length and records in the same
+// tag | length | value form as the header:
+//
+//   length (2) | records...
+const (
+  infoVersion    = byte(1)
+  infoLengthSize = 2
+
+  infoCreated byte = 0x01
+  infoAuthor  byte = 0x02
+  infoComment byte = 0x03
+  infoExpires byte = 0x04
+
+  MaxInfoAuthor  = 255
+  MaxInfoComment = 4096
+)
+
+var ErrExpired = errors.New("content has expired")
+
+// PayloadInfo describes a payload. Zero times and empty strings are left
+// out; a zero Expires means the payload never expires.
+type PayloadInfo struct {
+  Created time.Time
+  Author  string
+  Comment string
+  Expires time.Time
+}
+
+func (i *PayloadInfo) Expired(now time.Time) bool {
+  return !i.Expires.IsZero() && now.After(i.Expires)
+}
+
+func (i *PayloadInfo) validate() error {
+  if len(i.Author) > MaxInfoAuthor {
+    return fmt.Errorf("author is longer than %d bytes", MaxInfoAuthor)
+  }
+  if len(i.Comment) > MaxInfoComment {
+    return fmt.Errorf("comment is longer than %d bytes", MaxInfoComment)
+  }
+  if !utf8.ValidString(i.Author) || !utf8.ValidString(i.Comment) {
+    return errors.New("author and comment must be valid UTF-8")
+  }
+  if !i.Created.IsZero() && !i.Expires.IsZero() && !i.Expires.After(i.Created) {
+    return errors.New("expiry must be after the creation time")
+  }
+  return nil
+}
+
+func (i *PayloadInfo) records() []record {
+  var records []record
+  if !i.Created.IsZero() {
+    records = append(records, timeRecord(infoCreated, i.Created))
+  }
+  if i.Author != "" {
+    records = append(records, record{tag: infoAuthor, value: []byte(i.Author)})
+  }
+  if i.Comment != "" {
+    records = append(records, record{tag: infoComment, value: []byte(i.Comment)})
+  }
+  if !i.Expires.IsZero() {
+    records = append(records, timeRecord(infoExpires, i.Expires))
+  }
+  return records
+}
+
+func timeRecord(tag byte, t time.Time) record {
+  return record{tag: tag, value: binary.BigEndian.AppendUint64(nil, uint64(t.Unix()))}
+}
+
+func (i *PayloadInfo) marshal() []byte {
+  records := i.records()
+  block := make([]byte, 0, infoLengthSize+recordsSize(records))
+  block = binary.BigEndian.AppendUint16(block, uint16(recordsSize(records)))
+  return appendRecords(block, records)
+}
+
+func (i *PayloadInfo) size() int {
+  return infoLengthSize + recordsSize(i.records())
+}
+
+func parseInfo(data []byte) (*PayloadInfo, error) {
+  records, err := parseRecords(data)
+  if err != nil {
+    return nil, errors.New("malformed payload info")
+  }
+
+  info := &PayloadInfo{}
+  for _, r := range records {
+    switch r.tag {
+    case infoCreated, infoExpires:
+      if len(r.value) != 8 {
+        return nil, errors.New("malformed payload info")
+      }
+      t := time.Unix(int64(binary.BigEndian.Uint64(r.value)), 0)
+      if r.tag == infoCreated {
+        info.Created = t
+      } else {
+        info.Expires = t
+      }
+    case infoAuthor:
+      info.Author = string(r.value)
+    case infoComment:
+      info.Comment = string(r.value)
+    }
+  }
+  return info, nil
+}
+
+// SetInfo seals info in front of everything Encrypt and EncryptStream
+// write from now on.
+func (e *Encryptor) SetInfo(info *PayloadInfo) error {
+  if info != nil {
+    if err := info.validate(); err != nil {
+      return err
+    }
+  }
+  e.info = info
+  return nil
+}
+
+// Info is the info found by the last Decrypt or DecryptStream, nil when the
+// payload carried none.
+func (e *Encryptor) Info() *PayloadInfo {
+  return e.opened
+}
+
+// SetInfoCheck has DecryptStream hand a payload's info to check before it
+// writes any of the content, and stop with check's error. Payloads without
+// info are not checked.
+func (e *Encryptor) SetInfoCheck(check func(*PayloadInfo) error) {
+  e.checkInfo = check
+}
+
+func infoRecord() record {
+  return record{tag: tagInfo, value: []byte{infoVersion}}
+}
+
+// infoWriter takes the info block off the front of a decrypted stream and
+// passes the rest on to dst.
+type infoWriter struct {
+  dst   io.Writer
+  block []byte
+  info  *PayloadInfo
+  check func(*PayloadInfo) error
+}
+
+func (w *infoWriter) Write(p []byte) (int, error) {
+  n := len(p)
+  for w.info == nil && len(p) > 0 {
+    want := infoLengthSize
+    if len(w.block) >= infoLengthSize {
+      want += int(binary.BigEndian.Uint16(w.block))
+    }
+    take := min(want-len(w.block), len(p))
+    w.block = append(w.block, p[:take]...)
+    p = p[take:]
+
+    if len(w.block) >= infoLengthSize && len(w.block) == infoLengthSize+int(binary.BigEndian.Uint16(w.block)) {
+      info, err := parseInfo(w.block[infoLengthSize:])
+      if err != nil {
+        return 0, err
+      }
+      w.info = info
+      if w.check != nil {
+        if err := w.check(info); err != nil {
+          return 0, err
+        }
+      }
+    }
+  }
+
+  if len(p) > 0 {
+    if _, err := w.dst.Write(p); err != nil {
+      return 0, err
+    }
+  }
+  return n, nil
+}
+
+func infoReader(info *PayloadInfo, src io.Reader) io.Reader {
+  return io.MultiReader(bytes.NewReader(info.marshal()), src)
+}
@@ -0,0 +1,65 @@
+package crypto
+
+import (
+  "crypto/hmac"
+  "crypto/sha256"
+  "errors"
+)
+
+// Every stream carries a key check value, a truncated HMAC of a constant
+// under the payload key. It lets Decrypt tell a wrong key from a payload
+// damaged in the image before opening a single segment; once the key is
+// known to be right, any segment failing to authenticate is corruption.
+const (
+  keyCheckSize  = 8
+  keyCheckLabel = "steg-go key check value"
+)
+
+var (
+  ErrWrongKey       = errors.New("wrong key")
+  ErrCorruptPayload = errors.New("corrupted data")
+)
+
+// keyError is an error meaning the credentials given cannot open the data,
+// so errors.Is(err, ErrWrongKey) holds for all of them.
+type keyError struct {
+  msg string
+}
+
+func wrongKey(msg string) error {
+  return &keyError{msg: msg}
+}
+
+func (e *keyError) Error() string {
+  return e.msg
+}
+
+func (e *keyError) Is(target error) bool {
+  return target == ErrWrongKey
+}
+
+func keyCheckValue(key []byte) []byte {
+  mac := hmac.New(sha256.New, key)
+  mac.Write([]byte(keyCheckLabel))
+  return mac.Sum(nil)[:keyCheckSize]
+}
+
+func keyCheckRecord(key []byte) record {
+  return record{tag: tagKeyCheck, value: keyCheckValue(key)}
+}
+
+// checkKey compares key against the envelope's key check value. It reports
+// whether there was one to compare against.
+func checkKey(env *envelope, key []byte) (bool, error) {
+  check := env.find(tagKeyCheck)
+  if check == nil {
+    return false, nil
+  }
+  if !hmac.Equal(check, keyCheckValue(key)) {
+    if env.find(tagKDF) != nil {
+      return true, wrongKey("incorrect passphrase")
+    }
+    return true, wrongKey("wrong encryption key")
+  }
+  return true, nil
+}
@@ -0,0 +1,203 @@
+package crypto
+
+import (
+  "crypto/sha256"
+  _ "embed"
+  "encoding/hex"
+  "errors"
+  "fmt"
+  "math/big"
+  "strings"
+)
+
+// Keys can be written as hex, as Base58Check or as a BIP39 mnemonic, which
+// are easier to read out or copy by hand and carry a checksum that catches
+// typos. A 256-bit key is 24 words: 11 bits per word over the key followed
+// by the first byte of its SHA-256. Other data is written the same way with
+// one checksum bit for every 32 bits, as BIP39 does.
+
+//go:embed wordlist_english.txt
+var wordlistEnglish string
+
+var (
+  mnemonicWords = strings.Fields(wordlistEnglish)
+  mnemonicIndex = func() map[string]int {
+    index := make(map[string]int, len(mnemonicWords))
+    for i, word := range mnemonicWords {
+      index[word] = i
+    }
+    return index
+  }()
+)
+
+const (
+  base58Alphabet   = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+  base58KeyVersion = byte(0x53)
+
+  mnemonicWordBits = 11
+)
+
+// EncodeKeyMnemonic writes a key as 24 words from the BIP39 English list.
+func EncodeKeyMnemonic(key []byte) (string, error) {
+  if len(key) != keySize {
+    return "", errors.New("invalid key size")
+  }
+  return encodeMnemonic(key), nil
+}
+
+// ParseKeyMnemonic reads a mnemonic written by EncodeKeyMnemonic. Words are
+// unique in their first four letters, so those are enough.
+func ParseKeyMnemonic(mnemonic string) ([]byte, error) {
+  return parseMnemonic(mnemonic, keySize)
+}
+
+func mnemonicChecksumBits(size int) int {
+  return size * 8 / 32
+}
+
+func mnemonicWordCount(size int) int {
+  return (size*8 + mnemonicChecksumBits(size)) / mnemonicWordBits
+}
+
+// mnemonicChecksum is the first checksumBits bits of the SHA-256 of data.
+func mnemonicChecksum(data []byte) *big.Int {
+  sum := sha256.Sum256(data)
+  return new(big.Int).Rsh(new(big.Int).SetBytes(sum[:]), uint(256-mnemonicChecksumBits(len(data))))
+}
+
+func encodeMnemonic(data []byte) string {
+  bits := new(big.Int).SetBytes(data)
+  bits.Lsh(bits, uint(mnemonicChecksumBits(len(data))))
+  bits.Or(bits, mnemonicChecksum(data))
+
+  words := make([]string, mnemonicWordCount(len(data)))
+  mask := big.NewInt(1<<mnemonicWordBits - 1)
+  for i := len(words) - 1; i >= 0; i-- {
+    words[i] = mnemonicWords[new(big.Int).And(bits, mask).Int64()]
+    bits.Rsh(bits, mnemonicWordBits)
+  }
+  return strings.Join(words, " ")
+}
+
+func parseMnemonic(mnemonic string, size int) ([]byte, error) {
+  words := strings.Fields(strings.ToLower(mnemonic))
+  if length := mnemonicWordCount(size); len(words) != length {
+    return nil, fmt.Errorf("expected %d words, got %d", length, len(words))
+  }
+
+  bits := new(big.Int)
+  for n, word := range words {
+    i, ok := lookupMnemonicWord(word)
+    if !ok {
+      return nil, fmt.Errorf("word %d (%q) is not in the word list", n+1, word)
+    }
+    bits.Lsh(bits, mnemonicWordBits)
+    bits.Or(bits, big.NewInt(int64(i)))
+  }
+
+  checksum := new(big.Int).And(bits, big.NewInt(1<<mnemonicChecksumBits(size)-1))
+  data := bits.Rsh(bits, uint(mnemonicChecksumBits(size))).FillBytes(make([]byte, size))
+  if mnemonicChecksum(data).Cmp(checksum) != 0 {
+    return nil, errors.New("mnemonic checksum does not match; check the words for typos")
+  }
+  return data, nil
+}
+
+func lookupMnemonicWord(word string) (int, bool) {
+  if i, ok := mnemonicIndex[word]; ok {
+    return i, true
+  }
+  if len(word) < 4 {
+    return 0, false
+  }
+
+  match := -1
+  for i, candidate := range mnemonicWords {
+    if strings.HasPrefix(candidate, word) {
+      if match >= 0 {
+        return 0, false
+      }
+      match = i
+    }
+  }
+  return match, match >= 0
+}
+
+// EncodeKeyBase58 writes a key as Base58Check: a version byte, the key and
+// the first four bytes of its double SHA-256.
+func EncodeKeyBase58(key []byte) string {
+  payload := append([]byte{base58KeyVersion}, key...)
+  payload = append(payload, base58Checksum(payload)...)
+
+  n := new(big.Int).SetBytes(payload)
+  radix := big.NewInt(int64(len(base58Alphabet)))
+  mod := new(big.Int)
+
+  var encoded []byte
+  for n.Sign() > 0 {
+    n.DivMod(n, radix, mod)
+    encoded = append(encoded, base58Alphabet[mod.Int64()])
+  }
+  for _, b := range payload {
+    if b != 0 {
+      break
+    }
+    encoded = append(encoded, base58Alphabet[0])
+  }
+
+  for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+    encoded[i], encoded[j] = encoded[j], encoded[i]
+  }
+  return string(encoded)
+}
+
+func ParseKeyBase58(s string) ([]byte, error) {
+  s = strings.TrimSpace(s)
+  n := new(big.Int)
+  radix := big.NewInt(int64(len(base58Alphabet)))
+  for i, c := range s {
+    digit := strings.IndexRune(base58Alphabet, c)
+    if digit < 0 {
+      return nil, fmt.Errorf("invalid Base58 character %q at position %d", c, i+1)
+    }
+    n.Mul(n, radix)
+    n.Add(n, big.NewInt(int64(digit)))
+  }
+
+  size := 1 + keySize + 4
+  if n.BitLen() > size*8 {
+    return nil, errors.New("Base58 key is too long")
+  }
+  payload := n.FillBytes(make([]byte, size))
+  if payload[0] != base58KeyVersion {
+    return nil, errors.New("Base58 key is incomplete or not an encryption key")
+  }
+  if string(base58Checksum(payload[:size-4])) != string(payload[size-4:]) {
+    return nil, errors.New("Base58 checksum does not match; check the key for typos")
+  }
+  return payload[1 : 1+keySize], nil
+}
+
+func base58Checksum(payload []byte) []byte {
+  first := sha256.Sum256(payload)
+  second := sha256.Sum256(first[:])
+  return second[:4]
+}
+
+// ParseKey reads a key written as hex, Base58Check or a mnemonic.
+func ParseKey(s string) ([]byte, error) {
+  s = strings.TrimSpace(s)
+  switch {
+  case strings.ContainsAny(s, " \t\n"):
+    return ParseKeyMnemonic(s)
+  case len(s) == hex.EncodedLen(keySize):
+    key, err := hex.DecodeString(s)
+    if err != nil {
+      return nil, errors.New("invalid encryption key format: must be hexadecimal")
+    }
+    return key, nil
+  case s == "":
+    return nil, errors.New("encryption key cannot be empty")
+  }
+  return ParseKeyBase58(s)
+}
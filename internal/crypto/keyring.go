@@ -0,0 +1,353 @@
+package crypto
+
+import (
+  "bytes"
+  "crypto/ecdh"
+  "encoding/hex"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "os"
+  "path/filepath"
+  "strings"
+  "time"
+)
+
+// A keyring keeps keys by name in a file sealed with a passphrase, as an
+// ordinary Argon2id envelope around a JSON list, and remembers which images
+// each key was used for. Keys are held as bytes so they can be wiped, and
+// stored in hex, or in the stegsec: form for private keys.
+const (
+  KeyringKey      = "key"
+  KeyringIdentity = "identity"
+  KeyringMaster   = "master"
+)
+
+type KeyringEntry struct {
+  Name    string
+  Type    string
+  Key     []byte
+  Created time.Time
+  Images  []string
+}
+
+// keyringEntryJSON is a KeyringEntry as stored, with the key as a JSON
+// string built from its bytes.
+type keyringEntryJSON struct {
+  Name    string          `json:"name"`
+  Type    string          `json:"type"`
+  Key     json.RawMessage `json:"key"`
+  Created time.Time       `json:"created"`
+  Images  []string        `json:"images,omitempty"`
+}
+
+type Keyring struct {
+  Entries []*KeyringEntry `json:"entries"`
+
+  path       string
+  passphrase []byte
+}
+
+// DefaultKeyringPath is $STEG_KEYRING, or keyring in the steg-go directory
+// of the user config dir when that is not set.
+func DefaultKeyringPath() string {
+  if path := os.Getenv("STEG_KEYRING"); path != "" {
+    return path
+  }
+  dir, err := os.UserConfigDir()
+  if err != nil {
+    return "keyring"
+  }
+  return filepath.Join(dir, "steg-go", "keyring")
+}
+
+// OpenKeyring decrypts the keyring at path. A missing file is an empty
+// keyring that Save will create under the same passphrase.
+func OpenKeyring(path string, passphrase []byte) (*Keyring, error) {
+  if len(passphrase) == 0 {
+    return nil, errors.New("keyring passphrase cannot be empty")
+  }
+  keyring := &Keyring{path: path, passphrase: passphrase}
+
+  data, err := os.ReadFile(path)
+  if errors.Is(err, os.ErrNotExist) {
+    return keyring, nil
+  }
+  if err != nil {
+    return nil, err
+  }
+
+  encryptor, err := NewEncryptorWithPassphrase(passphrase, DefaultKDFParams)
+  if err != nil {
+    return nil, err
+  }
+  defer encryptor.Destroy()
+  plaintext, err := encryptor.Decrypt(data)
+  if err != nil {
+    return nil, fmt.Errorf("failed to open keyring: %v", err)
+  }
+  defer Wipe(plaintext)
+
+  if err := json.Unmarshal(plaintext, keyring); err != nil {
+    return nil, fmt.Errorf("malformed keyring: %v", err)
+  }
+  return keyring, nil
+}
+
+func (k *Keyring) Path() string {
+  return k.path
+}
+
+// Wipe zeroes every key and the passphrase. The keyring cannot be saved
+// after. A nil keyring is left alone, for callers that may not have one.
+func (k *Keyring) Wipe() {
+  if k == nil {
+    return
+  }
+  for _, entry := range k.Entries {
+    Wipe(entry.Key)
+  }
+  Wipe(k.passphrase)
+}
+
+// Save re-encrypts the keyring with a fresh salt and replaces the file.
+func (k *Keyring) Save() error {
+  plaintext, err := json.Marshal(k)
+  if err != nil {
+    return err
+  }
+  defer Wipe(plaintext)
+
+  encryptor, err := NewEncryptorWithPassphrase(k.passphrase, DefaultKDFParams)
+  if err != nil {
+    return err
+  }
+  defer encryptor.Destroy()
+  data, err := encryptor.Encrypt(plaintext)
+  if err != nil {
+    return err
+  }
+
+  if err := os.MkdirAll(filepath.Dir(k.path), 0700); err != nil {
+    return err
+  }
+  temp := k.path + ".tmp"
+  if err := os.WriteFile(temp, data, 0600); err != nil {
+    return err
+  }
+  return os.Rename(temp, k.path)
+}
+
+func (k *Keyring) Find(name string) *KeyringEntry {
+  for _, entry := range k.Entries {
+    if entry.Name == name {
+      return entry
+    }
+  }
+  return nil
+}
+
+func (k *Keyring) Add(entry *KeyringEntry) error {
+  if err := validKeyName(entry.Name); err != nil {
+    return err
+  }
+  if k.Find(entry.Name) != nil {
+    return fmt.Errorf("a key named %q already exists", entry.Name)
+  }
+  if _, err := entry.Encryptor(); err != nil {
+    return err
+  }
+  k.Entries = append(k.Entries, entry)
+  return nil
+}
+
+func (k *Keyring) Delete(name string) error {
+  for i, entry := range k.Entries {
+    if entry.Name == name {
+      k.Entries = append(k.Entries[:i], k.Entries[i+1:]...)
+      return nil
+    }
+  }
+  return fmt.Errorf("no key named %q", name)
+}
+
+func (k *Keyring) Rename(name, newName string) error {
+  entry := k.Find(name)
+  if entry == nil {
+    return fmt.Errorf("no key named %q", name)
+  }
+  if err := validKeyName(newName); err != nil {
+    return err
+  }
+  if k.Find(newName) != nil {
+    return fmt.Errorf("a key named %q already exists", newName)
+  }
+  entry.Name = newName
+  return nil
+}
+
+func validKeyName(name string) error {
+  if strings.TrimSpace(name) == "" {
+    return errors.New("key name cannot be empty")
+  }
+  if strings.ContainsAny(name, " \t\r\n") {
+    return errors.New("key name cannot contain whitespace")
+  }
+  return nil
+}
+
+func NewKeyringKey(name string, key []byte) *KeyringEntry {
+  return &KeyringEntry{Name: name, Type: KeyringKey, Key: bytes.Clone(key), Created: time.Now()}
+}
+
+func NewKeyringMaster(name string, master []byte) *KeyringEntry {
+  return &KeyringEntry{Name: name, Type: KeyringMaster, Key: bytes.Clone(master), Created: time.Now()}
+}
+
+func NewKeyringIdentity(name string, identity *ecdh.PrivateKey) *KeyringEntry {
+  return &KeyringEntry{Name: name, Type: KeyringIdentity, Key: identity.Bytes(), Created: time.Now()}
+}
+
+func (e *KeyringEntry) MarshalJSON() ([]byte, error) {
+  key := []byte{'"'}
+  if e.Type == KeyringIdentity {
+    key = append(key, IdentityPrefix...)
+    key = keyEncoding.AppendEncode(key, e.Key)
+  } else {
+    key = hex.AppendEncode(key, e.Key)
+  }
+  key = append(key, '"')
+  defer Wipe(key)
+
+  return json.Marshal(keyringEntryJSON{Name: e.Name, Type: e.Type, Key: key, Created: e.Created, Images: e.Images})
+}
+
+func (e *KeyringEntry) UnmarshalJSON(data []byte) error {
+  var stored keyringEntryJSON
+  if err := json.Unmarshal(data, &stored); err != nil {
+    return err
+  }
+  defer Wipe(stored.Key)
+
+  key, err := decodeKeyringKey(stored.Type, stored.Key)
+  if err != nil {
+    return fmt.Errorf("key %q is malformed", stored.Name)
+  }
+  *e = KeyringEntry{Name: stored.Name, Type: stored.Type, Key: key, Created: stored.Created, Images: stored.Images}
+  return nil
+}
+
+// decodeKeyringKey reads a stored key from its JSON string without making
+// a Go string of it.
+func decodeKeyringKey(keyType string, text []byte) ([]byte, error) {
+  if len(text) < 2 || text[0] != '"' || text[len(text)-1] != '"' {
+    return nil, errors.New("key is not a string")
+  }
+  text = text[1 : len(text)-1]
+
+  if keyType == KeyringIdentity {
+    encoded, ok := bytes.CutPrefix(text, []byte(IdentityPrefix))
+    if !ok {
+      return nil, fmt.Errorf("private key must start with %q", IdentityPrefix)
+    }
+    key := make([]byte, keyEncoding.DecodedLen(len(encoded)))
+    n, err := keyEncoding.Decode(key, encoded)
+    if err != nil {
+      Wipe(key)
+      return nil, err
+    }
+    return key[:n], nil
+  }
+
+  key := make([]byte, hex.DecodedLen(len(text)))
+  if _, err := hex.Decode(key, text); err != nil {
+    Wipe(key)
+    return nil, err
+  }
+  return key, nil
+}
+
+// ParseKeyringEntry reads an encryption key in any form ParseKey accepts,
+// or a stegsec: private key.
+func ParseKeyringEntry(name, value string) (*KeyringEntry, error) {
+  value = strings.TrimSpace(value)
+  if strings.HasPrefix(value, IdentityPrefix) {
+    identity, err := ParseIdentity(value)
+    if err != nil {
+      return nil, err
+    }
+    return NewKeyringIdentity(name, identity), nil
+  }
+
+  key, err := ParseKey(value)
+  if err != nil {
+    return nil, err
+  }
+  defer Wipe(key)
+  return NewKeyringKey(name, key), nil
+}
+
+// Identity is the private key of an identity entry.
+func (e *KeyringEntry) Identity() (*ecdh.PrivateKey, error) {
+  if e.Type != KeyringIdentity {
+    return nil, fmt.Errorf("key %q is not a private key", e.Name)
+  }
+  identity, err := ecdh.X25519().NewPrivateKey(e.Key)
+  if err != nil {
+    return nil, fmt.Errorf("key %q is malformed: %v", e.Name, err)
+  }
+  return identity, nil
+}
+
+// Encryptor opens payloads sealed with the entry's key or with keys derived
+// from it, or encrypted to the public key of its identity.
+func (e *KeyringEntry) Encryptor() (*Encryptor, error) {
+  switch e.Type {
+  case KeyringKey:
+    return NewEncryptorWithKey(e.Key)
+  case KeyringMaster:
+    return NewEncryptorWithMaster(e.Key)
+  case KeyringIdentity:
+    identity, err := e.Identity()
+    if err != nil {
+      return nil, err
+    }
+    return NewEncryptorWithIdentity(identity)
+  }
+  return nil, fmt.Errorf("key %q has unknown type %q", e.Name, e.Type)
+}
+
+// Match tries every key in the keyring against data and returns the entry
+// that opens it. A key that passes the key check value or authenticates
+// the first segment is the right one even if the payload is damaged, so
+// Decrypt can report where. Only the payload is tried: image headers are
+// not derived from a key, so there is no per-key header to look for.
+func (k *Keyring) Match(data []byte) (*KeyringEntry, error) {
+  for _, entry := range k.Entries {
+    encryptor, err := entry.Encryptor()
+    if err != nil {
+      continue
+    }
+
+    plaintext, err := encryptor.Decrypt(data)
+    Wipe(plaintext)
+    encryptor.Destroy()
+    if err == nil || errors.Is(err, ErrCorruptPayload) {
+      return entry, nil
+    }
+  }
+  return nil, wrongKey(fmt.Sprintf("none of the %d keys in the keyring opens this data", len(k.Entries)))
+}
+
+// NoteImage records that the entry was used for an image.
+func (e *KeyringEntry) NoteImage(path string) {
+  if absolute, err := filepath.Abs(path); err == nil {
+    path = absolute
+  }
+  for _, image := range e.Images {
+    if image == path {
+      return
+    }
+  }
+  e.Images = append(e.Images, path)
+}
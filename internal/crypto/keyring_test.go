@@ -0,0 +1,67 @@
+package crypto
+
+import (
+  "bytes"
+  "encoding/hex"
+  "path/filepath"
+  "testing"
+)
+
+// Keys are held as bytes but stored as before, hex and stegsec: strings,
+// so keyrings written by earlier versions still open.
+func TestKeyringKeepsStoredFormat(t *testing.T) {
+  key := bytes.Repeat([]byte{0x5a}, keySize)
+  identity, err := GenerateIdentity()
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  passphrase := []byte("keyring passphrase")
+  path := filepath.Join(t.TempDir(), "keyring")
+  keyring, err := OpenKeyring(path, bytes.Clone(passphrase))
+  if err != nil {
+    t.Fatal(err)
+  }
+  for _, entry := range []*KeyringEntry{NewKeyringKey("plain", key), NewKeyringIdentity("me", identity)} {
+    if err := keyring.Add(entry); err != nil {
+      t.Fatal(err)
+    }
+  }
+  if err := keyring.Save(); err != nil {
+    t.Fatal(err)
+  }
+
+  plaintext, err := keyring.Entries[0].MarshalJSON()
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !bytes.Contains(plaintext, []byte(`"key":"`+hex.EncodeToString(key)+`"`)) {
+    t.Errorf("key stored as %s, want hex", plaintext)
+  }
+  plaintext, err = keyring.Entries[1].MarshalJSON()
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !bytes.Contains(plaintext, []byte(`"key":"`+EncodeIdentity(identity)+`"`)) {
+    t.Errorf("private key stored as %s, want its stegsec: form", plaintext)
+  }
+
+  keyring.Wipe()
+  for _, entry := range keyring.Entries {
+    if !bytes.Equal(entry.Key, make([]byte, len(entry.Key))) {
+      t.Errorf("key %q survives Wipe", entry.Name)
+    }
+  }
+
+  reopened, err := OpenKeyring(path, passphrase)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !bytes.Equal(reopened.Find("plain").Key, key) {
+    t.Error("key does not survive a save")
+  }
+  restored, err := reopened.Find("me").Identity()
+  if err != nil || !restored.Equal(identity) {
+    t.Errorf("private key does not survive a save (%v)", err)
+  }
+}
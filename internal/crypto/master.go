@@ -0,0 +1,91 @@
+package crypto
+
+import (
+  "crypto/rand"
+  "crypto/sha256"
+  "errors"
+  "io"
+
+  "golang.org/x/crypto/hkdf"
+)
+
+// A team master key never seals anything itself. Each image gets a random
+// image ID, stored in the header next to a short ID of the master, and its
+// key is HKDF(master, image ID). Whoever holds the master can open every
+// team image, while an image key on its own says nothing about the master
+// or about any other image.
+const (
+  masterIDSize     = 4
+  imageIDSize      = 16
+  masterRecordSize = masterIDSize + imageIDSize
+  masterIDInfo     = "steg-go master key id"
+  imageKeyInfo     = "steg-go image key"
+)
+
+var ErrMasterRequired = wrongKey("data is sealed with a team master key")
+
+// NewEncryptorWithMaster seals with a key derived from master for a fresh
+// image ID, and opens anything sealed under the same master.
+func NewEncryptorWithMaster(master []byte) (*Encryptor, error) {
+  if len(master) != keySize {
+    return nil, errors.New("invalid master key size")
+  }
+
+  imageID := make([]byte, imageIDSize)
+  if _, err := io.ReadFull(rand.Reader, imageID); err != nil {
+    return nil, err
+  }
+
+  key, err := DeriveImageKey(master, imageID)
+  if err != nil {
+    return nil, err
+  }
+  lockMemory(key)
+  return &Encryptor{key: key, master: secretCopy(master), imageID: imageID}, nil
+}
+
+// DeriveImageKey is the key of the image with imageID under master.
+func DeriveImageKey(master, imageID []byte) ([]byte, error) {
+  key := make([]byte, keySize)
+  if _, err := io.ReadFull(hkdf.New(sha256.New, master, imageID, []byte(imageKeyInfo)), key); err != nil {
+    return nil, err
+  }
+  return key, nil
+}
+
+// MasterKeyID identifies a master key without revealing it.
+func MasterKeyID(master []byte) []byte {
+  id := make([]byte, masterIDSize)
+  io.ReadFull(hkdf.New(sha256.New, master, nil, []byte(masterIDInfo)), id)
+  return id
+}
+
+func (e *Encryptor) UsesMaster() bool {
+  return e.master != nil
+}
+
+// ImageID is the ID the next image sealed with a master key is derived for.
+func (e *Encryptor) ImageID() []byte {
+  return e.imageID
+}
+
+func (e *Encryptor) masterRecord() record {
+  return record{tag: tagMaster, value: append(MasterKeyID(e.master), e.imageID...)}
+}
+
+// masterImageKey derives the key for the image described by a master
+// record, after checking that it was sealed under this master.
+func (e *Encryptor) masterImageKey(value []byte) ([]byte, error) {
+  if len(value) != masterRecordSize {
+    return nil, errors.New("malformed master key record")
+  }
+  if string(value[:masterIDSize]) != string(MasterKeyID(e.master)) {
+    return nil, wrongKey("data is sealed with a different master key")
+  }
+  return DeriveImageKey(e.master, value[masterIDSize:])
+}
+
+func NeedsMaster(data []byte) bool {
+  env, err := parseEnvelope(data)
+  return err == nil && env.find(tagMaster) != nil
+}
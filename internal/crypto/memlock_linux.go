@@ -0,0 +1,23 @@
+//go:build linux
+
+package crypto
+
+import (
+  "syscall"
+)
+
+// lockMemory keeps the pages under b out of swap. It is best effort: once
+// RLIMIT_MEMLOCK is used up the key is only wiped, not locked.
+func lockMemory(b []byte) {
+  if len(b) > 0 {
+    syscall.Mlock(b)
+  }
+}
+
+// unlockMemory undoes lockMemory. Locks do not nest, so it also unlocks any
+// other secret sharing a page with b.
+func unlockMemory(b []byte) {
+  if len(b) > 0 {
+    syscall.Munlock(b)
+  }
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package crypto
+
+// Memory locking is only done on Linux; elsewhere keys are just wiped.
+func lockMemory(b []byte) {}
+
+func unlockMemory(b []byte) {}
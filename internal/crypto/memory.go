@@ -0,0 +1,46 @@
+package crypto
+
+import (
+  "runtime"
+)
+
+// Keys and passphrases an Encryptor holds live in buffers of its own,
+// locked in memory where the platform allows so they stay out of swap,
+// and Destroy wipes them. Copies Go makes behind our back, such as strings
+// or the old backing arrays of grown slices, are out of reach, so callers
+// should keep secrets in byte slices and wipe their own copies with Wipe.
+
+// Wipe overwrites b with zeros.
+func Wipe(b []byte) {
+  clear(b)
+  runtime.KeepAlive(b)
+}
+
+// secretCopy copies b into a locked buffer owned by the encryptor.
+func secretCopy(b []byte) []byte {
+  if b == nil {
+    return nil
+  }
+  secret := make([]byte, len(b))
+  lockMemory(secret)
+  copy(secret, b)
+  return secret
+}
+
+func destroySecret(b []byte) {
+  Wipe(b)
+  unlockMemory(b)
+}
+
+// Destroy wipes every key and passphrase the encryptor holds and unlocks
+// their memory. The encryptor cannot be used afterwards. A private key is
+// only dropped, since crypto/ecdh gives no way to wipe one.
+func (e *Encryptor) Destroy() {
+  destroySecret(e.key)
+  destroySecret(e.passphrase)
+  destroySecret(e.master)
+  for _, key := range e.derived {
+    destroySecret(key)
+  }
+  *e = Encryptor{}
+}
@@ -0,0 +1,223 @@
+package crypto
+
+import (
+  "encoding/binary"
+  "errors"
+  "math"
+  "strings"
+  "unicode"
+
+  "golang.org/x/crypto/argon2"
+  "golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+  kdfArgon2id byte = 0x01
+
+  saltSize             = 16
+  kdfRecordSize        = 1 + 4 + 4 + 1 + saltSize
+  passphraseStanzaSize = kdfRecordSize + wrappedKeySize
+
+  // The cost recorded in an envelope comes from the image, so these bound
+  // what opening an untrusted image can cost as well as what hiding allows.
+  MaxKDFTime    = 16
+  MaxKDFMemory  = 1024 * 1024
+  MaxKDFThreads = 16
+)
+
+// KDFParams are the Argon2id cost parameters. Memory is in KiB. They are
+// stored in the envelope header, so extraction needs only the passphrase.
+type KDFParams struct {
+  Time    uint32
+  Memory  uint32
+  Threads uint8
+}
+
+var DefaultKDFParams = KDFParams{
+  Time:    3,
+  Memory:  64 * 1024,
+  Threads: 4,
+}
+
+func (p KDFParams) validate() error {
+  if p.Time < 1 || p.Time > MaxKDFTime {
+    return errors.New("invalid key derivation time cost")
+  }
+  if p.Memory < 8*uint32(p.Threads) || p.Memory > MaxKDFMemory {
+    return errors.New("invalid key derivation memory cost")
+  }
+  if p.Threads < 1 || p.Threads > MaxKDFThreads {
+    return errors.New("invalid key derivation parallelism")
+  }
+  return nil
+}
+
+func deriveKey(passphrase, salt []byte, params KDFParams) []byte {
+  return argon2.IDKey(passphrase, salt, params.Time, params.Memory, params.Threads, keySize)
+}
+
+func kdfRecord(params KDFParams, salt []byte) record {
+  value := make([]byte, 0, kdfRecordSize)
+  value = append(value, kdfArgon2id)
+  value = binary.BigEndian.AppendUint32(value, params.Time)
+  value = binary.BigEndian.AppendUint32(value, params.Memory)
+  value = append(value, params.Threads)
+  value = append(value, salt...)
+  return record{tag: tagKDF, value: value}
+}
+
+func parseKDFRecord(value []byte) (KDFParams, []byte, error) {
+  if len(value) != kdfRecordSize || value[0] != kdfArgon2id {
+    return KDFParams{}, nil, errors.New("unsupported key derivation function")
+  }
+
+  params := KDFParams{
+    Time:    binary.BigEndian.Uint32(value[1:5]),
+    Memory:  binary.BigEndian.Uint32(value[5:9]),
+    Threads: value[9],
+  }
+  if err := params.validate(); err != nil {
+    return KDFParams{}, nil, err
+  }
+  return params, value[10:], nil
+}
+
+// PayloadKDFParams reads the Argon2id cost a passphrase payload was sealed
+// with, so it can be sealed again at the same cost. ok is false for a
+// payload without a KDF record.
+func PayloadKDFParams(data []byte) (params KDFParams, ok bool, err error) {
+  env, err := parseEnvelope(data)
+  if err != nil {
+    return KDFParams{}, false, nil
+  }
+  kdf := env.find(tagKDF)
+  if kdf == nil {
+    return KDFParams{}, false, nil
+  }
+  if params, _, err = parseKDFRecord(kdf); err != nil {
+    return KDFParams{}, false, err
+  }
+  return params, true, nil
+}
+
+// passphraseStanza wraps a recipient envelope's file key under a passphrase
+// derived key. Each stanza has its own salt, so a fixed nonce is safe.
+func passphraseStanza(fileKey []byte, params KDFParams, salt, wrapKey []byte) record {
+  aead, _ := chacha20poly1305.New(wrapKey)
+  value := kdfRecord(params, salt).value
+  value = aead.Seal(value, make([]byte, chacha20poly1305.NonceSize), fileKey, nil)
+  return record{tag: tagPassphraseStanza, value: value}
+}
+
+func (e *Encryptor) unwrapPassphraseStanza(value []byte) []byte {
+  if len(value) != passphraseStanzaSize {
+    return nil
+  }
+  params, salt, err := parseKDFRecord(value[:kdfRecordSize])
+  if err != nil {
+    return nil
+  }
+
+  aead, err := chacha20poly1305.New(e.passphraseKey(params, salt))
+  if err != nil {
+    return nil
+  }
+  fileKey, err := aead.Open(nil, make([]byte, chacha20poly1305.NonceSize), value[kdfRecordSize:], nil)
+  if err != nil {
+    return nil
+  }
+  return fileKey
+}
+
+// NeedsPassphrase reports whether encrypted data can be opened with a
+// passphrase rather than a raw key.
+func NeedsPassphrase(data []byte) bool {
+  env, err := parseEnvelope(data)
+  return err == nil && (env.find(tagKDF) != nil || env.find(tagPassphraseStanza) != nil)
+}
+
+var commonPassphrases = []string{
+  "password", "123456", "12345678", "qwerty", "letmein", "welcome",
+  "admin", "iloveyou", "monkey", "dragon", "football", "secret",
+  "abc123", "passw0rd", "trustno1", "sunshine", "princess", "steg",
+}
+
+// PassphraseStrength gives a rough entropy estimate in bits and a label.
+// It counts the character classes in use, then discounts runs, keyboard
+// and alphabet sequences, common passwords and word-only passphrases.
+func PassphraseStrength(passphrase string) (float64, string) {
+  runes := []rune(passphrase)
+  if len(runes) == 0 {
+    return 0, "empty"
+  }
+
+  var lower, upper, digit, symbol, other bool
+  for _, r := range runes {
+    switch {
+    case r >= 'a' && r <= 'z':
+      lower = true
+    case r >= 'A' && r <= 'Z':
+      upper = true
+    case r >= '0' && r <= '9':
+      digit = true
+    case r < unicode.MaxASCII && unicode.IsPrint(r):
+      symbol = true
+    default:
+      other = true
+    }
+  }
+
+  pool := 0
+  for _, class := range []struct {
+    used bool
+    size int
+  }{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+    if class.used {
+      pool += class.size
+    }
+  }
+  perChar := math.Log2(float64(pool))
+
+  // Characters that repeat or continue a sequence add next to nothing.
+  effective := 1.0
+  for i := 1; i < len(runes); i++ {
+    step := runes[i] - runes[i-1]
+    if step == 0 || step == 1 || step == -1 {
+      effective += 0.25
+    } else {
+      effective++
+    }
+  }
+  bits := effective * perChar
+
+  lowered := strings.ToLower(passphrase)
+  for _, common := range commonPassphrases {
+    if strings.Contains(lowered, common) {
+      bits -= float64(len(common)) * perChar
+    }
+  }
+
+  words := strings.FieldsFunc(lowered, func(r rune) bool {
+    return r == ' ' || r == '-' || r == '_' || r == '.'
+  })
+  if len(words) >= 2 && strings.IndexFunc(lowered, unicode.IsDigit) < 0 {
+    if wordBits := float64(len(words))*12 + 4; wordBits < bits {
+      bits = wordBits
+    }
+  }
+
+  if bits < 0 {
+    bits = 0
+  }
+
+  switch {
+  case bits < 40:
+    return bits, "weak"
+  case bits < 60:
+    return bits, "fair"
+  case bits < 80:
+    return bits, "good"
+  default:
+    return bits, "strong"
+  }
+}
@@ -0,0 +1,90 @@
+package crypto
+
+import (
+  "bytes"
+  "testing"
+)
+
+func craftedEnvelope(records ...record) []byte {
+  return append(marshalHeader(records), make([]byte, 64)...)
+}
+
+// The cost in a KDF record comes from the image, so anything past the
+// limits has to be refused before Argon2id runs.
+func TestOversizedKDFRecordIsRejected(t *testing.T) {
+  salt := bytes.Repeat([]byte{1}, saltSize)
+  tests := []struct {
+    name   string
+    params KDFParams
+  }{
+    {"memory", KDFParams{Time: 1, Memory: MaxKDFMemory + 1, Threads: 1}},
+    {"4 GiB memory", KDFParams{Time: 1, Memory: 4 * 1024 * 1024, Threads: 1}},
+    {"time", KDFParams{Time: MaxKDFTime + 1, Memory: 64, Threads: 1}},
+    {"threads", KDFParams{Time: 1, Memory: 8 * 255, Threads: 255}},
+  }
+
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      e, err := NewEncryptorWithPassphrase([]byte("passphrase"), KDFParams{Time: 1, Memory: 64, Threads: 1})
+      if err != nil {
+        t.Fatal(err)
+      }
+      if _, err := e.Decrypt(craftedEnvelope(kdfRecord(tt.params, salt))); err == nil {
+        t.Fatal("Decrypt accepted an oversized KDF record")
+      }
+      if len(e.derived) != 0 {
+        t.Error("Argon2id ran for an oversized KDF record")
+      }
+    })
+  }
+}
+
+func TestSeveralPassphraseRecordsAreRejected(t *testing.T) {
+  params := KDFParams{Time: 1, Memory: 64, Threads: 1}
+  stanza := func(fill byte) record {
+    salt := bytes.Repeat([]byte{fill}, saltSize)
+    return passphraseStanza(make([]byte, keySize), params, salt, make([]byte, keySize))
+  }
+
+  for name, records := range map[string][]record{
+    "stanzas":        {stanza(1), stanza(2), stanza(3)},
+    "kdf and stanza": {kdfRecord(params, bytes.Repeat([]byte{1}, saltSize)), stanza(2)},
+  } {
+    t.Run(name, func(t *testing.T) {
+      e, err := NewEncryptorWithPassphrase([]byte("passphrase"), params)
+      if err != nil {
+        t.Fatal(err)
+      }
+      if _, err := e.Decrypt(craftedEnvelope(records...)); err == nil {
+        t.Fatal("Decrypt accepted several passphrase records")
+      }
+      if len(e.derived) != 0 {
+        t.Errorf("Argon2id ran %d times", len(e.derived))
+      }
+    })
+  }
+}
+
+func TestPassphraseRoundTrip(t *testing.T) {
+  params := KDFParams{Time: 1, Memory: 64, Threads: 1}
+  e, err := NewEncryptorWithPassphrase([]byte("correct horse"), params)
+  if err != nil {
+    t.Fatal(err)
+  }
+  ciphertext, err := e.Encrypt([]byte("hidden"))
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  d, err := NewEncryptorWithPassphrase([]byte("correct horse"), DefaultKDFParams)
+  if err != nil {
+    t.Fatal(err)
+  }
+  plaintext, err := d.Decrypt(ciphertext)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if string(plaintext) != "hidden" {
+    t.Errorf("got %q", plaintext)
+  }
+}
@@ -0,0 +1,228 @@
+package crypto
+
+import (
+  "crypto/ecdh"
+  "crypto/rand"
+  "crypto/sha256"
+  "encoding/base64"
+  "errors"
+  "fmt"
+  "io"
+  "os"
+  "strings"
+  "time"
+
+  "golang.org/x/crypto/chacha20poly1305"
+  "golang.org/x/crypto/hkdf"
+)
+
+// Recipient encryption follows age: the payload is sealed with a random
+// file key, and for every recipient an ephemeral X25519 share plus the file
+// key wrapped under HKDF(ECDH(ephemeral, recipient)) is stored in a stanza.
+const (
+  PublicKeyPrefix  = "stegpub:"
+  IdentityPrefix   = "stegsec:"
+  x25519KeySize    = 32
+  wrappedKeySize   = keySize + chacha20poly1305.Overhead
+  stanzaSize       = x25519KeySize + wrappedKeySize
+  recipientKDFInfo = "steg-go x25519 recipient"
+)
+
+var ErrIdentityRequired = wrongKey("data is encrypted to a recipient public key; a private key is required")
+
+var keyEncoding = base64.RawURLEncoding
+
+func GenerateIdentity() (*ecdh.PrivateKey, error) {
+  return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+func EncodePublicKey(key *ecdh.PublicKey) string {
+  return PublicKeyPrefix + keyEncoding.EncodeToString(key.Bytes())
+}
+
+func ParsePublicKey(s string) (*ecdh.PublicKey, error) {
+  s = strings.TrimSpace(s)
+  if !strings.HasPrefix(s, PublicKeyPrefix) {
+    return nil, fmt.Errorf("public key must start with %q", PublicKeyPrefix)
+  }
+  raw, err := keyEncoding.DecodeString(s[len(PublicKeyPrefix):])
+  if err != nil || len(raw) != x25519KeySize {
+    return nil, errors.New("malformed public key")
+  }
+  return ecdh.X25519().NewPublicKey(raw)
+}
+
+// Recipient is a public key with an optional name for display. Names are
+// never written to the image.
+type Recipient struct {
+  Name string
+  Key  *ecdh.PublicKey
+}
+
+func (r Recipient) String() string {
+  if r.Name != "" {
+    return r.Name
+  }
+  encoded := EncodePublicKey(r.Key)
+  return encoded[:len(PublicKeyPrefix)+8] + "…"
+}
+
+// ParseRecipient accepts a public key, optionally prefixed with a name as
+// name=stegpub:... or "name stegpub:...".
+func ParseRecipient(s string) (Recipient, error) {
+  s = strings.TrimSpace(s)
+  name := ""
+  if i := strings.LastIndexAny(s, "= \t"); i >= 0 {
+    name, s = strings.TrimSpace(s[:i]), s[i+1:]
+  }
+
+  key, err := ParsePublicKey(s)
+  if err != nil {
+    return Recipient{}, err
+  }
+  return Recipient{Name: name, Key: key}, nil
+}
+
+// LoadRecipientsFile reads one recipient per line, skipping blank and #
+// comment lines.
+func LoadRecipientsFile(path string) ([]Recipient, error) {
+  data, err := os.ReadFile(path)
+  if err != nil {
+    return nil, err
+  }
+
+  var recipients []Recipient
+  for n, line := range strings.Split(string(data), "\n") {
+    line = strings.TrimSpace(line)
+    if line == "" || strings.HasPrefix(line, "#") {
+      continue
+    }
+    recipient, err := ParseRecipient(line)
+    if err != nil {
+      return nil, fmt.Errorf("%s line %d: %v", path, n+1, err)
+    }
+    recipients = append(recipients, recipient)
+  }
+  return recipients, nil
+}
+
+func EncodeIdentity(key *ecdh.PrivateKey) string {
+  return IdentityPrefix + keyEncoding.EncodeToString(key.Bytes())
+}
+
+// ParseIdentity reads a private key, skipping blank and # comment lines so
+// the contents of an identity file can be passed as is.
+func ParseIdentity(s string) (*ecdh.PrivateKey, error) {
+  for _, line := range strings.Split(s, "\n") {
+    line = strings.TrimSpace(line)
+    if line == "" || strings.HasPrefix(line, "#") {
+      continue
+    }
+    if !strings.HasPrefix(line, IdentityPrefix) {
+      return nil, fmt.Errorf("private key must start with %q", IdentityPrefix)
+    }
+    raw, err := keyEncoding.DecodeString(line[len(IdentityPrefix):])
+    if err != nil || len(raw) != x25519KeySize {
+      return nil, errors.New("malformed private key")
+    }
+    return ecdh.X25519().NewPrivateKey(raw)
+  }
+  return nil, errors.New("no private key found")
+}
+
+func LoadIdentityFile(path string) (*ecdh.PrivateKey, error) {
+  data, err := os.ReadFile(path)
+  if err != nil {
+    return nil, err
+  }
+  return ParseIdentity(string(data))
+}
+
+// WriteIdentityFile saves a private key readable only by the owner, with
+// the matching public key in a comment for reference.
+func WriteIdentityFile(path string, key *ecdh.PrivateKey) error {
+  content := fmt.Sprintf("# created: %s\n# public key: %s\n%s\n",
+    time.Now().Format(time.RFC3339), EncodePublicKey(key.PublicKey()), EncodeIdentity(key))
+  return os.WriteFile(path, []byte(content), 0600)
+}
+
+func wrapKDF(shared, ephemeral, recipient []byte) ([]byte, error) {
+  salt := make([]byte, 0, 2*x25519KeySize)
+  salt = append(salt, ephemeral...)
+  salt = append(salt, recipient...)
+
+  key := make([]byte, chacha20poly1305.KeySize)
+  if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(recipientKDFInfo)), key); err != nil {
+    return nil, err
+  }
+  return key, nil
+}
+
+// The wrap key is unique to each ephemeral share, so a fixed nonce is safe.
+func recipientStanza(fileKey []byte, recipient *ecdh.PublicKey) (record, error) {
+  ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+  if err != nil {
+    return record{}, err
+  }
+  shared, err := ephemeral.ECDH(recipient)
+  if err != nil {
+    return record{}, err
+  }
+  defer Wipe(shared)
+
+  wrapKey, err := wrapKDF(shared, ephemeral.PublicKey().Bytes(), recipient.Bytes())
+  if err != nil {
+    return record{}, err
+  }
+  defer Wipe(wrapKey)
+  aead, err := chacha20poly1305.New(wrapKey)
+  if err != nil {
+    return record{}, err
+  }
+
+  value := make([]byte, 0, stanzaSize)
+  value = append(value, ephemeral.PublicKey().Bytes()...)
+  value = aead.Seal(value, make([]byte, chacha20poly1305.NonceSize), fileKey, nil)
+  return record{tag: tagRecipient, value: value}, nil
+}
+
+// unwrapStanza returns the file key, or nil if the stanza is not addressed
+// to this identity.
+func unwrapStanza(value []byte, identity *ecdh.PrivateKey) []byte {
+  if len(value) != stanzaSize {
+    return nil
+  }
+
+  ephemeral, err := ecdh.X25519().NewPublicKey(value[:x25519KeySize])
+  if err != nil {
+    return nil
+  }
+  shared, err := identity.ECDH(ephemeral)
+  if err != nil {
+    return nil
+  }
+  defer Wipe(shared)
+
+  wrapKey, err := wrapKDF(shared, value[:x25519KeySize], identity.PublicKey().Bytes())
+  if err != nil {
+    return nil
+  }
+  defer Wipe(wrapKey)
+  aead, err := chacha20poly1305.New(wrapKey)
+  if err != nil {
+    return nil
+  }
+
+  fileKey, err := aead.Open(nil, make([]byte, chacha20poly1305.NonceSize), value[x25519KeySize:], nil)
+  if err != nil {
+    return nil
+  }
+  return fileKey
+}
+
+// NeedsIdentity reports whether encrypted data was sealed to recipient
+// public keys.
+func NeedsIdentity(data []byte) bool {
+  env, err := parseEnvelope(data)
+  return err == nil && env.find(tagRecipient) != nil
+}
@@ -0,0 +1,112 @@
+package crypto
+
+import (
+  "bytes"
+  "encoding/hex"
+  "fmt"
+)
+
+type knownAnswer struct {
+  source     string
+  key        string
+  nonce      string
+  aad        string
+  plaintext  string
+  ciphertext string
+}
+
+const sunscreen = "Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it."
+
+var sunscreenHex = hex.EncodeToString([]byte(sunscreen))
+
+// Published test vectors for every supported cipher. Ciphertexts include
+// the 16-byte tag.
+var knownAnswers = map[byte][]knownAnswer{
+  0x01: {
+    {
+      source:     "GCM spec test case 13",
+      key:        "0000000000000000000000000000000000000000000000000000000000000000",
+      nonce:      "000000000000000000000000",
+      ciphertext: "530f8afbc74536b9a963b4f1c4cb738b",
+    },
+    {
+      source:     "GCM spec test case 14",
+      key:        "0000000000000000000000000000000000000000000000000000000000000000",
+      nonce:      "000000000000000000000000",
+      plaintext:  "00000000000000000000000000000000",
+      ciphertext: "cea7403d4d606b6e074ec5d3baf39d18d0d1c8a799996bf0265b98b5d48ab919",
+    },
+  },
+  0x02: {
+    {
+      source:    "RFC 8439 section 2.8.2",
+      key:       "808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f",
+      nonce:     "070000004041424344454647",
+      aad:       "50515253c0c1c2c3c4c5c6c7",
+      plaintext: sunscreenHex,
+      ciphertext: "d31a8d34648e60db7b86afbc53ef7ec2a4aded51296e08fea9e2b5a736ee62d6" +
+        "3dbea45e8ca9671282fafb69da92728b1a71de0a9e060b2905d6a5b67ecd3b36" +
+        "92ddbd7f2d778b8c9803aee328091b58fab324e4fad675945585808b4831d7bc" +
+        "3ff4def08e4b7a9de576d26586cec64b6116" +
+        "1ae10b594f09e26a7e902ecbd0600691",
+    },
+  },
+  0x03: {
+    {
+      source:    "draft-irtf-cfrg-xchacha appendix A.3.1",
+      key:       "808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f",
+      nonce:     "404142434445464748494a4b4c4d4e4f5051525354555657",
+      aad:       "50515253c0c1c2c3c4c5c6c7",
+      plaintext: sunscreenHex,
+      ciphertext: "bd6d179d3e83d43b9576579493c0e939572a1700252bfaccbed2902c21396cbb" +
+        "731c7f1b0b4aa6440bf3a82f4eda7e39ae64c6708c54c216cb96b72e1213b452" +
+        "2f8c9ba40db5d945b11b69b982c1bb9e3f3fac2bc369488f76b2383565d3fff9" +
+        "21f9664c97637da9768812f615c68b13b52e" +
+        "c0875924c1c7987947deafd8780acf49",
+    },
+  },
+}
+
+// SelfTest checks a cipher against its known-answer vectors, both sealing
+// and opening, and that a flipped ciphertext bit fails authentication.
+func SelfTest(c Cipher) error {
+  vectors := knownAnswers[c.ID()]
+  if len(vectors) == 0 {
+    return fmt.Errorf("%s: no known-answer vectors", c.Name())
+  }
+
+  for _, v := range vectors {
+    key, nonce, aad := mustHex(v.key), mustHex(v.nonce), mustHex(v.aad)
+    plaintext, expected := mustHex(v.plaintext), mustHex(v.ciphertext)
+
+    aead, err := c.New(key)
+    if err != nil {
+      return fmt.Errorf("%s (%s): %v", c.Name(), v.source, err)
+    }
+
+    if sealed := aead.Seal(nil, nonce, plaintext, aad); !bytes.Equal(sealed, expected) {
+      return fmt.Errorf("%s (%s): ciphertext mismatch", c.Name(), v.source)
+    }
+
+    opened, err := aead.Open(nil, nonce, expected, aad)
+    if err != nil || !bytes.Equal(opened, plaintext) {
+      return fmt.Errorf("%s (%s): decryption mismatch", c.Name(), v.source)
+    }
+
+    tampered := append([]byte(nil), expected...)
+    tampered[0] ^= 0x01
+    if _, err := aead.Open(nil, nonce, tampered, aad); err == nil {
+      return fmt.Errorf("%s (%s): tampered ciphertext accepted", c.Name(), v.source)
+    }
+  }
+
+  return nil
+}
+
+func mustHex(s string) []byte {
+  b, err := hex.DecodeString(s)
+  if err != nil {
+    panic(err)
+  }
+  return b
+}
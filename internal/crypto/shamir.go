@@ -0,0 +1,206 @@
+package crypto
+
+import (
+  "crypto/rand"
+  "encoding/binary"
+  "encoding/hex"
+  "errors"
+  "fmt"
+  "io"
+  "strings"
+)
+
+// A key can be split into n shares so that any k of them rebuild it and
+// fewer reveal nothing, using Shamir's scheme over GF(2^8) one byte at a
+// time. Each share carries a random split ID, the threshold and its index
+// (the x coordinate) ahead of the 32 share bytes, so shares from different
+// splits or typed twice are caught before combining:
+//
+//   split ID (2) | threshold (1) | index (1) | value (32)
+//
+// In words that is 288 bits plus a 9-bit checksum, 27 words.
+const (
+  MaxKeyShares = 255
+
+  shareHeaderSize = 2 + 1 + 1
+  shareSize       = shareHeaderSize + keySize
+)
+
+type KeyShare struct {
+  Split     uint16
+  Threshold int
+  Index     int
+  Value     []byte
+}
+
+// SplitKey splits key into n shares, any threshold of which rebuild it.
+func SplitKey(key []byte, n, threshold int) ([]KeyShare, error) {
+  if len(key) != keySize {
+    return nil, errors.New("invalid key size")
+  }
+  if threshold < 2 {
+    return nil, errors.New("threshold must be at least 2")
+  }
+  if n < threshold || n > MaxKeyShares {
+    return nil, fmt.Errorf("number of shares must be between the threshold and %d", MaxKeyShares)
+  }
+
+  var split [2]byte
+  if _, err := io.ReadFull(rand.Reader, split[:]); err != nil {
+    return nil, err
+  }
+
+  // coefficients[i] holds the higher coefficients of the polynomial for
+  // byte i; its constant term is the key byte.
+  coefficients := make([]byte, keySize*(threshold-1))
+  if _, err := io.ReadFull(rand.Reader, coefficients); err != nil {
+    return nil, err
+  }
+
+  shares := make([]KeyShare, n)
+  for s := range shares {
+    x := byte(s + 1)
+    value := make([]byte, keySize)
+    for i := range value {
+      poly := coefficients[i*(threshold-1) : (i+1)*(threshold-1)]
+      y := byte(0)
+      for j := len(poly) - 1; j >= 0; j-- {
+        y = gfMul(y, x) ^ poly[j]
+      }
+      value[i] = gfMul(y, x) ^ key[i]
+    }
+    shares[s] = KeyShare{
+      Split:     binary.BigEndian.Uint16(split[:]),
+      Threshold: threshold,
+      Index:     int(x),
+      Value:     value,
+    }
+  }
+  return shares, nil
+}
+
+// CombineKeyShares rebuilds a key from at least threshold of its shares.
+// Any shares beyond the threshold must agree with the key the others give.
+func CombineKeyShares(shares []KeyShare) ([]byte, error) {
+  if len(shares) == 0 {
+    return nil, errors.New("no key shares given")
+  }
+
+  first := shares[0]
+  for n, share := range shares {
+    if len(share.Value) != keySize || share.Index < 1 || share.Index > MaxKeyShares {
+      return nil, fmt.Errorf("share %d is malformed", n+1)
+    }
+    if share.Split != first.Split || share.Threshold != first.Threshold {
+      return nil, fmt.Errorf("share %d comes from a different split than share 1", n+1)
+    }
+    for m, other := range shares[:n] {
+      if other.Index != share.Index {
+        continue
+      }
+      if string(other.Value) == string(share.Value) {
+        return nil, fmt.Errorf("shares %d and %d are the same share (index %d)", m+1, n+1, share.Index)
+      }
+      return nil, fmt.Errorf("shares %d and %d are different but both claim index %d", m+1, n+1, share.Index)
+    }
+  }
+  if len(shares) < first.Threshold {
+    return nil, fmt.Errorf("%d shares are needed to rebuild the key, got %d", first.Threshold, len(shares))
+  }
+
+  used := shares[:first.Threshold]
+  key := interpolateShares(used, 0)
+  for n, extra := range shares[first.Threshold:] {
+    if string(interpolateShares(used, byte(extra.Index))) != string(extra.Value) {
+      return nil, fmt.Errorf("share %d does not agree with the others; check it for typos", first.Threshold+n+1)
+    }
+  }
+  return key, nil
+}
+
+// interpolateShares evaluates at x the polynomials passing through shares.
+func interpolateShares(shares []KeyShare, x byte) []byte {
+  result := make([]byte, keySize)
+  for j, share := range shares {
+    xj := byte(share.Index)
+    basis := byte(1)
+    for m, other := range shares {
+      if m == j {
+        continue
+      }
+      xm := byte(other.Index)
+      basis = gfMul(basis, gfMul(x^xm, gfInv(xj^xm)))
+    }
+    for i := range result {
+      result[i] ^= gfMul(share.Value[i], basis)
+    }
+  }
+  return result
+}
+
+// gfMul multiplies in GF(2^8) with the AES polynomial, without branching
+// or table lookups on the operands.
+func gfMul(a, b byte) byte {
+  var product byte
+  for i := 0; i < 8; i++ {
+    product ^= -(b & 1) & a
+    a = a<<1 ^ -(a>>7)&0x1b
+    b >>= 1
+  }
+  return product
+}
+
+// gfInv returns a^254, the inverse of a non-zero a.
+func gfInv(a byte) byte {
+  result := byte(1)
+  for i := 0; i < 7; i++ {
+    a = gfMul(a, a)
+    result = gfMul(result, a)
+  }
+  return result
+}
+
+func (s KeyShare) Bytes() []byte {
+  data := binary.BigEndian.AppendUint16(nil, s.Split)
+  data = append(data, byte(s.Threshold), byte(s.Index))
+  return append(data, s.Value...)
+}
+
+func (s KeyShare) Hex() string {
+  return hex.EncodeToString(s.Bytes())
+}
+
+func (s KeyShare) Mnemonic() string {
+  return encodeMnemonic(s.Bytes())
+}
+
+// ParseKeyShare reads a share written as hex or as words.
+func ParseKeyShare(s string) (KeyShare, error) {
+  s = strings.TrimSpace(s)
+
+  var data []byte
+  var err error
+  if strings.ContainsAny(s, " \t\n") {
+    data, err = parseMnemonic(s, shareSize)
+  } else if len(s) == hex.EncodedLen(shareSize) {
+    if data, err = hex.DecodeString(s); err != nil {
+      err = errors.New("invalid key share format: must be hexadecimal")
+    }
+  } else {
+    err = fmt.Errorf("a key share is %d hex digits or %d words", hex.EncodedLen(shareSize), mnemonicWordCount(shareSize))
+  }
+  if err != nil {
+    return KeyShare{}, err
+  }
+
+  share := KeyShare{
+    Split:     binary.BigEndian.Uint16(data),
+    Threshold: int(data[2]),
+    Index:     int(data[3]),
+    Value:     data[shareHeaderSize:],
+  }
+  if share.Threshold < 2 || share.Index == 0 {
+    return KeyShare{}, errors.New("not a key share")
+  }
+  return share, nil
+}
@@ -0,0 +1,188 @@
+package crypto
+
+import (
+  "bytes"
+  "crypto/ed25519"
+  "crypto/rand"
+  "crypto/sha256"
+  "errors"
+  "fmt"
+  "os"
+  "path/filepath"
+  "strings"
+  "time"
+)
+
+// Sender signatures are Ed25519 over a fixed context string and the signed
+// content. Signers are identified by a short key ID, the first bytes of the
+// SHA-256 of their public key.
+const (
+  SigningPublicKeyPrefix = "stegsign:"
+  SigningKeyPrefix       = "stegsignsec:"
+  SigningKeyIDSize       = 8
+
+  signatureContext = "steg-go signature v1\x00"
+)
+
+type SignatureStatus string
+
+const (
+  SignatureUnsigned   SignatureStatus = "unsigned"
+  SignatureVerified   SignatureStatus = "verified"
+  SignatureUnverified SignatureStatus = "unverified"
+  SignatureInvalid    SignatureStatus = "invalid"
+)
+
+func GenerateSigningKey() (ed25519.PrivateKey, error) {
+  _, key, err := ed25519.GenerateKey(rand.Reader)
+  return key, err
+}
+
+func SigningKeyID(key ed25519.PublicKey) []byte {
+  sum := sha256.Sum256(key)
+  return sum[:SigningKeyIDSize]
+}
+
+func Sign(key ed25519.PrivateKey, message []byte) []byte {
+  return ed25519.Sign(key, signedMessage(message))
+}
+
+func signedMessage(message []byte) []byte {
+  return append([]byte(signatureContext), message...)
+}
+
+func EncodeSigningPublicKey(key ed25519.PublicKey) string {
+  return SigningPublicKeyPrefix + keyEncoding.EncodeToString(key)
+}
+
+func ParseSigningPublicKey(s string) (ed25519.PublicKey, error) {
+  s = strings.TrimSpace(s)
+  if !strings.HasPrefix(s, SigningPublicKeyPrefix) {
+    return nil, fmt.Errorf("signing public key must start with %q", SigningPublicKeyPrefix)
+  }
+  raw, err := keyEncoding.DecodeString(s[len(SigningPublicKeyPrefix):])
+  if err != nil || len(raw) != ed25519.PublicKeySize {
+    return nil, errors.New("malformed signing public key")
+  }
+  return ed25519.PublicKey(raw), nil
+}
+
+// ParseSigningKey reads a signing key, skipping blank and # comment lines
+// like ParseIdentity.
+func ParseSigningKey(s string) (ed25519.PrivateKey, error) {
+  for _, line := range strings.Split(s, "\n") {
+    line = strings.TrimSpace(line)
+    if line == "" || strings.HasPrefix(line, "#") {
+      continue
+    }
+    if !strings.HasPrefix(line, SigningKeyPrefix) {
+      return nil, fmt.Errorf("signing key must start with %q", SigningKeyPrefix)
+    }
+    seed, err := keyEncoding.DecodeString(line[len(SigningKeyPrefix):])
+    if err != nil || len(seed) != ed25519.SeedSize {
+      return nil, errors.New("malformed signing key")
+    }
+    return ed25519.NewKeyFromSeed(seed), nil
+  }
+  return nil, errors.New("no signing key found")
+}
+
+func LoadSigningKeyFile(path string) (ed25519.PrivateKey, error) {
+  data, err := os.ReadFile(path)
+  if err != nil {
+    return nil, err
+  }
+  return ParseSigningKey(string(data))
+}
+
+func WriteSigningKeyFile(path string, key ed25519.PrivateKey) error {
+  public := key.Public().(ed25519.PublicKey)
+  content := fmt.Sprintf("# created: %s\n# signing public key: %s\n%s%s\n",
+    time.Now().Format(time.RFC3339), EncodeSigningPublicKey(public),
+    SigningKeyPrefix, keyEncoding.EncodeToString(key.Seed()))
+  return os.WriteFile(path, []byte(content), 0600)
+}
+
+// DefaultTrustedSignersPath is $STEG_TRUSTED_SIGNERS, or trusted_signers
+// in ~/.steg-go when that is not set.
+func DefaultTrustedSignersPath() string {
+  if path := os.Getenv("STEG_TRUSTED_SIGNERS"); path != "" {
+    return path
+  }
+  home, err := os.UserHomeDir()
+  if err != nil {
+    return "trusted_signers"
+  }
+  return filepath.Join(home, ".steg-go", "trusted_signers")
+}
+
+// TrustedSigner is an entry of the local trusted-keys list.
+type TrustedSigner struct {
+  Name string
+  Key  ed25519.PublicKey
+}
+
+// LoadTrustedSigners reads "name stegsign:..." lines. A missing file is an
+// empty list, so every signature then reports as unverified.
+func LoadTrustedSigners(path string) ([]TrustedSigner, error) {
+  data, err := os.ReadFile(path)
+  if errors.Is(err, os.ErrNotExist) {
+    return nil, nil
+  }
+  if err != nil {
+    return nil, err
+  }
+
+  var signers []TrustedSigner
+  for n, line := range strings.Split(string(data), "\n") {
+    line = strings.TrimSpace(line)
+    if line == "" || strings.HasPrefix(line, "#") {
+      continue
+    }
+
+    name, encoded := "", line
+    if i := strings.LastIndexAny(line, "= \t"); i >= 0 {
+      name, encoded = strings.TrimSpace(line[:i]), line[i+1:]
+    }
+    key, err := ParseSigningPublicKey(encoded)
+    if err != nil {
+      return nil, fmt.Errorf("%s line %d: %v", path, n+1, err)
+    }
+    signers = append(signers, TrustedSigner{Name: name, Key: key})
+  }
+  return signers, nil
+}
+
+// AddTrustedSigner appends a signer to the trusted-keys list, creating the
+// file if needed.
+func AddTrustedSigner(path, name string, key ed25519.PublicKey) error {
+  if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+    return err
+  }
+
+  file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+  if err != nil {
+    return err
+  }
+  defer file.Close()
+
+  _, err = fmt.Fprintf(file, "%s %s\n", name, EncodeSigningPublicKey(key))
+  return err
+}
+
+// VerifySignature checks a signature against the trusted signers. It is
+// unverified when no trusted key has the signer's key ID, and invalid when
+// one does but the signature does not match. The signer's name is returned
+// for verified and invalid results.
+func VerifySignature(trusted []TrustedSigner, keyID, message, signature []byte) (SignatureStatus, string) {
+  for _, signer := range trusted {
+    if !bytes.Equal(SigningKeyID(signer.Key), keyID) {
+      continue
+    }
+    if ed25519.Verify(signer.Key, signedMessage(message), signature) {
+      return SignatureVerified, signer.Name
+    }
+    return SignatureInvalid, signer.Name
+  }
+  return SignatureUnverified, ""
+}
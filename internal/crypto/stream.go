@@ -0,0 +1,343 @@
+package crypto
+
+import (
+  "bytes"
+  "crypto/cipher"
+  "crypto/rand"
+  "crypto/sha256"
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "io"
+  "math"
+
+  "golang.org/x/crypto/hkdf"
+)
+
+// Payloads are sealed as a STREAM of fixed size segments rather than in one
+// AEAD call, so neither side needs the whole payload in memory and damage is
+// confined to the segment it lands in. Each segment's nonce is a random
+// per-payload prefix, a 32-bit segment counter and a flag set only on the
+// last segment, which stops segments being reordered, dropped or the stream
+// being truncated at a segment boundary:
+//
+//   nonce prefix | segment 0 | segment 1 | ... | last segment
+//
+// Every segment but the last holds exactly the segment size of plaintext
+// plus the tag, and the last may be empty.
+//
+// The segments are not sealed under the payload key itself but under a key
+// derived from it and a random salt in the stream record. With a 12-byte
+// nonce the random prefix is only 7 bytes, too short to rule out a repeat
+// across every payload sealed under a long-lived key; a key of its own per
+// payload makes a repeated prefix harmless.
+const (
+  DefaultSegmentSize = 64 * 1024
+
+  streamSaltSize    = 16
+  streamRecordSize  = 4 + streamSaltSize
+  streamCounterSize = 4 + 1
+  maxSegmentSize    = 16 * 1024 * 1024
+  streamKeyInfo     = "steg-go stream key"
+)
+
+// SegmentError reports the zero-based index of the first segment that failed
+// to authenticate. Segments before it were intact.
+type SegmentError struct {
+  Index int
+
+  keyChecked bool
+}
+
+func (e *SegmentError) Error() string {
+  return fmt.Sprintf("segment %d failed authentication", e.Index)
+}
+
+// Is reports a segment failure as corruption once the key is known to be
+// right, either from the key check value or from an earlier segment.
+func (e *SegmentError) Is(target error) bool {
+  return target == ErrCorruptPayload && (e.Index > 0 || e.keyChecked)
+}
+
+func streamRecord(segmentSize int, salt []byte) record {
+  value := binary.BigEndian.AppendUint32(nil, uint32(segmentSize))
+  return record{tag: tagStream, value: append(value, salt...)}
+}
+
+// streamParams returns the segment size and salt of env's stream record.
+func (env *envelope) streamParams() (int, []byte, error) {
+  value := env.find(tagStream)
+  if len(value) != streamRecordSize {
+    return 0, nil, errors.New("malformed stream record")
+  }
+  size := binary.BigEndian.Uint32(value)
+  if size == 0 || size > maxSegmentSize {
+    return 0, nil, errors.New("invalid stream segment size")
+  }
+  return int(size), value[4:], nil
+}
+
+func streamAEAD(c Cipher, key, salt []byte) (cipher.AEAD, error) {
+  streamKey := make([]byte, keySize)
+  defer Wipe(streamKey)
+  if _, err := io.ReadFull(hkdf.New(sha256.New, key, salt, []byte(streamKeyInfo)), streamKey); err != nil {
+    return nil, err
+  }
+  return c.New(streamKey)
+}
+
+func streamNonce(prefix []byte, index uint32, last bool) []byte {
+  nonce := make([]byte, 0, len(prefix)+streamCounterSize)
+  nonce = append(nonce, prefix...)
+  nonce = binary.BigEndian.AppendUint32(nonce, index)
+  if last {
+    return append(nonce, 1)
+  }
+  return append(nonce, 0)
+}
+
+func streamSegments(plainLen int) int {
+  if plainLen == 0 {
+    return 1
+  }
+  return (plainLen + DefaultSegmentSize - 1) / DefaultSegmentSize
+}
+
+// EncryptStream seals everything read from src and writes the envelope to
+// dst. Encrypt produces the same output for data held in memory.
+func (e *Encryptor) EncryptStream(dst io.Writer, src io.Reader) error {
+  records, key, err := e.sealingKey()
+  if err != nil {
+    return err
+  }
+
+  salt := make([]byte, streamSaltSize)
+  if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+    return err
+  }
+
+  c := e.Cipher()
+  records = append(records, keyCheckRecord(key))
+  if e.info != nil {
+    records = append(records, infoRecord())
+    src = infoReader(e.info, src)
+  }
+  header := marshalHeader(append([]record{cipherRecord(c), streamRecord(DefaultSegmentSize, salt)}, records...))
+
+  aead, err := streamAEAD(c, key, salt)
+  if err != nil {
+    return err
+  }
+
+  prefix := make([]byte, aead.NonceSize()-streamCounterSize)
+  if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+    return err
+  }
+
+  if _, err := dst.Write(append(header, prefix...)); err != nil {
+    return err
+  }
+  return sealSegments(dst, src, aead, prefix, header, DefaultSegmentSize)
+}
+
+func sealSegments(dst io.Writer, src io.Reader, aead cipher.AEAD, prefix, header []byte, size int) error {
+  segment := make([]byte, size, size+aead.Overhead())
+  next := make([]byte, size, size+aead.Overhead())
+  defer Wipe(segment[:cap(segment)])
+  defer Wipe(next[:cap(next)])
+
+  n, err := io.ReadFull(src, segment)
+  for index := uint32(0); ; index++ {
+    if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+      return err
+    }
+
+    // A full segment is only the last one if nothing follows it.
+    last := err != nil
+    var m int
+    var nextErr error
+    if !last {
+      m, nextErr = io.ReadFull(src, next)
+      last = nextErr == io.EOF
+    }
+    if !last && index == math.MaxUint32 {
+      return errors.New("payload too large to encrypt")
+    }
+
+    sealed := aead.Seal(segment[:0], streamNonce(prefix, index, last), segment[:n], header)
+    if _, err := dst.Write(sealed); err != nil {
+      return err
+    }
+    if last {
+      return nil
+    }
+
+    segment, next = next, segment
+    n, err = m, nextErr
+  }
+}
+
+// DecryptStream opens an envelope read from src and writes the plaintext to
+// dst one segment at a time, as each is authenticated. On a *SegmentError
+// dst has received everything before the damaged segment. Envelopes from
+// before segmenting are opened whole.
+func (e *Encryptor) DecryptStream(dst io.Writer, src io.Reader) error {
+  e.opened = nil
+  env, err := readEnvelope(src)
+  if err != nil {
+    return err
+  }
+  if env.find(tagStream) == nil {
+    body, err := io.ReadAll(src)
+    if err != nil {
+      return err
+    }
+    plaintext, err := e.Decrypt(append(env.header, body...))
+    if err != nil {
+      return err
+    }
+    defer Wipe(plaintext)
+    if e.checkInfo != nil && e.opened != nil {
+      if err := e.checkInfo(e.opened); err != nil {
+        return err
+      }
+    }
+    _, err = dst.Write(plaintext)
+    return err
+  }
+
+  size, salt, err := env.streamParams()
+  if err != nil {
+    return err
+  }
+
+  c, err := env.cipher()
+  if err != nil {
+    return err
+  }
+
+  key, scratch, err := e.envelopeKey(env)
+  if err != nil {
+    return err
+  }
+  if scratch {
+    defer Wipe(key)
+  }
+  keyChecked, err := checkKey(env, key)
+  if err != nil {
+    return err
+  }
+
+  aead, err := streamAEAD(c, key, salt)
+  if err != nil {
+    return err
+  }
+
+  prefix := make([]byte, aead.NonceSize()-streamCounterSize)
+  if _, err := io.ReadFull(src, prefix); err != nil {
+    return errors.New("ciphertext too short")
+  }
+
+  var info *infoWriter
+  if version := env.find(tagInfo); version != nil {
+    if len(version) != 1 || version[0] != infoVersion {
+      return errors.New("unsupported payload info version")
+    }
+    info = &infoWriter{dst: dst, check: e.checkInfo}
+    dst = info
+  }
+
+  err = openSegments(dst, src, aead, prefix, env.header, size)
+  if info != nil {
+    e.opened = info.info
+    if err == nil && info.info == nil {
+      return errors.New("truncated payload info")
+    }
+  }
+  var segmentErr *SegmentError
+  if errors.As(err, &segmentErr) {
+    segmentErr.keyChecked = keyChecked
+    if segmentErr.Index == 0 && !keyChecked {
+      return authError(env, err)
+    }
+  }
+  return err
+}
+
+func openSegments(dst io.Writer, src io.Reader, aead cipher.AEAD, prefix, header []byte, size int) error {
+  segment := make([]byte, size+aead.Overhead())
+  next := make([]byte, size+aead.Overhead())
+  defer Wipe(segment)
+  defer Wipe(next)
+
+  n, err := io.ReadFull(src, segment)
+  for index := uint32(0); ; index++ {
+    if err == io.EOF {
+      return &SegmentError{Index: int(index)}
+    }
+    if err != nil && err != io.ErrUnexpectedEOF {
+      return err
+    }
+
+    last := err != nil
+    var m int
+    var nextErr error
+    if !last {
+      m, nextErr = io.ReadFull(src, next)
+      last = nextErr == io.EOF
+    }
+
+    plaintext, openErr := aead.Open(segment[:0], streamNonce(prefix, index, last), segment[:n], header)
+    if openErr != nil {
+      return &SegmentError{Index: int(index)}
+    }
+    if _, err := dst.Write(plaintext); err != nil {
+      return err
+    }
+    if last {
+      return nil
+    }
+
+    segment, next = next, segment
+    n, err = m, nextErr
+  }
+}
+
+// ReadEnvelopeHeader reads just the envelope header from src, leaving src at
+// the start of the body. The header alone tells which credentials open the
+// payload, so a caller streaming it can ask for them before decrypting;
+// DecryptStream then takes the header followed by the rest of src.
+func ReadEnvelopeHeader(src io.Reader) ([]byte, error) {
+  env, err := readEnvelope(src)
+  if err != nil {
+    return nil, err
+  }
+  return env.header, nil
+}
+
+// readEnvelope reads just the envelope header from src, leaving src at the
+// start of the body.
+func readEnvelope(src io.Reader) (*envelope, error) {
+  prefix := make([]byte, envelopePrefix)
+  if _, err := io.ReadFull(src, prefix); err != nil || !isEnvelope(prefix) {
+    return nil, errors.New("not an encrypted envelope")
+  }
+
+  header := make([]byte, envelopePrefix+int(binary.BigEndian.Uint16(prefix[len(envelopeMagic)+1:])))
+  copy(header, prefix)
+  if _, err := io.ReadFull(src, header[envelopePrefix:]); err != nil {
+    return nil, errors.New("truncated envelope header")
+  }
+  return parseEnvelope(header)
+}
+
+// decryptStream sizes the buffer for the whole plaintext up front, so no
+// copy of it is left behind in a smaller one outgrown on the way.
+func (e *Encryptor) decryptStream(ciphertext []byte) ([]byte, error) {
+  var plaintext bytes.Buffer
+  plaintext.Grow(len(ciphertext))
+  if err := e.DecryptStream(&plaintext, bytes.NewReader(ciphertext)); err != nil {
+    return nil, err
+  }
+  return plaintext.Bytes(), nil
+}
@@ -0,0 +1,147 @@
+package steganography
+
+import (
+  "math"
+)
+
+// Content-adaptive embedding only uses pixels whose neighbourhood is
+// textured enough to mask LSB noise. The texture score is computed from the
+// upper seven bits of each channel, which embedding never touches, so the
+// decoder rebuilds exactly the same map from the stego image.
+//
+// FlagDensity adds a byte after the length with the density cap the image
+// was embedded under, in half-percent steps, so a rekey can keep it.
+const (
+  FlagDensity byte = 0x40
+
+  DefaultMaxDensity = 0.5
+  minTextureScore   = 4
+  densityScale      = 200
+)
+
+// densityByte rounds a density cap to the steps the header records it in.
+func densityByte(density float64) byte {
+  return byte(max(math.Round(density*densityScale), 1))
+}
+
+// textureMap scores every pixel, indexed in slot order (column by column),
+// by the standard deviation of its 3x3 neighbourhood, capped at 255.
+func textureMap(c *carrier) []uint8 {
+  intensity := make([]int, c.width*c.height)
+  for x := 0; x < c.width; x++ {
+    for y := 0; y < c.height; y++ {
+      i := c.img.PixOffset(x, y)
+      pix := c.img.Pix[i : i+3]
+      intensity[x*c.height+y] = int(pix[0]>>1) + int(pix[1]>>1) + int(pix[2]>>1)
+    }
+  }
+
+  scores := make([]uint8, len(intensity))
+  for x := 0; x < c.width; x++ {
+    for y := 0; y < c.height; y++ {
+      n, sum, sumSquares := 0, 0, 0
+      for dx := -1; dx <= 1; dx++ {
+        for dy := -1; dy <= 1; dy++ {
+          nx, ny := x+dx, y+dy
+          if nx < 0 || ny < 0 || nx >= c.width || ny >= c.height {
+            continue
+          }
+          v := intensity[nx*c.height+ny]
+          n++
+          sum += v
+          sumSquares += v * v
+        }
+      }
+
+      deviation := isqrt(n*sumSquares-sum*sum) / n
+      if deviation > 255 {
+        deviation = 255
+      }
+      scores[x*c.height+y] = uint8(deviation)
+    }
+  }
+
+  return scores
+}
+
+func isqrt(v int) int {
+  r := int(math.Sqrt(float64(v)))
+  for r*r > v {
+    r--
+  }
+  for (r+1)*(r+1) <= v {
+    r++
+  }
+  return r
+}
+
+// texturedSlots counts, for every threshold, the slots from start onwards
+// that belong to pixels scoring at least that threshold.
+func texturedSlots(scores []uint8, start int) [256]int {
+  var histogram [256]int
+  for p, score := range scores {
+    first := p * 3
+    if first+3 <= start {
+      continue
+    }
+    if first < start {
+      histogram[score] += first + 3 - start
+    } else {
+      histogram[score] += 3
+    }
+  }
+
+  var counts [256]int
+  total := 0
+  for t := 255; t >= 0; t-- {
+    total += histogram[t]
+    counts[t] = total
+  }
+  return counts
+}
+
+// adaptiveThreshold returns the highest texture threshold whose slots can
+// hold the payload without exceeding the density cap.
+func adaptiveThreshold(scores []uint8, start, bits int, maxDensity float64) (byte, bool) {
+  counts := texturedSlots(scores, start)
+  for t := 255; t >= minTextureScore; t-- {
+    if float64(bits) <= maxDensity*float64(counts[t]) {
+      return byte(t), true
+    }
+  }
+  return 0, false
+}
+
+func adaptiveCapacity(scores []uint8, start int, maxDensity float64) int {
+  counts := texturedSlots(scores, start)
+  return int(maxDensity*float64(counts[minTextureScore])) / bitsPerByte
+}
+
+// adaptiveSlots spreads bits evenly over the eligible slots so the changes
+// are not bunched up in the first textured columns.
+func adaptiveSlots(scores []uint8, threshold byte, start, bits int) []int {
+  eligible := texturedSlots(scores, start)[threshold]
+  if bits > eligible {
+    return nil
+  }
+
+  slots := make([]int, 0, bits)
+  index := 0
+  for p, score := range scores {
+    if score < threshold {
+      continue
+    }
+    for ch := 0; ch < 3; ch++ {
+      slot := p*3 + ch
+      if slot < start {
+        continue
+      }
+      if index*bits/eligible != (index+1)*bits/eligible {
+        slots = append(slots, slot)
+      }
+      index++
+    }
+  }
+
+  return slots
+}
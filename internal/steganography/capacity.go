@@ -0,0 +1,43 @@
+package steganography
+
+import (
+  "image"
+  "os"
+  _ "image/jpeg"
+  _ "image/png"
+)
+
+// Capacity holds the payload size, in bytes, each embedding mode can carry
+// in an image once the header is accounted for.
+type Capacity struct {
+  LSB        int
+  Adaptive   int
+  MaxDensity float64
+}
+
+func EstimateCapacity(imagePath string) (*Capacity, error) {
+  file, err := os.Open(imagePath)
+  if err != nil {
+    return nil, err
+  }
+  defer file.Close()
+
+  img, _, err := image.Decode(file)
+  if err != nil {
+    return nil, err
+  }
+
+  c := newCarrier(img)
+  start := (&Header{Version: formatVersion, Flags: FlagChecksum}).size() * bitsPerByte
+  adaptiveStart := (&Header{Version: formatVersion, Flags: FlagDensity | FlagChecksum}).size() * bitsPerByte
+
+  capacity := &Capacity{
+    MaxDensity: DefaultMaxDensity,
+    Adaptive:   adaptiveCapacity(textureMap(c), adaptiveStart, DefaultMaxDensity),
+  }
+  if c.slots() > start {
+    capacity.LSB = (c.slots() - start) / bitsPerByte
+  }
+
+  return capacity, nil
+}
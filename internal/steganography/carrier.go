@@ -0,0 +1,165 @@
+package steganography
+
+import (
+  "errors"
+  "image"
+  "io"
+)
+
+// carrier exposes the colour channels of an image as a flat run of LSB
+// slots. Slots walk the image column by column, and R, G, B within a pixel,
+// which is the order every format version has used.
+type carrier struct {
+  img    *image.RGBA
+  width  int
+  height int
+}
+
+func newCarrier(src image.Image) *carrier {
+  bounds := src.Bounds()
+  width := bounds.Max.X - bounds.Min.X
+  height := bounds.Max.Y - bounds.Min.Y
+
+  img := image.NewRGBA(image.Rect(0, 0, width, height))
+  for y := 0; y < height; y++ {
+    for x := 0; x < width; x++ {
+      r, g, b, a := src.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+      i := img.PixOffset(x, y)
+      img.Pix[i] = uint8(r >> 8)
+      img.Pix[i+1] = uint8(g >> 8)
+      img.Pix[i+2] = uint8(b >> 8)
+      img.Pix[i+3] = uint8(a >> 8)
+    }
+  }
+
+  return &carrier{
+    img:    img,
+    width:  width,
+    height: height,
+  }
+}
+
+func (c *carrier) slots() int {
+  return c.width * c.height * 3
+}
+
+func (c *carrier) offset(slot int) int {
+  x := slot / (c.height * 3)
+  y := (slot / 3) % c.height
+  return c.img.PixOffset(x, y) + slot%3
+}
+
+func (c *carrier) value(slot int) uint8 {
+  return c.img.Pix[c.offset(slot)]
+}
+
+func (c *carrier) setValue(slot int, v uint8) {
+  c.img.Pix[c.offset(slot)] = v
+}
+
+func (c *carrier) bit(slot int) byte {
+  return c.value(slot) & 1
+}
+
+// setBit reports whether the slot had to change to hold b.
+func (c *carrier) setBit(slot int, b byte) bool {
+  i := c.offset(slot)
+  if c.img.Pix[i]&1 == b {
+    return false
+  }
+  c.img.Pix[i] ^= 1
+  return true
+}
+
+func (c *carrier) flip(slot int) {
+  c.img.Pix[c.offset(slot)] ^= 1
+}
+
+func (c *carrier) writeBytes(data []byte, slot int) int {
+  changes := 0
+  for i := 0; i < len(data)*bitsPerByte; i++ {
+    if c.setBit(slot+i, dataBit(data, i)) {
+      changes++
+    }
+  }
+  return changes
+}
+
+func (c *carrier) readBytes(slot, n int) []byte {
+  data := make([]byte, n)
+  for i := 0; i < n*bitsPerByte; i++ {
+    data[i/bitsPerByte] |= c.bit(slot+i) << uint(7-i%bitsPerByte)
+  }
+  return data
+}
+
+// writeFrom streams n bytes from r into consecutive slots, so a large body
+// never has to be held in memory alongside the image.
+func (c *carrier) writeFrom(r io.Reader, slot, n int) (int, error) {
+  buf := make([]byte, 32*1024)
+  changes := 0
+  for written := 0; written < n; {
+    chunk := buf
+    if n-written < len(chunk) {
+      chunk = chunk[:n-written]
+    }
+    if _, err := io.ReadFull(r, chunk); err != nil {
+      if err == io.EOF || err == io.ErrUnexpectedEOF {
+        return changes, errors.New("payload shorter than its declared length")
+      }
+      return changes, err
+    }
+    changes += c.writeBytes(chunk, slot+written*bitsPerByte)
+    written += len(chunk)
+  }
+  return changes, nil
+}
+
+// slotReader reads bytes from consecutive slots.
+type slotReader struct {
+  c         *carrier
+  slot      int
+  remaining int
+}
+
+func (r *slotReader) Read(p []byte) (int, error) {
+  if r.remaining == 0 {
+    return 0, io.EOF
+  }
+  if len(p) > r.remaining {
+    p = p[:r.remaining]
+  }
+
+  for i := range p {
+    var b byte
+    for bit := 0; bit < bitsPerByte; bit++ {
+      b = b<<1 | r.c.bit(r.slot+bit)
+    }
+    p[i] = b
+    r.slot += bitsPerByte
+  }
+  r.remaining -= len(p)
+  return len(p), nil
+}
+
+func (c *carrier) writeSlots(data []byte, slots []int) int {
+  changes := 0
+  for i, slot := range slots {
+    if c.setBit(slot, dataBit(data, i)) {
+      changes++
+    }
+  }
+  return changes
+}
+
+func (c *carrier) readSlots(slots []int, n int) []byte {
+  data := make([]byte, n)
+  for i, slot := range slots[:n*bitsPerByte] {
+    data[i/bitsPerByte] |= c.bit(slot) << uint(7-i%bitsPerByte)
+  }
+  return data
+}
+
+func dataBit(data []byte, i int) byte {
+  return (data[i/bitsPerByte] >> uint(7-i%bitsPerByte)) & 1
+}
@@ -0,0 +1,50 @@
+package steganography
+
+import (
+  "errors"
+  "fmt"
+  "hash/crc32"
+
+  "github.com/pranaykumar2/steg-go/internal/crypto"
+)
+
+// Headers written with FlagChecksum end with a CRC-32 of the body. It is no
+// defence against tampering, which the payload's own authentication covers,
+// but it tells a payload damaged in the image apart from a key that does not
+// open it.
+const (
+  FlagChecksum byte = 0x08
+
+  checksumSize = 4
+)
+
+var (
+  ErrNoPayload      = errors.New("no steganographic data found")
+  ErrCorruptPayload = errors.New("hidden data is damaged")
+)
+
+func (h *Header) checksumMatches(body []byte) bool {
+  return h.Flags&FlagChecksum == 0 || crc32.ChecksumIEEE(body) == h.Checksum
+}
+
+// ChecksumFailed reports whether the body returned by the last Extract does
+// not match the checksum in the header. The header may be what is damaged,
+// so the body is still worth trying to decrypt.
+func (d *Decoder) ChecksumFailed() bool {
+  return d.checksumFailed
+}
+
+// PayloadError explains a failure to open the body returned by the last
+// Extract: when the checksum failed too, the image is damaged and err is
+// wrapped as ErrCorruptPayload. A failed stream segment stays in the chain
+// so its index can still be reported.
+func (d *Decoder) PayloadError(err error) error {
+  if err == nil || !d.checksumFailed {
+    return err
+  }
+  var segmentErr *crypto.SegmentError
+  if errors.As(err, &segmentErr) {
+    return fmt.Errorf("%w: %w", ErrCorruptPayload, err)
+  }
+  return fmt.Errorf("%w: %v", ErrCorruptPayload, err)
+}
@@ -0,0 +1,242 @@
+package steganography
+
+import (
+  "crypto/sha256"
+  "encoding/binary"
+  "math/bits"
+)
+
+// Redundant embedding writes several copies of the body, each scattered
+// over the whole image by a permutation keyed with the header's layout
+// seed. Localised damage then hits different bits in every copy and a
+// bitwise majority vote recovers the original.
+//
+// The header is replicated too, at positions that depend only on the size
+// of the layout and not on its seed, so a header damaged at the start of
+// the image can be voted back from its replicas.
+const (
+  FlagCopies byte = 0x02
+
+  AutoCopies     = -1
+  maxAutoCopies  = 9
+  layoutSeedSize = 16
+  headerReplicas = 4
+)
+
+// scatter is a keyed Feistel permutation over [0, domain), using cycle
+// walking to stay inside the domain, so positions never collide and need
+// no lookup table however large the image is.
+type scatter struct {
+  keys   [4]uint64
+  half   uint
+  mask   uint64
+  domain uint64
+}
+
+func newScatter(seed []byte, domain uint64) *scatter {
+  sum := sha256.Sum256(seed)
+  s := &scatter{domain: domain}
+  for i := range s.keys {
+    s.keys[i] = binary.BigEndian.Uint64(sum[i*8:])
+  }
+
+  width := uint(bits.Len64(domain - 1))
+  s.half = (width + 1) / 2
+  if s.half == 0 {
+    s.half = 1
+  }
+  s.mask = 1<<s.half - 1
+  return s
+}
+
+func (s *scatter) permute(x uint64) uint64 {
+  l, r := x>>s.half, x&s.mask
+  for _, key := range s.keys {
+    l, r = r, l^(mix64(r^key)&s.mask)
+  }
+  return l<<s.half | r
+}
+
+func (s *scatter) position(i uint64) uint64 {
+  for {
+    i = s.permute(i)
+    if i < s.domain {
+      return i
+    }
+  }
+}
+
+func mix64(x uint64) uint64 {
+  x ^= x >> 30
+  x *= 0xbf58476d1ce4e5b9
+  x ^= x >> 27
+  x *= 0x94d049bb133111eb
+  return x ^ x>>31
+}
+
+func autoCopies(bits, available int) int {
+  copies := available / bits
+  if copies > maxAutoCopies {
+    copies = maxAutoCopies
+  }
+  if copies > 1 && copies%2 == 0 {
+    copies--
+  }
+  if copies < 1 {
+    copies = 1
+  }
+  return copies
+}
+
+// replicaSlots returns where the header's replicas start: evenly spaced
+// through the layout, which begins right after the header. A layout too
+// small to space them out keeps none.
+func (h *Header) replicaSlots() []int {
+  bits := h.size() * bitsPerByte
+  step := h.LayoutSlots / (headerReplicas + 1)
+  if step < uint64(2*bits) {
+    return nil
+  }
+
+  slots := make([]int, headerReplicas)
+  for i := range slots {
+    slots[i] = bits + int(step)*(i+1)
+  }
+  return slots
+}
+
+// copySlots is how many slots of the layout are left to the copies.
+func (h *Header) copySlots() uint64 {
+  return h.LayoutSlots - uint64(len(h.replicaSlots())*h.size()*bitsPerByte)
+}
+
+// forEachCopySlot visits every (copy, bit) pair of a redundant body with
+// the slot that holds it, stepping over the header replicas.
+func forEachCopySlot(header *Header, start, bitCount int, visit func(copy, bit, slot int)) {
+  replicas := header.replicaSlots()
+  headerBits := header.size() * bitsPerByte
+  s := newScatter(header.LayoutSeed, header.copySlots())
+  for n := 0; n < int(header.Copies); n++ {
+    for i := 0; i < bitCount; i++ {
+      slot := start + int(s.position(uint64(n*bitCount+i)))
+      for _, replica := range replicas {
+        if slot >= replica {
+          slot += headerBits
+        }
+      }
+      visit(n, i, slot)
+    }
+  }
+}
+
+// writeHeaderReplicas writes the header's replicas into a redundant layout.
+func writeHeaderReplicas(c *carrier, header *Header) int {
+  changes := 0
+  for _, slot := range header.replicaSlots() {
+    if slot < c.slots() {
+      changes += c.writeBytes(header.marshal(), slot)
+    }
+  }
+  return changes
+}
+
+// readHeaderReplicas rebuilds the header of a redundant layout from the
+// copy at the start of the image and its replicas, for when the header read
+// from the start does not lead to an intact body. The replicas are found
+// from the layout size in that header, or from the size of a layout that
+// fills the image, as it does unless the image was cropped. It returns the
+// vote first and then every replica that reads on its own.
+func readHeaderReplicas(c *carrier, primary *Header) []*Header {
+  var layouts []uint64
+  if primary != nil && primary.Flags&FlagCopies != 0 {
+    layouts = append(layouts, primary.LayoutSlots)
+  }
+  if start := maxHeaderSize * bitsPerByte; c.slots() > start {
+    layouts = append(layouts, uint64(c.slots()-start))
+  }
+
+  seen := make(map[string]bool)
+  if primary != nil {
+    seen[string(primary.marshal())] = true
+  }
+
+  var headers []*Header
+  for _, layoutSlots := range layouts {
+    probe := &Header{Version: formatVersion, Flags: FlagCopies | FlagChecksum, LayoutSlots: layoutSlots}
+    starts := append([]int{0}, probe.replicaSlots()...)
+    if len(starts) == 1 {
+      continue
+    }
+
+    for _, data := range voteCopies(c, len(starts), maxHeaderSize, func(visit func(copy, bit, slot int)) {
+      for n, start := range starts {
+        for i := 0; i < maxHeaderSize*bitsPerByte; i++ {
+          visit(n, i, start+i)
+        }
+      }
+    }) {
+      header, err := parseHeader(data)
+      if err != nil || header.Flags&FlagCopies == 0 || seen[string(header.marshal())] {
+        continue
+      }
+      seen[string(header.marshal())] = true
+      headers = append(headers, header)
+    }
+  }
+  return headers
+}
+
+func writeCopies(c *carrier, body []byte, header *Header, start int) int {
+  changes := 0
+  forEachCopySlot(header, start, len(body)*bitsPerByte, func(_, bit, slot int) {
+    if c.setBit(slot, dataBit(body, bit)) {
+      changes++
+    }
+  })
+  return changes
+}
+
+func readCopies(c *carrier, header *Header, start, length int) [][]byte {
+  return voteCopies(c, int(header.Copies), length, func(visit func(copy, bit, slot int)) {
+    forEachCopySlot(header, start, length*bitsPerByte, visit)
+  })
+}
+
+// voteCopies returns the majority vote of count copies of length bytes,
+// whose slots each visits, followed by each copy on its own. Slots lost to
+// cropping count as erasures, and a tied bit is taken from the lowest
+// numbered copy that still has it.
+func voteCopies(c *carrier, count, length int, each func(visit func(copy, bit, slot int))) [][]byte {
+  bitCount := length * bitsPerByte
+  result := make([][]byte, count+1)
+  for i := range result {
+    result[i] = make([]byte, length)
+  }
+
+  votes := make([]int, bitCount)
+  first := make([]int8, bitCount)
+  each(func(n, bit, slot int) {
+    if slot >= c.slots() {
+      return
+    }
+
+    value := c.bit(slot)
+    result[n+1][bit/bitsPerByte] |= value << uint(7-bit%bitsPerByte)
+    if value == 1 {
+      votes[bit]++
+    } else {
+      votes[bit]--
+    }
+    if first[bit] == 0 {
+      first[bit] = int8(value)*2 - 1
+    }
+  })
+
+  for i, vote := range votes {
+    if vote > 0 || (vote == 0 && first[i] > 0) {
+      result[0][i/bitsPerByte] |= 1 << uint(7-i%bitsPerByte)
+    }
+  }
+
+  return result
+}
@@ -0,0 +1,121 @@
+package steganography
+
+import (
+  "bytes"
+  "image"
+  "image/color"
+  "image/png"
+  "math/rand"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+func writeNoiseImage(t *testing.T, path string, width, height int) {
+  t.Helper()
+  rng := rand.New(rand.NewSource(1))
+  img := image.NewRGBA(image.Rect(0, 0, width, height))
+  rng.Read(img.Pix)
+  for i := 3; i < len(img.Pix); i += 4 {
+    img.Pix[i] = 0xff
+  }
+  savePNG(t, path, img)
+}
+
+func savePNG(t *testing.T, path string, img image.Image) {
+  t.Helper()
+  file, err := os.Create(path)
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer file.Close()
+  if err := png.Encode(file, img); err != nil {
+    t.Fatal(err)
+  }
+}
+
+func loadRGBA(t *testing.T, path string) *image.RGBA {
+  t.Helper()
+  file, err := os.Open(path)
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer file.Close()
+  src, err := png.Decode(file)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return newCarrier(src).img
+}
+
+// Damage that wipes out the header at the start of the image has to be
+// voted away with the header's replicas, as the body's is with its copies.
+func TestCopiesSurviveHeaderDamage(t *testing.T) {
+  dir := t.TempDir()
+  cover := filepath.Join(dir, "cover.png")
+  writeNoiseImage(t, cover, 160, 90)
+
+  message := bytes.Repeat([]byte("redundant payload "), 12)
+
+  tests := []struct {
+    name   string
+    damage func(img *image.RGBA)
+  }{
+    {"none", func(img *image.RGBA) {}},
+    {"black first column", func(img *image.RGBA) {
+      for y := 0; y < img.Bounds().Dy(); y++ {
+        img.Set(0, y, color.RGBA{A: 0xff})
+      }
+    }},
+    {"left edge patch", func(img *image.RGBA) {
+      for x := 0; x < 10; x++ {
+        for y := 0; y < 40; y++ {
+          img.Set(x, y, color.RGBA{A: 0xff})
+        }
+      }
+    }},
+    {"header and one replica", func(img *image.RGBA) {
+      for x := 0; x < 3; x++ {
+        for y := 0; y < img.Bounds().Dy(); y++ {
+          img.Set(x, y, color.RGBA{R: 0xff, A: 0xff})
+          img.Set(img.Bounds().Dx()/5+x, y, color.RGBA{R: 0xff, A: 0xff})
+        }
+      }
+    }},
+  }
+
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      encoder, err := NewEncoderWithOptions(cover, Options{Copies: 5})
+      if err != nil {
+        t.Fatal(err)
+      }
+      if err := encoder.Hide(message); err != nil {
+        t.Fatal(err)
+      }
+      stego := filepath.Join(dir, "stego.png")
+      if err := encoder.SaveOutput(stego); err != nil {
+        t.Fatal(err)
+      }
+
+      img := loadRGBA(t, stego)
+      tt.damage(img)
+      savePNG(t, stego, img)
+
+      decoder, err := NewDecoder(stego)
+      if err != nil {
+        t.Fatal(err)
+      }
+      data, _, _, err := decoder.Extract()
+      if err != nil {
+        t.Fatalf("Extract: %v", err)
+      }
+      if decoder.ChecksumFailed() {
+        t.Error("checksum failed")
+      }
+      if !bytes.Equal(data, message) {
+        t.Error("extracted data differs from the message")
+      }
+    })
+  }
+}
@@ -1,17 +1,27 @@
 package steganography
 
 import (
-  "encoding/binary"
+  "bytes"
   "errors"
+  "hash/crc32"
   "image"
+  "io"
   "os"
   _ "image/jpeg"
   _ "image/png"
+
+  "github.com/pranaykumar2/steg-go/internal/crypto"
 )
 
 type Decoder struct {
   image       image.Image
   fileHandler *FileHandler
+  header      *Header
+  copies      [][]byte
+  signature   *Signature
+
+  checksumFailed bool
+  mode           byte
 }
 
 func NewDecoder(imagePath string) (*Decoder, error) {
@@ -33,45 +43,225 @@ func NewDecoder(imagePath string) (*Decoder, error) {
 }
 
 func (d *Decoder) Extract() ([]byte, bool, *FileMetadata, error) {
-  bounds := d.image.Bounds()
-  width := bounds.Max.X - bounds.Min.X
-  height := bounds.Max.Y - bounds.Min.Y
+  c := newCarrier(d.image)
+
+  header, err := readHeader(c)
+  var data []byte
+  var copies [][]byte
+  if err == nil {
+    data, copies, err = readPayload(c, header)
+  }
+
+  // The header itself may be what is damaged. Redundant layouts keep
+  // replicas of it, and the first one that leads to an intact body wins.
+  if err != nil || !header.checksumMatches(data) {
+    for _, replica := range readHeaderReplicas(c, header) {
+      replicaData, replicaCopies, replicaErr := readPayload(c, replica)
+      if replicaErr != nil {
+        continue
+      }
+      intact := replica.checksumMatches(replicaData)
+      if err != nil || intact {
+        header, data, copies, err = replica, replicaData, replicaCopies, nil
+      }
+      if intact {
+        break
+      }
+    }
+  }
+  if err != nil {
+    return nil, false, nil, err
+  }
+
+  d.header = header
+  d.copies = copies
+  d.checksumFailed = !header.checksumMatches(data)
+
+  return d.parseBody(data)
+}
+
+// readPayload reads the body the header describes and, for a redundant
+// layout, each of its copies.
+func readPayload(c *carrier, header *Header) ([]byte, [][]byte, error) {
+  if header.Flags&FlagCopies == 0 {
+    data, err := readBody(c, header)
+    return data, nil, err
+  }
+
+  bodies, err := readRedundantBody(c, header)
+  if err != nil {
+    return nil, nil, err
+  }
+  data, copies := bodies[0], bodies[1:]
+
+  // A vote can go wrong where most copies are damaged in the same place
+  // yet one of them is intact.
+  if !header.checksumMatches(data) {
+    for _, body := range copies {
+      if header.checksumMatches(body) {
+        data = body
+        break
+      }
+    }
+  }
+  return data, copies, nil
+}
+
+// ExtractReader is Extract for bodies too large to copy out of the image
+// comfortably. A plain LSB body is read straight from the image's slots as
+// the reader is consumed; other layouts fall back to Extract.
+func (d *Decoder) ExtractReader() (io.Reader, bool, *FileMetadata, error) {
+  c := newCarrier(d.image)
+
+  // Only an intact plain LSB body is streamed. Anything else goes through
+  // Extract, which can also fall back to the header's replicas; bodies with
+  // plain text file metadata predate streaming and are small.
+  header, err := readHeader(c)
+  sequential := err == nil && header.Mode == ModeLSB && header.Flags&(FlagCopies|FlagSigned) == 0
+  start := 0
+  if sequential {
+    start = header.size() * bitsPerByte
+    sequential = header.Length > 0 && header.Length <= uint64(c.slots()-start)/bitsPerByte &&
+      c.readBytes(start, 1)[0] != FileModeEnabled
+  }
+
+  // The body is in memory as the image anyway, so checking it up front
+  // costs a pass over the slots but no copy.
+  if sequential && header.Flags&FlagChecksum != 0 {
+    checksum := crc32.NewIEEE()
+    io.Copy(checksum, &slotReader{c: c, slot: start, remaining: int(header.Length)})
+    sequential = checksum.Sum32() == header.Checksum
+  }
+
+  if !sequential {
+    data, isFile, metadata, err := d.Extract()
+    if err != nil {
+      return nil, false, nil, err
+    }
+    return bytes.NewReader(data), isFile, metadata, nil
+  }
+
+  d.header = header
+  d.copies = nil
+  d.signature = nil
+  d.checksumFailed = false
+
+  d.mode = c.readBytes(start, 1)[0]
+  isFile := d.mode == EncryptedFileModeEnabled
+  return &slotReader{c: c, slot: start + bitsPerByte, remaining: int(header.Length) - 1}, isFile, nil, nil
+}
+
+// ExtractedFile is a file payload decrypted to disk by DecryptFile.
+type ExtractedFile struct {
+  Path     string
+  Metadata *FileMetadata
+  Size     int64
+
+  // Copy is the redundant copy the file came from, counting from one, or
+  // zero for the body as extracted.
+  Copy int
+}
 
-  headerBytes := make([]byte, len(headerPattern))
-  bitIndex := 0
-  for i := 0; i < len(headerPattern); i++ {
-    headerBytes[i] = readByte(d.image, &bitIndex, width, height)
+// DecryptFile decrypts the encrypted file body returned by ExtractReader
+// straight into a temporary file in dir, so neither the ciphertext nor the
+// plaintext is ever held whole. When the body does not decrypt and the
+// image has redundant copies, each copy is tried in turn. The caller moves
+// the file into place with FileHandler.MoveFileContent, or removes it with
+// WipeFile. A non-nil check is handed the payload's info before anything
+// is written, and an error from it ends the extraction.
+func (d *Decoder) DecryptFile(body io.Reader, encryptor *crypto.Encryptor, dir string, check func(*crypto.PayloadInfo) error) (*ExtractedFile, error) {
+  file, err := os.CreateTemp(dir, ".extract-*")
+  if err != nil {
+    return nil, err
   }
+  result := &ExtractedFile{Path: file.Name()}
 
-  if string(headerBytes) != headerPattern {
-    return nil, false, nil, errors.New("no steganographic data found")
+  var refused error
+  if check != nil {
+    encryptor.SetInfoCheck(func(info *crypto.PayloadInfo) error {
+      refused = check(info)
+      return refused
+    })
+    defer encryptor.SetInfoCheck(nil)
   }
 
-  version := readByte(d.image, &bitIndex, width, height)
-  if version != formatVersion {
-    return nil, false, nil, errors.New("unsupported steganography format version")
+  decrypt := func(src io.Reader) error {
+    if err := zeroFile(file); err != nil {
+      return err
+    }
+    if err := file.Truncate(0); err != nil {
+      return err
+    }
+    if _, err := file.Seek(0, io.SeekStart); err != nil {
+      return err
+    }
+
+    unpacker := d.fileHandler.NewUnpacker(file, d.header.Padded())
+    if err := encryptor.DecryptStream(unpacker, src); err != nil {
+      return err
+    }
+    metadata, err := unpacker.Metadata()
+    if err != nil {
+      return err
+    }
+    result.Metadata, result.Size = metadata, unpacker.Written()
+    return nil
   }
 
-  lengthBytes := make([]byte, 8)
-  for i := 0; i < 8; i++ {
-    lengthBytes[i] = readByte(d.image, &bitIndex, width, height)
+  err = decrypt(body)
+  for i := 0; err != nil && refused == nil && i < len(d.copies); i++ {
+    copyData, _, _, copyErr := d.ExtractCopy(i)
+    if copyErr != nil {
+      continue
+    }
+    if decrypt(bytes.NewReader(copyData)) == nil {
+      err, result.Copy = nil, i+1
+    }
   }
 
-  dataLength := binary.BigEndian.Uint64(lengthBytes)
-  if dataLength == 0 || dataLength > uint64((width*height*3)/8) {
-    return nil, false, nil, errors.New("invalid data length")
+  if closeErr := file.Close(); err == nil {
+    err = closeErr
   }
+  if err != nil {
+    WipeFile(result.Path)
+    return nil, err
+  }
+  return result, nil
+}
+
+// CopyCount reports how many redundant copies the last Extract found. When
+// the majority vote does not decrypt, each copy can be tried on its own.
+func (d *Decoder) CopyCount() int {
+  return len(d.copies)
+}
 
-  modeIndicator := readByte(d.image, &bitIndex, width, height)
+func (d *Decoder) ExtractCopy(index int) ([]byte, bool, *FileMetadata, error) {
+  if index < 0 || index >= len(d.copies) {
+    return nil, false, nil, errors.New("no such copy")
+  }
+  return d.parseBody(d.copies[index])
+}
 
-  bitIndex -= 8
+// parseBody splits a body into its content and file details. Files written
+// before the metadata was encrypted carry it in plain text ahead of the
+// content; for current files the metadata is nil and has to be unpacked
+// from the decrypted content with FileHandler.UnpackFile.
+func (d *Decoder) parseBody(data []byte) ([]byte, bool, *FileMetadata, error) {
+  d.signature = nil
+  if d.header.Signed() {
+    var err error
+    data, d.signature, err = splitSignature(data)
+    if err != nil {
+      return nil, false, nil, err
+    }
+  }
 
-  data := make([]byte, dataLength)
-  for i := uint64(0); i < dataLength; i++ {
-    data[i] = readByte(d.image, &bitIndex, width, height)
+  d.mode = data[0]
+  if data[0] == EncryptedFileModeEnabled {
+    return data[1:], true, nil, nil
   }
 
-  isFile := modeIndicator == FileModeEnabled
+  isFile := data[0] == FileModeEnabled
 
   var metadata *FileMetadata
   var contentData []byte
@@ -95,30 +285,57 @@ func (d *Decoder) Extract() ([]byte, bool, *FileMetadata, error) {
   return contentData, isFile, metadata, nil
 }
 
-func readByte(img image.Image, bitIndex *int, width, height int) byte {
-  var b byte
-  for bit := 7; bit >= 0; bit-- {
-    x := *bitIndex / (height * 3)
-    y := (*bitIndex / 3) % height
+// Header returns the header read by the last successful Extract.
+func (d *Decoder) Header() *Header {
+  return d.header
+}
 
-    if x >= width {
-      return 0
-    }
+func readBody(c *carrier, header *Header) ([]byte, error) {
+  start := header.size() * bitsPerByte
+  availableBits := uint64(c.slots() - start)
 
-    r, g, b_, _ := img.At(x, y).RGBA()
-    var colorBit uint8
+  if header.Length == 0 || header.Length > availableBits/bitsPerByte {
+    return nil, errors.New("invalid data length")
+  }
+  length := int(header.Length)
 
-    switch *bitIndex % 3 {
-    case 0:
-      colorBit = uint8(r & 1)
-    case 1:
-      colorBit = uint8(g & 1)
-    case 2:
-      colorBit = uint8(b_ & 1)
+  switch header.Mode {
+  case ModeLSB:
+    return c.readBytes(start, length), nil
+  case ModeMatrix:
+    k := int(header.Param)
+    if k < 1 || k > maxHammingK {
+      return nil, errors.New("invalid matrix embedding parameters")
+    }
+    if uint64(hammingSlots(length*bitsPerByte, k)) > availableBits {
+      return nil, errors.New("invalid data length")
+    }
+    return matrixExtract(c, start, k, length), nil
+  case ModeAdaptive:
+    slots := adaptiveSlots(textureMap(c), header.Param, start, length*bitsPerByte)
+    if slots == nil {
+      return nil, errors.New("invalid data length")
     }
+    return c.readSlots(slots, length), nil
+  default:
+    return nil, errors.New("unsupported embedding mode")
+  }
+}
 
-    b |= colorBit << uint(bit)
-    *bitIndex++
+func readRedundantBody(c *carrier, header *Header) ([][]byte, error) {
+  if header.Mode != ModeLSB || header.Copies == 0 {
+    return nil, errors.New("invalid redundant layout")
   }
-  return b
+
+  // The layout may span more slots than a cropped image still has, but not
+  // absurdly more, which would only mean a corrupt header.
+  start := header.size() * bitsPerByte
+  if header.LayoutSlots == 0 || header.LayoutSlots > 4*uint64(c.slots()) {
+    return nil, errors.New("invalid redundant layout")
+  }
+  if header.Length == 0 || header.Length > header.copySlots()/bitsPerByte/uint64(header.Copies) {
+    return nil, errors.New("invalid data length")
+  }
+
+  return readCopies(c, header, start, int(header.Length)), nil
 }
@@ -0,0 +1,167 @@
+package steganography
+
+import (
+  "bytes"
+  "errors"
+  "io"
+  "math/rand"
+  "os"
+  "path/filepath"
+  "testing"
+  "time"
+
+  "github.com/pranaykumar2/steg-go/internal/crypto"
+)
+
+// A file payload spanning several stream segments is decrypted from the
+// image straight to disk, padding and metadata stripped on the way.
+func TestDecryptFileStreams(t *testing.T) {
+  dir := t.TempDir()
+  cover := filepath.Join(dir, "cover.png")
+  writeNoiseImage(t, cover, 600, 400)
+
+  contents := make([]byte, crypto.DefaultSegmentSize+1000)
+  rand.New(rand.NewSource(3)).Read(contents)
+  metadata := &FileMetadata{OriginalName: "report.bin", FileExt: ".bin", FileSize: uint64(len(contents))}
+
+  for _, padding := range []PaddingMode{PaddingNone, PaddingBucket} {
+    t.Run(padding.String(), func(t *testing.T) {
+      encryptor, err := crypto.NewEncryptor()
+      if err != nil {
+        t.Fatal(err)
+      }
+      encoder, err := NewEncoderWithOptions(cover, Options{Padding: padding})
+      if err != nil {
+        t.Fatal(err)
+      }
+
+      plaintext := NewFileHandler().PackFile(contents, metadata)
+      size, err := encoder.PaddedSize(len(plaintext), encryptor.Overhead)
+      if err != nil {
+        t.Fatal(err)
+      }
+      if size != len(plaintext) {
+        if plaintext, err = Pad(plaintext, size); err != nil {
+          t.Fatal(err)
+        }
+      }
+      var ciphertext bytes.Buffer
+      if err := encryptor.EncryptStream(&ciphertext, bytes.NewReader(plaintext)); err != nil {
+        t.Fatal(err)
+      }
+      if err := encoder.HideFile(ciphertext.Bytes()); err != nil {
+        t.Fatal(err)
+      }
+      stego := filepath.Join(dir, "stego.png")
+      if err := encoder.SaveOutput(stego); err != nil {
+        t.Fatal(err)
+      }
+
+      decoder, err := NewDecoder(stego)
+      if err != nil {
+        t.Fatal(err)
+      }
+      body, isFile, _, err := decoder.ExtractReader()
+      if err != nil {
+        t.Fatal(err)
+      }
+      if !isFile {
+        t.Fatal("payload not reported as a file")
+      }
+      header, err := crypto.ReadEnvelopeHeader(body)
+      if err != nil {
+        t.Fatal(err)
+      }
+      extracted, err := decoder.DecryptFile(io.MultiReader(bytes.NewReader(header), body), encryptor, dir, nil)
+      if err != nil {
+        t.Fatalf("DecryptFile: %v", err)
+      }
+      defer WipeFile(extracted.Path)
+
+      if *extracted.Metadata != *metadata {
+        t.Errorf("metadata = %+v, want %+v", *extracted.Metadata, *metadata)
+      }
+      if extracted.Size != int64(len(contents)) {
+        t.Errorf("size = %d, want %d", extracted.Size, len(contents))
+      }
+      got, err := os.ReadFile(extracted.Path)
+      if err != nil {
+        t.Fatal(err)
+      }
+      if !bytes.Equal(got, contents) {
+        t.Error("extracted file differs from the original")
+      }
+    })
+  }
+}
+
+// An expired file is refused from its info, before any of it is written,
+// and the refusal is not retried on the redundant copies.
+func TestDecryptFileChecksInfoFirst(t *testing.T) {
+  dir := t.TempDir()
+  cover := filepath.Join(dir, "cover.png")
+  writeNoiseImage(t, cover, 600, 400)
+
+  encryptor, err := crypto.NewEncryptor()
+  if err != nil {
+    t.Fatal(err)
+  }
+  if err := encryptor.SetInfo(&crypto.PayloadInfo{Expires: time.Now().Add(-time.Hour)}); err != nil {
+    t.Fatal(err)
+  }
+  encoder, err := NewEncoderWithOptions(cover, Options{Copies: 3})
+  if err != nil {
+    t.Fatal(err)
+  }
+  contents := bytes.Repeat([]byte("expired "), 1000)
+  metadata := &FileMetadata{OriginalName: "old.txt", FileExt: ".txt", FileSize: uint64(len(contents))}
+  var ciphertext bytes.Buffer
+  if err := encryptor.EncryptStream(&ciphertext, bytes.NewReader(NewFileHandler().PackFile(contents, metadata))); err != nil {
+    t.Fatal(err)
+  }
+  if err := encoder.HideFile(ciphertext.Bytes()); err != nil {
+    t.Fatal(err)
+  }
+  stego := filepath.Join(dir, "stego.png")
+  if err := encoder.SaveOutput(stego); err != nil {
+    t.Fatal(err)
+  }
+
+  decoder, err := NewDecoder(stego)
+  if err != nil {
+    t.Fatal(err)
+  }
+  body, _, _, err := decoder.ExtractReader()
+  if err != nil {
+    t.Fatal(err)
+  }
+  header, err := crypto.ReadEnvelopeHeader(body)
+  if err != nil {
+    t.Fatal(err)
+  }
+  out := t.TempDir()
+  checks := 0
+  _, err = decoder.DecryptFile(io.MultiReader(bytes.NewReader(header), body), encryptor, out,
+    func(info *crypto.PayloadInfo) error {
+      checks++
+      written, _ := filepath.Glob(filepath.Join(out, ".extract-*"))
+      for _, path := range written {
+        if stat, err := os.Stat(path); err == nil && stat.Size() > 0 {
+          t.Error("content was written before the info was checked")
+        }
+      }
+      if info.Expired(time.Now()) {
+        return crypto.ErrExpired
+      }
+      return nil
+    })
+  if !errors.Is(err, crypto.ErrExpired) {
+    t.Fatalf("DecryptFile = %v, want ErrExpired", err)
+  }
+  if checks != 1 {
+    t.Errorf("info checked %d times, want once", checks)
+  }
+  if left, _ := os.ReadDir(out); len(left) != 0 {
+    t.Errorf("%d files left behind", len(left))
+  }
+}
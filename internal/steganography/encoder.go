@@ -1,11 +1,14 @@
 package steganography
 
 import (
-  "encoding/binary"
+  "bytes"
+  "crypto/ed25519"
+  "crypto/rand"
   "fmt"
+  "hash/crc32"
   "image"
-  "image/color"
   "image/png"
+  "io"
   "os"
   "path/filepath"
   _ "image/jpeg"
@@ -14,142 +17,317 @@ import (
 )
 
 const (
-  headerPattern = "STEG"
-  headerSize    = 13
-  bitsPerByte   = 8
-  formatVersion = byte(1)
+  headerPattern       = "STEG"
+  headerSize          = 13
+  bitsPerByte         = 8
+  legacyFormatVersion = byte(1)
+  formatVersion       = byte(2)
 )
 
+type Options struct {
+  Mode              EmbedMode
+  MaxDensity        float64
+  PreserveHistogram bool
+  Padding           PaddingMode
+  RandomizeUnused   bool
+  Copies            int
+  Signer            ed25519.PrivateKey
+
+  // Scrub marks, by slot, a payload being replaced. Slots the new payload
+  // does not cover are refilled with random bits.
+  Scrub []bool
+}
+
+type EmbedStats struct {
+  Mode               EmbedMode
+  Padding            PaddingMode
+  CodeSize           int
+  Threshold          int
+  PayloadBits        int
+  Copies             int
+  SlotsUsed          int
+  Changes            int
+  CorrectionChanges  int
+  RandomFillChanges  int
+  HistogramDeviation float64
+}
+
 type Encoder struct {
-  processor *imageprocessing.ImageProcessor
-  image     image.Image
+  processor   *imageprocessing.ImageProcessor
+  image       image.Image
   fileHandler *FileHandler
+  options     Options
+  header      *Header
+  stats       EmbedStats
 }
 
 func NewEncoder(imagePath string) (*Encoder, error) {
+  return NewEncoderWithOptions(imagePath, Options{})
+}
+
+func NewEncoderWithOptions(imagePath string, options Options) (*Encoder, error) {
   processor, err := imageprocessing.NewImageProcessor(imagePath)
   if err != nil {
     return nil, err
   }
 
   return &Encoder{
-    processor: processor,
-    image:     processor.GetImage(),
+    processor:   processor,
+    image:       processor.GetImage(),
     fileHandler: NewFileHandler(),
+    options:     options,
   }, nil
 }
 
 func (e *Encoder) Hide(data []byte) error {
-  bounds := e.image.Bounds()
-  width := bounds.Max.X - bounds.Min.X
-  height := bounds.Max.Y - bounds.Min.Y
-
-  metadata := []byte{TextModeEnabled}
+  body := make([]byte, 0, 1+len(data))
+  body = append(body, TextModeEnabled)
+  body = append(body, data...)
 
-  totalDataSize := len(headerPattern) + 1 + 8 + len(metadata) + len(data)
-  requiredBits := totalDataSize * 8
-  availableBits := width * height * 3
+  return e.embed(body)
+}
 
-  if requiredBits > availableBits {
-    return fmt.Errorf("image too small, need %d bits but have %d", requiredBits, availableBits)
-  }
+// HideFile embeds a file encrypted together with its metadata, see
+// FileHandler.PackFile.
+func (e *Encoder) HideFile(data []byte) error {
+  body := make([]byte, 0, 1+len(data))
+  body = append(body, EncryptedFileModeEnabled)
+  body = append(body, data...)
 
-  output := image.NewRGBA(bounds)
+  return e.embed(body)
+}
 
-  for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-    for x := bounds.Min.X; x < bounds.Max.X; x++ {
-      r, g, b, a := e.image.At(x, y).RGBA()
-      output.Set(x, y, color.RGBA{
-        R: uint8(r >> 8),
-        G: uint8(g >> 8),
-        B: uint8(b >> 8),
-        A: uint8(a >> 8),
-      })
-    }
-  }
+// HideFileStream is HideFile for an encrypted payload of size bytes read
+// from src, such as the output of Encryptor.EncryptStream.
+func (e *Encoder) HideFileStream(src io.Reader, size int) error {
+  mode := bytes.NewReader([]byte{EncryptedFileModeEnabled})
+  return e.embedFrom(io.MultiReader(mode, src), 1+size)
+}
 
-  bitIndex := 0
+func (e *Encoder) Stats() EmbedStats {
+  return e.stats
+}
 
-  for i := 0; i < len(headerPattern); i++ {
-    b := headerPattern[i]
-    writeByte(output, b, &bitIndex, width, height)
-  }
+// Header returns the header written by the last successful Hide or HideFile.
+func (e *Encoder) Header() *Header {
+  return e.header
+}
 
-  writeByte(output, formatVersion, &bitIndex, width, height)
+func (e *Encoder) embed(body []byte) error {
+  return e.embedFrom(bytes.NewReader(body), len(body))
+}
 
-  lengthBytes := make([]byte, 8)
-  binary.BigEndian.PutUint64(lengthBytes, uint64(len(metadata) + len(data)))
-  for i := 0; i < len(lengthBytes); i++ {
-    writeByte(output, lengthBytes[i], &bitIndex, width, height)
+// embedFrom embeds a body of length bytes read from src. Plain LSB writes
+// it into the image as it is read; signing, redundant copies and the other
+// modes need all of it up front.
+func (e *Encoder) embedFrom(src io.Reader, length int) error {
+  c := newCarrier(e.image)
+
+  var body []byte
+  if e.options.Signer != nil || e.options.Mode != ModeLSB || e.options.Copies > 1 || e.options.Copies == AutoCopies {
+    body = make([]byte, length)
+    if _, err := io.ReadFull(src, body); err != nil {
+      return fmt.Errorf("failed to read payload: %v", err)
+    }
   }
 
-  for i := 0; i < len(metadata); i++ {
-    writeByte(output, metadata[i], &bitIndex, width, height)
+  var flags byte
+  if e.options.Signer != nil {
+    body = signBody(body, e.options.Signer)
+    src = bytes.NewReader(body)
+    length = len(body)
+    flags |= FlagSigned
   }
 
-  for i := 0; i < len(data); i++ {
-    writeByte(output, data[i], &bitIndex, width, height)
+  header := &Header{
+    Version: formatVersion,
+    Mode:    e.options.Mode,
+    Flags:   flags | e.optionFlags() | FlagChecksum,
+    Length:  uint64(length),
+  }
+  if e.options.Padding != PaddingNone {
+    header.Flags |= FlagPadded
+  }
+  if header.Mode == ModeAdaptive {
+    header.Density = densityByte(e.maxDensity())
+  }
+  if e.options.Copies > 1 || e.options.Copies == AutoCopies {
+    if header.Mode != ModeLSB {
+      return fmt.Errorf("redundant copies are only supported in lsb mode")
+    }
+    header.Flags |= FlagCopies
   }
 
-  e.processor = &imageprocessing.ImageProcessor{}
-  e.image = output
-  return nil
-}
-
-func (e *Encoder) HideFile(fileData []byte, metadata *FileMetadata) error {
-  bounds := e.image.Bounds()
-  width := bounds.Max.X - bounds.Min.X
-  height := bounds.Max.Y - bounds.Min.Y
+  start := header.size() * bitsPerByte
+  requiredBits := length * bitsPerByte
+  availableBits := c.slots() - start
 
-  metadataBytes := e.fileHandler.SerializeMetadata(metadata)
+  stats := EmbedStats{
+    Mode:        header.Mode,
+    Padding:     e.options.Padding,
+    PayloadBits: requiredBits,
+  }
 
-  totalDataSize := len(headerPattern) + 1 + 8 + len(metadataBytes) + len(fileData)
-  requiredBits := totalDataSize * 8
-  availableBits := width * height * 3
+  var slots []int
 
-  if requiredBits > availableBits {
-    return fmt.Errorf("image too small, need %d bits but have %d", requiredBits, availableBits)
+  switch header.Mode {
+  case ModeLSB:
+    if requiredBits > availableBits {
+      return fmt.Errorf("image too small, need %d bits but have %d", start+requiredBits, c.slots())
+    }
+    stats.SlotsUsed = requiredBits
+
+    if header.Flags&FlagCopies != 0 {
+      header.LayoutSlots = uint64(availableBits)
+      copyBits := int(header.copySlots())
+
+      copies := e.options.Copies
+      if copies == AutoCopies {
+        copies = autoCopies(requiredBits, copyBits)
+      }
+      if copies > 255 || copies*requiredBits > copyBits {
+        return fmt.Errorf("image too small for %d copies, need %d bits but have %d",
+          copies, c.slots()-copyBits+copies*requiredBits, c.slots())
+      }
+
+      seed := make([]byte, layoutSeedSize)
+      if _, err := rand.Read(seed); err != nil {
+        return fmt.Errorf("failed to generate layout seed: %v", err)
+      }
+      header.Copies = byte(copies)
+      header.LayoutSeed = seed
+      stats.Copies = copies
+      stats.SlotsUsed = copies * requiredBits
+    }
+  case ModeMatrix:
+    k, ok := hammingK(requiredBits, availableBits)
+    if !ok {
+      return fmt.Errorf("image too small, need %d bits but have %d", start+requiredBits, c.slots())
+    }
+    header.Param = byte(k)
+    stats.CodeSize = k
+    stats.SlotsUsed = hammingSlots(requiredBits, k)
+  case ModeAdaptive:
+    scores := textureMap(c)
+    threshold, ok := adaptiveThreshold(scores, start, requiredBits, e.maxDensity())
+    if !ok {
+      return fmt.Errorf("not enough textured area for %d bits (adaptive capacity is %d bytes)",
+        requiredBits, adaptiveCapacity(scores, start, e.maxDensity()))
+    }
+    header.Param = threshold
+    stats.Threshold = int(threshold)
+    slots = adaptiveSlots(scores, threshold, start, requiredBits)
+    stats.SlotsUsed = len(slots)
+  default:
+    return fmt.Errorf("unsupported embedding mode: %s", header.Mode)
   }
 
-  output := image.NewRGBA(bounds)
+  // The header goes in last, once a streamed body's checksum is known.
+  cover := channelHistogram(c)
+  switch {
+  case header.Flags&FlagCopies != 0:
+    stats.Changes = writeCopies(c, body, header, start)
+  case header.Mode == ModeLSB && body == nil:
+    checksum := crc32.NewIEEE()
+    changes, err := c.writeFrom(io.TeeReader(src, checksum), start, length)
+    if err != nil {
+      return err
+    }
+    stats.Changes = changes
+    header.Checksum = checksum.Sum32()
+  case header.Mode == ModeLSB:
+    stats.Changes = c.writeBytes(body, start)
+  case header.Mode == ModeMatrix:
+    stats.Changes = matrixEmbed(c, body, start, stats.CodeSize)
+  case header.Mode == ModeAdaptive:
+    stats.Changes = c.writeSlots(body, slots)
+  }
+  if body != nil {
+    header.Checksum = crc32.ChecksumIEEE(body)
+  }
+  stats.Changes += c.writeBytes(header.marshal(), 0)
+  if header.Flags&FlagCopies != 0 {
+    stats.Changes += writeHeaderReplicas(c, header)
+  }
 
-  for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-    for x := bounds.Min.X; x < bounds.Max.X; x++ {
-      r, g, b, a := e.image.At(x, y).RGBA()
-      output.Set(x, y, color.RGBA{
-        R: uint8(r >> 8),
-        G: uint8(g >> 8),
-        B: uint8(b >> 8),
-        A: uint8(a >> 8),
+  var used []bool
+  if e.options.RandomizeUnused || e.options.PreserveHistogram || len(e.options.Scrub) > 0 {
+    if header.Flags&FlagCopies != 0 {
+      used = usedSlots(c.slots(), start, 0, nil)
+      forEachCopySlot(header, start, requiredBits, func(_, _, slot int) {
+        used[slot] = true
       })
+      for _, slot := range header.replicaSlots() {
+        for i := 0; i < start; i++ {
+          used[slot+i] = true
+        }
+      }
+    } else {
+      used = usedSlots(c.slots(), start, stats.SlotsUsed, slots)
     }
   }
 
-  bitIndex := 0
-
-  for i := 0; i < len(headerPattern); i++ {
-    b := headerPattern[i]
-    writeByte(output, b, &bitIndex, width, height)
+  // Adaptive mode spreads the payload over the whole textured area, so
+  // there is no boundary to hide and filling flat regions with noise would
+  // only undo the point of the mode.
+  if e.options.RandomizeUnused && header.Mode != ModeAdaptive {
+    changes, err := randomizeUnused(c, used)
+    if err != nil {
+      return fmt.Errorf("failed to randomize unused capacity: %v", err)
+    }
+    stats.RandomFillChanges = changes
+  } else if len(e.options.Scrub) > 0 {
+    changes, err := scrubSlots(c, used, e.options.Scrub)
+    if err != nil {
+      return fmt.Errorf("failed to clear the previous payload: %v", err)
+    }
+    stats.RandomFillChanges = changes
   }
 
-  writeByte(output, formatVersion, &bitIndex, width, height)
-  lengthBytes := make([]byte, 8)
-  binary.BigEndian.PutUint64(lengthBytes, uint64(len(metadataBytes) + len(fileData)))
-  for i := 0; i < len(lengthBytes); i++ {
-    writeByte(output, lengthBytes[i], &bitIndex, width, height)
+  if e.options.PreserveHistogram {
+    changes, err := correctHistogram(c, cover, used)
+    if err != nil {
+      return fmt.Errorf("histogram correction failed: %v", err)
+    }
+    stats.CorrectionChanges = changes
   }
+  stats.HistogramDeviation = histogramDeviation(cover, channelHistogram(c), c.slots())
 
-  for i := 0; i < len(metadataBytes); i++ {
-    writeByte(output, metadataBytes[i], &bitIndex, width, height)
+  e.processor = &imageprocessing.ImageProcessor{}
+  e.image = c.img
+  e.header = header
+  e.stats = stats
+  return nil
+}
+
+// usedSlots marks the header and payload slots. Range modes use a run of
+// span slots right after the header; adaptive mode passes its slot list.
+func usedSlots(total, start, span int, slots []int) []bool {
+  used := make([]bool, total)
+  if slots == nil {
+    for i := 0; i < start+span; i++ {
+      used[i] = true
+    }
+    return used
   }
 
-  for i := 0; i < len(fileData); i++ {
-    writeByte(output, fileData[i], &bitIndex, width, height)
+  for i := 0; i < start; i++ {
+    used[i] = true
+  }
+  for _, slot := range slots {
+    used[slot] = true
   }
+  return used
+}
 
-  e.processor = &imageprocessing.ImageProcessor{}
-  e.image = output
-  return nil
+// maxDensity is the density cap rounded as the header records it, so a
+// rekey embeds under exactly the same one.
+func (e *Encoder) maxDensity() float64 {
+  density := e.options.MaxDensity
+  if density <= 0 || density > 1 {
+    density = DefaultMaxDensity
+  }
+  return float64(densityByte(density)) / densityScale
 }
 
 func (e *Encoder) SaveOutput(outputPath string) error {
@@ -165,24 +343,3 @@ func (e *Encoder) SaveOutput(outputPath string) error {
 
   return png.Encode(output, e.image)
 }
-
-func writeByte(img *image.RGBA, b byte, bitIndex *int, width, height int) {
-  for bit := 7; bit >= 0; bit-- {
-    x := *bitIndex / (height * 3)
-    y := (*bitIndex / 3) % height
-    colorBit := (b >> uint(bit)) & 1
-    c := img.RGBAAt(x, y)
-
-    switch *bitIndex % 3 {
-    case 0:
-      c.R = (c.R & 0xFE) | uint8(colorBit)
-    case 1:
-      c.G = (c.G & 0xFE) | uint8(colorBit)
-    case 2:
-      c.B = (c.B & 0xFE) | uint8(colorBit)
-    }
-
-    img.SetRGBA(x, y, c)
-    *bitIndex++
-  }
-}
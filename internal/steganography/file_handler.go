@@ -3,15 +3,17 @@ package steganography
 import (
   "encoding/binary"
   "errors"
+  "io"
   "os"
   "path/filepath"
   "strings"
 )
 
 const (
-  FileModeEnabled byte = 0x01
-  TextModeEnabled byte = 0x00
-  MetadataSize         = 256
+  FileModeEnabled          byte = 0x01
+  TextModeEnabled          byte = 0x00
+  EncryptedFileModeEnabled byte = 0x02
+  MetadataSize                  = 256
 )
 
 type FileMetadata struct {
@@ -44,19 +46,113 @@ func (fh *FileHandler) ReadFileContent(filePath string) ([]byte, *FileMetadata,
   return data, metadata, nil
 }
 
+// OpenFileContent is ReadFileContent for files that should be streamed
+// rather than read into memory. The caller closes the file.
+func (fh *FileHandler) OpenFileContent(filePath string) (*os.File, *FileMetadata, error) {
+  file, err := os.Open(filePath)
+  if err != nil {
+    return nil, nil, err
+  }
+
+  info, err := file.Stat()
+  if err != nil {
+    file.Close()
+    return nil, nil, err
+  }
+
+  metadata := &FileMetadata{
+    OriginalName: filepath.Base(filePath),
+    FileExt:      filepath.Ext(filePath),
+    FileSize:     uint64(info.Size()),
+  }
+
+  return file, metadata, nil
+}
+
 func (fh *FileHandler) SaveFileContent(data []byte, metadata *FileMetadata, outputPath string) error {
+  return os.WriteFile(fh.outputFilePath(metadata, outputPath), data, 0644)
+}
+
+// MoveFileContent is SaveFileContent for contents already written to the
+// file at path, such as a payload decrypted straight to disk. The file is
+// moved into place, or copied and then wiped where it cannot be.
+func (fh *FileHandler) MoveFileContent(path string, metadata *FileMetadata, outputPath string) error {
+  outputPath = fh.outputFilePath(metadata, outputPath)
+  if err := os.Rename(path, outputPath); err == nil {
+    return os.Chmod(outputPath, 0644)
+  }
+
+  src, err := os.Open(path)
+  if err != nil {
+    return err
+  }
+  defer src.Close()
+
+  dst, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+  if err != nil {
+    return err
+  }
+  if _, err := io.Copy(dst, src); err != nil {
+    dst.Close()
+    return err
+  }
+  if err := dst.Close(); err != nil {
+    return err
+  }
+  return WipeFile(path)
+}
+
+// WipeFile overwrites a file with zeros before removing it, so decrypted
+// content does not linger in free disk blocks. Filesystems that copy on
+// write or journal data can still keep the old blocks.
+func WipeFile(path string) error {
+  file, err := os.OpenFile(path, os.O_WRONLY, 0)
+  if err != nil {
+    return err
+  }
+  err = zeroFile(file)
+  file.Close()
+  if err != nil {
+    return err
+  }
+  return os.Remove(path)
+}
+
+// zeroFile overwrites everything written to file with zeros.
+func zeroFile(file *os.File) error {
+  info, err := file.Stat()
+  if err != nil {
+    return err
+  }
+  if _, err := file.Seek(0, io.SeekStart); err != nil {
+    return err
+  }
+  if _, err := io.CopyN(file, zeroReader{}, info.Size()); err != nil {
+    return err
+  }
+  return file.Sync()
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+  clear(p)
+  return len(p), nil
+}
+
+func (fh *FileHandler) outputFilePath(metadata *FileMetadata, outputPath string) string {
   fileInfo, err := os.Stat(outputPath)
   if err == nil && fileInfo.IsDir() {
-    outputPath = filepath.Join(outputPath, metadata.OriginalName)
-  } else if filepath.Ext(outputPath) == "" {
+    return filepath.Join(outputPath, metadata.OriginalName)
+  }
+  if filepath.Ext(outputPath) == "" {
     if metadata.FileExt != "" {
-      outputPath = outputPath + metadata.FileExt
+      return outputPath + metadata.FileExt
     } else if ext := filepath.Ext(metadata.OriginalName); ext != "" {
-      outputPath = outputPath + ext
+      return outputPath + ext
     }
   }
-
-  return os.WriteFile(outputPath, data, 0644)
+  return outputPath
 }
 
 func (fh *FileHandler) SerializeMetadata(metadata *FileMetadata) []byte {
@@ -134,6 +230,94 @@ func (fh *FileHandler) DeserializeMetadata(data []byte) (*FileMetadata, error) {
   }, nil
 }
 
+// PackFile prefixes file data with its serialized metadata, so the name and
+// size can be encrypted together with the contents and are not readable from
+// the image without the key.
+func (fh *FileHandler) PackFile(data []byte, metadata *FileMetadata) []byte {
+  packed := make([]byte, 0, MetadataSize+len(data))
+  packed = append(packed, fh.SerializeMetadata(metadata)...)
+  return append(packed, data...)
+}
+
+func (fh *FileHandler) UnpackFile(packed []byte) ([]byte, *FileMetadata, error) {
+  if len(packed) < MetadataSize {
+    return nil, nil, errors.New("invalid file data: too small")
+  }
+
+  metadata, err := fh.DeserializeMetadata(packed[:MetadataSize])
+  if err != nil {
+    return nil, nil, err
+  }
+  return packed[MetadataSize:], metadata, nil
+}
+
+// FileUnpacker is UnpackFile, and Unpad for a padded payload, as a writer:
+// a decrypted file payload is written to it as it streams in, and only the
+// file contents pass on to dst.
+type FileUnpacker struct {
+  dst      io.Writer
+  padded   bool
+  length   []byte
+  limit    int64
+  metadata []byte
+  written  int64
+}
+
+func (fh *FileHandler) NewUnpacker(dst io.Writer, padded bool) *FileUnpacker {
+  return &FileUnpacker{dst: dst, padded: padded}
+}
+
+func (u *FileUnpacker) Write(p []byte) (int, error) {
+  n := len(p)
+
+  // A padded payload starts with the length of the packed file, and
+  // whatever follows it is fill.
+  if u.padded {
+    if len(u.length) < paddingPrefixSize {
+      take := min(paddingPrefixSize-len(u.length), len(p))
+      u.length = append(u.length, p[:take]...)
+      p = p[take:]
+      if len(u.length) < paddingPrefixSize {
+        return n, nil
+      }
+      u.limit = int64(binary.BigEndian.Uint32(u.length))
+    }
+    if int64(len(p)) > u.limit {
+      p = p[:u.limit]
+    }
+    u.limit -= int64(len(p))
+  }
+
+  if len(u.metadata) < MetadataSize {
+    take := min(MetadataSize-len(u.metadata), len(p))
+    u.metadata = append(u.metadata, p[:take]...)
+    p = p[take:]
+  }
+  if len(p) > 0 {
+    if _, err := u.dst.Write(p); err != nil {
+      return 0, err
+    }
+    u.written += int64(len(p))
+  }
+  return n, nil
+}
+
+// Written is how many bytes of file contents have passed on to dst.
+func (u *FileUnpacker) Written() int64 {
+  return u.written
+}
+
+// Metadata checks that the whole file arrived and returns its metadata.
+func (u *FileUnpacker) Metadata() (*FileMetadata, error) {
+  if u.padded && (len(u.length) < paddingPrefixSize || u.limit > 0) {
+    return nil, errors.New("invalid padded data length")
+  }
+  if len(u.metadata) < MetadataSize {
+    return nil, errors.New("invalid file data: too small")
+  }
+  return NewFileHandler().DeserializeMetadata(u.metadata)
+}
+
 func (fh *FileHandler) IsFileSupported(filePath string) (bool, string) {
   ext := strings.ToLower(filepath.Ext(filePath))
 
@@ -0,0 +1,169 @@
+package steganography
+
+import (
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "strings"
+)
+
+type EmbedMode byte
+
+const (
+  ModeLSB      EmbedMode = 0x00
+  ModeMatrix   EmbedMode = 0x01
+  ModeAdaptive EmbedMode = 0x02
+)
+
+func (m EmbedMode) String() string {
+  switch m {
+  case ModeLSB:
+    return "lsb"
+  case ModeMatrix:
+    return "matrix"
+  case ModeAdaptive:
+    return "adaptive"
+  default:
+    return fmt.Sprintf("unknown(%d)", byte(m))
+  }
+}
+
+func ParseEmbedMode(name string) (EmbedMode, error) {
+  switch strings.ToLower(strings.TrimSpace(name)) {
+  case "", "lsb":
+    return ModeLSB, nil
+  case "matrix", "hamming":
+    return ModeMatrix, nil
+  case "adaptive":
+    return ModeAdaptive, nil
+  default:
+    return 0, fmt.Errorf("unknown embedding mode: %s", name)
+  }
+}
+
+// Header is the plain LSB block at the start of every stego image. Version 1
+// images carry only the length; version 2 adds the embedding mode and its
+// parameter (the Hamming code size for matrix mode, the texture threshold for
+// adaptive mode) plus a flags byte.
+//
+// With FlagCopies set the length is followed by the redundant layout: the
+// number of copies, the seed of the scatter permutation and the number of
+// slots it spans. Slots are numbered column by column, so cropping columns
+// off the right of the image only loses slots; any other crop moves every
+// slot and loses the layout. The header is replicated through the layout,
+// see replicaSlots. FlagDensity adds adaptive mode's density cap and
+// FlagChecksum the body's CRC-32 after that.
+type Header struct {
+  Version byte
+  Mode    EmbedMode
+  Param   byte
+  Flags   byte
+  Length  uint64
+
+  Copies      byte
+  LayoutSeed  []byte
+  LayoutSlots uint64
+
+  Density  byte
+  Checksum uint32
+}
+
+func (h *Header) size() int {
+  if h.Version == legacyFormatVersion {
+    return len(headerPattern) + 1 + 8
+  }
+
+  size := len(headerPattern) + 4 + 8
+  if h.Flags&FlagCopies != 0 {
+    size += 1 + layoutSeedSize + 8
+  }
+  if h.Flags&FlagDensity != 0 {
+    size++
+  }
+  if h.Flags&FlagChecksum != 0 {
+    size += checksumSize
+  }
+  return size
+}
+
+func (h *Header) marshal() []byte {
+  result := make([]byte, 0, h.size())
+  result = append(result, headerPattern...)
+  result = append(result, h.Version, byte(h.Mode), h.Param, h.Flags)
+  result = binary.BigEndian.AppendUint64(result, h.Length)
+
+  if h.Flags&FlagCopies != 0 {
+    result = append(result, h.Copies)
+    result = append(result, h.LayoutSeed...)
+    result = binary.BigEndian.AppendUint64(result, h.LayoutSlots)
+  }
+  if h.Flags&FlagDensity != 0 {
+    result = append(result, h.Density)
+  }
+  if h.Flags&FlagChecksum != 0 {
+    result = binary.BigEndian.AppendUint32(result, h.Checksum)
+  }
+  return result
+}
+
+// maxHeaderSize is the size of the largest header any flags give: only
+// lsb mode has copies and only adaptive mode a density.
+var maxHeaderSize = (&Header{Version: formatVersion, Flags: FlagCopies | FlagChecksum}).size()
+
+func readHeader(c *carrier) (*Header, error) {
+  n := c.slots() / bitsPerByte
+  if n > maxHeaderSize {
+    n = maxHeaderSize
+  }
+  return parseHeader(c.readBytes(0, n))
+}
+
+// parseHeader reads a header from the start of data, which may run past it.
+func parseHeader(data []byte) (*Header, error) {
+  if len(data) < len(headerPattern)+1 || string(data[:len(headerPattern)]) != headerPattern {
+    return nil, ErrNoPayload
+  }
+
+  pos := len(headerPattern)
+  h := &Header{Version: data[pos]}
+  pos++
+
+  switch h.Version {
+  case legacyFormatVersion:
+    h.Mode = ModeLSB
+  case formatVersion:
+    if len(data) < pos+3 {
+      return nil, errors.New("invalid header")
+    }
+    h.Mode = EmbedMode(data[pos])
+    h.Param = data[pos+1]
+    h.Flags = data[pos+2]
+    pos += 3
+  default:
+    return nil, errors.New("unsupported steganography format version")
+  }
+
+  if len(data) < h.size() {
+    return nil, errors.New("invalid header")
+  }
+  h.Length = binary.BigEndian.Uint64(data[pos:])
+  pos += 8
+
+  if h.Flags&FlagCopies != 0 {
+    h.Copies = data[pos]
+    pos++
+    h.LayoutSeed = append([]byte(nil), data[pos:pos+layoutSeedSize]...)
+    pos += layoutSeedSize
+    h.LayoutSlots = binary.BigEndian.Uint64(data[pos:])
+    pos += 8
+  }
+  if h.Flags&FlagDensity != 0 {
+    h.Density = data[pos]
+    pos++
+  }
+  if h.Flags&FlagChecksum != 0 {
+    h.Checksum = binary.BigEndian.Uint32(data[pos:])
+  }
+
+  return h, nil
+}
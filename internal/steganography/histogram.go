@@ -0,0 +1,85 @@
+package steganography
+
+import (
+  crand "crypto/rand"
+  "math/rand/v2"
+)
+
+// histogram counts channel values separately for R, G and B.
+type histogram [3][256]int
+
+func channelHistogram(c *carrier) *histogram {
+  h := &histogram{}
+  for slot := 0; slot < c.slots(); slot++ {
+    h[slot%3][c.value(slot)]++
+  }
+  return h
+}
+
+// histogramDeviation is the share of channel values that sit in the wrong
+// bin compared to the cover, 0 when the histograms are identical.
+func histogramDeviation(cover, stego *histogram, samples int) float64 {
+  if samples == 0 {
+    return 0
+  }
+
+  diff := 0
+  for ch := 0; ch < 3; ch++ {
+    for v := 0; v < 256; v++ {
+      d := stego[ch][v] - cover[ch][v]
+      if d < 0 {
+        d = -d
+      }
+      diff += d
+    }
+  }
+  return float64(diff) / float64(2*samples)
+}
+
+// correctHistogram is an OutGuess-style correction pass. LSB changes only
+// move values between 2k and 2k+1, so for every pair it flips randomly
+// chosen slots outside the payload until the pair's counts match the cover
+// again, or until no unused slots with the surplus value are left.
+func correctHistogram(c *carrier, cover *histogram, used []bool) (int, error) {
+  var seed [32]byte
+  if _, err := crand.Read(seed[:]); err != nil {
+    return 0, err
+  }
+  rng := rand.New(rand.NewChaCha8(seed))
+
+  current := channelHistogram(c)
+  var surplus histogram
+  for ch := 0; ch < 3; ch++ {
+    for v := 0; v < 256; v += 2 {
+      if d := current[ch][v] - cover[ch][v]; d > 0 {
+        surplus[ch][v] = d
+      } else {
+        surplus[ch][v+1] = -d
+      }
+    }
+  }
+
+  var available histogram
+  for slot, inUse := range used {
+    if !inUse {
+      available[slot%3][c.value(slot)]++
+    }
+  }
+
+  changes := 0
+  for slot, inUse := range used {
+    if inUse {
+      continue
+    }
+
+    ch, v := slot%3, c.value(slot)
+    if surplus[ch][v] > 0 && rng.IntN(available[ch][v]) < surplus[ch][v] {
+      c.flip(slot)
+      surplus[ch][v]--
+      changes++
+    }
+    available[ch][v]--
+  }
+
+  return changes, nil
+}
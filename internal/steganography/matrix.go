@@ -0,0 +1,75 @@
+package steganography
+
+// Matrix embedding with binary Hamming codes: a block of n = 2^k-1 slots
+// carries k message bits as the syndrome of its LSBs, so at most one slot
+// per block changes instead of roughly half of the k slots plain LSB would
+// touch. The larger k, the fewer changes per bit but the more slots needed.
+const maxHammingK = 12
+
+func hammingBlockSize(k int) int {
+  return (1 << uint(k)) - 1
+}
+
+func hammingSlots(bits, k int) int {
+  return (bits + k - 1) / k * hammingBlockSize(k)
+}
+
+// hammingK picks the largest code size for which the payload still fits in
+// the available slots, i.e. the most efficient code the capacity allows.
+func hammingK(bits, available int) (int, bool) {
+  for k := maxHammingK; k >= 1; k-- {
+    if hammingSlots(bits, k) <= available {
+      return k, true
+    }
+  }
+  return 0, false
+}
+
+func hammingSyndrome(c *carrier, start, n int) int {
+  s := 0
+  for j := 0; j < n; j++ {
+    if c.bit(start+j) == 1 {
+      s ^= j + 1
+    }
+  }
+  return s
+}
+
+func matrixEmbed(c *carrier, data []byte, start, k int) int {
+  n := hammingBlockSize(k)
+  bits := len(data) * bitsPerByte
+  changes := 0
+
+  for i, slot := 0, start; i < bits; i, slot = i+k, slot+n {
+    msg := 0
+    for j := 0; j < k; j++ {
+      msg <<= 1
+      if i+j < bits {
+        msg |= int(dataBit(data, i+j))
+      }
+    }
+
+    if d := hammingSyndrome(c, slot, n) ^ msg; d != 0 {
+      c.flip(slot + d - 1)
+      changes++
+    }
+  }
+
+  return changes
+}
+
+func matrixExtract(c *carrier, start, k, length int) []byte {
+  n := hammingBlockSize(k)
+  bits := length * bitsPerByte
+  data := make([]byte, length)
+
+  for i, slot := 0, start; i < bits; i, slot = i+k, slot+n {
+    msg := hammingSyndrome(c, slot, n)
+    for j := 0; j < k && i+j < bits; j++ {
+      bit := byte(msg>>uint(k-1-j)) & 1
+      data[(i+j)/bitsPerByte] |= bit << uint(7-(i+j)%bitsPerByte)
+    }
+  }
+
+  return data
+}
@@ -0,0 +1,166 @@
+package steganography
+
+import (
+  "bytes"
+  "crypto/rand"
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "io"
+  "strings"
+)
+
+type PaddingMode byte
+
+const (
+  PaddingNone PaddingMode = iota
+  PaddingBucket
+  PaddingFull
+)
+
+const (
+  FlagPadded byte = 0x01
+
+  paddingPrefixSize = 4
+  minPaddingBucket  = 256
+)
+
+func ParsePaddingMode(name string) (PaddingMode, error) {
+  switch strings.ToLower(strings.TrimSpace(name)) {
+  case "", "none":
+    return PaddingNone, nil
+  case "bucket":
+    return PaddingBucket, nil
+  case "full":
+    return PaddingFull, nil
+  default:
+    return 0, fmt.Errorf("unknown padding mode: %s", name)
+  }
+}
+
+func (p PaddingMode) String() string {
+  switch p {
+  case PaddingBucket:
+    return "bucket"
+  case PaddingFull:
+    return "full"
+  default:
+    return "none"
+  }
+}
+
+// Pad frames data with its length and zero-fills it to size. It is applied
+// to the plaintext before encryption, so the real length ends up inside the
+// ciphertext and the embedded length only reveals the padded size.
+func Pad(data []byte, size int) ([]byte, error) {
+  if size < len(data)+paddingPrefixSize {
+    return nil, errors.New("padding size too small for data")
+  }
+
+  result := make([]byte, size)
+  binary.BigEndian.PutUint32(result, uint32(len(data)))
+  copy(result[paddingPrefixSize:], data)
+  return result, nil
+}
+
+// PadReader is Pad for a plaintext of length bytes read from r.
+func PadReader(r io.Reader, length, size int) (io.Reader, error) {
+  if size < length+paddingPrefixSize {
+    return nil, errors.New("padding size too small for data")
+  }
+
+  prefix := binary.BigEndian.AppendUint32(nil, uint32(length))
+  fill := bytes.NewReader(make([]byte, size-paddingPrefixSize-length))
+  return io.MultiReader(bytes.NewReader(prefix), io.LimitReader(r, int64(length)), fill), nil
+}
+
+func Unpad(data []byte) ([]byte, error) {
+  if len(data) < paddingPrefixSize {
+    return nil, errors.New("invalid padded data")
+  }
+
+  length := binary.BigEndian.Uint32(data)
+  if uint64(length) > uint64(len(data)-paddingPrefixSize) {
+    return nil, errors.New("invalid padded data length")
+  }
+  return data[paddingPrefixSize : paddingPrefixSize+int(length)], nil
+}
+
+func (h *Header) Padded() bool {
+  return h.Flags&FlagPadded != 0
+}
+
+// PaddedSize returns the size plaintext of plainLen bytes should be padded
+// to, given the encryption overhead for a plaintext length, so that the Hide
+// (or HideFile) payload fills a power-of-two bucket or the whole capacity of
+// the chosen mode.
+func (e *Encoder) PaddedSize(plainLen int, overhead func(int) int) (int, error) {
+  if e.options.Padding == PaddingNone {
+    return plainLen, nil
+  }
+
+  framing := 1
+  if e.options.Signer != nil {
+    framing += signatureTrailerSize
+  }
+
+  // The overhead grows with the plaintext, so start from a size that
+  // certainly fits and take up whatever room is left.
+  available := e.capacity() - framing
+  maxSize := available - overhead(available)
+  for maxSize+1+overhead(maxSize+1) <= available {
+    maxSize++
+  }
+  if maxSize < plainLen+paddingPrefixSize {
+    return 0, fmt.Errorf("image too small for a padded payload of %d bytes", plainLen)
+  }
+
+  if e.options.Padding == PaddingFull {
+    return maxSize, nil
+  }
+
+  size := minPaddingBucket
+  for size < plainLen+paddingPrefixSize {
+    size *= 2
+  }
+  if size > maxSize {
+    size = maxSize
+  }
+  return size, nil
+}
+
+// capacity is the largest body, in bytes, the configured mode can embed.
+func (e *Encoder) capacity() int {
+  c := newCarrier(e.image)
+  header := &Header{Version: formatVersion, Flags: FlagChecksum}
+  if e.options.Mode == ModeAdaptive {
+    header.Flags |= FlagDensity
+  }
+  start := header.size() * bitsPerByte
+
+  if e.options.Mode == ModeAdaptive {
+    return adaptiveCapacity(textureMap(c), start, e.maxDensity())
+  }
+  if c.slots() < start {
+    return 0
+  }
+  return (c.slots() - start) / bitsPerByte
+}
+
+// randomizeUnused overwrites the LSBs of every slot outside the header and
+// payload with CSPRNG output, so there is no boundary where embedded noise
+// stops and untouched cover LSBs begin.
+func randomizeUnused(c *carrier, used []bool) (int, error) {
+  noise := make([]byte, (len(used)+bitsPerByte-1)/bitsPerByte)
+  if _, err := rand.Read(noise); err != nil {
+    return 0, err
+  }
+
+  changes := 0
+  for slot, inUse := range used {
+    if !inUse && c.setBit(slot, dataBit(noise, slot)) {
+      changes++
+    }
+  }
+  return changes, nil
+}
@@ -0,0 +1,139 @@
+package steganography
+
+import (
+  "crypto/rand"
+  "errors"
+)
+
+// Options that only shape how a payload is embedded, and are not needed to
+// read it back, are recorded in the header too so that a rekey can repeat
+// them.
+const (
+  FlagRandomized byte = 0x10
+  FlagHistogram  byte = 0x20
+)
+
+func (e *Encoder) optionFlags() byte {
+  var flags byte
+  if e.options.RandomizeUnused {
+    flags |= FlagRandomized
+  }
+  if e.options.PreserveHistogram {
+    flags |= FlagHistogram
+  }
+  if e.options.Mode == ModeAdaptive {
+    flags |= FlagDensity
+  }
+  return flags
+}
+
+// RekeyOptions writes a new payload over the image of the last Extract the
+// way its header says the old one was written: the same mode, number of
+// copies and density cap, padding kept, and random fill and histogram
+// correction if they were used. Whatever of the old payload the new one
+// does not overwrite is scrubbed with random bits.
+func (d *Decoder) RekeyOptions() Options {
+  h := d.header
+  options := Options{
+    Mode:              h.Mode,
+    Copies:            1,
+    RandomizeUnused:   h.Flags&FlagRandomized != 0,
+    PreserveHistogram: h.Flags&FlagHistogram != 0,
+    Scrub:             d.payloadSlots(),
+  }
+  if h.Flags&FlagDensity != 0 {
+    options.MaxDensity = float64(h.Density) / densityScale
+  }
+  if h.Padded() {
+    options.Padding = PaddingFull
+  }
+  if h.Flags&FlagCopies != 0 {
+    options.Copies = int(h.Copies)
+  }
+  return options
+}
+
+// payloadSlots marks the slots the body of the last Extract was read from.
+func (d *Decoder) payloadSlots() []bool {
+  c := newCarrier(d.image)
+  h := d.header
+  start := h.size() * bitsPerByte
+  bits := int(h.Length) * bitsPerByte
+
+  slots := make([]bool, c.slots())
+  mark := func(slot int) {
+    if slot < len(slots) {
+      slots[slot] = true
+    }
+  }
+  switch {
+  case h.Flags&FlagCopies != 0:
+    forEachCopySlot(h, start, bits, func(_, _, slot int) {
+      mark(slot)
+    })
+  case h.Mode == ModeAdaptive:
+    for _, slot := range adaptiveSlots(textureMap(c), h.Param, start, bits) {
+      mark(slot)
+    }
+  default:
+    span := bits
+    if h.Mode == ModeMatrix {
+      span = hammingSlots(bits, int(h.Param))
+    }
+    for slot := start; slot < start+span; slot++ {
+      mark(slot)
+    }
+  }
+  return slots
+}
+
+// scrubSlots refills the slots marked in scrub that are not in use with
+// CSPRNG output.
+func scrubSlots(c *carrier, used, scrub []bool) (int, error) {
+  noise := make([]byte, (len(scrub)+bitsPerByte-1)/bitsPerByte)
+  if _, err := rand.Read(noise); err != nil {
+    return 0, err
+  }
+
+  changes := 0
+  for slot, old := range scrub {
+    if old && slot < len(used) && !used[slot] && c.setBit(slot, dataBit(noise, slot)) {
+      changes++
+    }
+  }
+  return changes, nil
+}
+
+// RekeyPlaintext turns a payload the decoder extracted and the old key
+// decrypted into the plaintext to encrypt under the new one. A file that
+// still carries its metadata in plain text is packed into the current
+// format, and a padded payload is padded again so that, given the new
+// key's overhead, the embedded length of embedded bytes does not change.
+func (d *Decoder) RekeyPlaintext(decrypted []byte, metadata *FileMetadata, embedded int, overhead func(int) int) ([]byte, error) {
+  if d.header == nil {
+    return nil, errors.New("no payload has been extracted")
+  }
+
+  data := decrypted
+  if d.header.Padded() {
+    var err error
+    if data, err = Unpad(decrypted); err != nil {
+      return nil, err
+    }
+  }
+  if metadata != nil {
+    data = d.fileHandler.PackFile(data, metadata)
+  }
+  if !d.header.Padded() {
+    return data, nil
+  }
+
+  size := embedded - overhead(embedded)
+  for size+1+overhead(size+1) <= embedded {
+    size++
+  }
+  if size < len(data)+paddingPrefixSize {
+    return nil, errors.New("the new key's overhead leaves no room for the padded payload")
+  }
+  return Pad(data, size)
+}
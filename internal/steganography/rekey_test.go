@@ -0,0 +1,96 @@
+package steganography
+
+import (
+  "bytes"
+  "path/filepath"
+  "testing"
+)
+
+func hideTo(t *testing.T, cover, output string, options Options, message []byte) {
+  t.Helper()
+  encoder, err := NewEncoderWithOptions(cover, options)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if err := encoder.Hide(message); err != nil {
+    t.Fatal(err)
+  }
+  if err := encoder.SaveOutput(output); err != nil {
+    t.Fatal(err)
+  }
+}
+
+func extractFrom(t *testing.T, path string) (*Decoder, []byte) {
+  t.Helper()
+  decoder, err := NewDecoder(path)
+  if err != nil {
+    t.Fatal(err)
+  }
+  data, _, _, err := decoder.Extract()
+  if err != nil {
+    t.Fatalf("Extract: %v", err)
+  }
+  return decoder, data
+}
+
+// A rekey writes the new payload the way the old one was written, down to
+// the options the payload can be read without.
+func TestRekeyOptionsKeepEmbedding(t *testing.T) {
+  dir := t.TempDir()
+  cover := filepath.Join(dir, "cover.png")
+  writeNoiseImage(t, cover, 160, 90)
+
+  tests := []Options{
+    {Mode: ModeLSB},
+    {Mode: ModeLSB, RandomizeUnused: true, Copies: 3},
+    {Mode: ModeMatrix, PreserveHistogram: true},
+    {Mode: ModeAdaptive, MaxDensity: 0.35, PreserveHistogram: true},
+  }
+  for _, options := range tests {
+    t.Run(options.Mode.String(), func(t *testing.T) {
+      stego := filepath.Join(dir, "stego.png")
+      hideTo(t, cover, stego, options, []byte("embedding options"))
+
+      decoder, _ := extractFrom(t, stego)
+      rekey := decoder.RekeyOptions()
+      if rekey.Mode != options.Mode || rekey.RandomizeUnused != options.RandomizeUnused ||
+        rekey.PreserveHistogram != options.PreserveHistogram {
+        t.Errorf("rekey options = %+v, want those of %+v", rekey, options)
+      }
+      if options.Copies > 1 && rekey.Copies != options.Copies {
+        t.Errorf("copies = %d, want %d", rekey.Copies, options.Copies)
+      }
+      if options.Mode == ModeAdaptive && rekey.MaxDensity != options.MaxDensity {
+        t.Errorf("max density = %v, want %v", rekey.MaxDensity, options.MaxDensity)
+      }
+    })
+  }
+}
+
+// Without random fill, a shorter new payload would leave the end of the old
+// one readable past its own end.
+func TestRekeyScrubsOldPayload(t *testing.T) {
+  dir := t.TempDir()
+  cover := filepath.Join(dir, "cover.png")
+  writeNoiseImage(t, cover, 160, 90)
+
+  old := bytes.Repeat([]byte("old payload "), 20)
+  stego := filepath.Join(dir, "stego.png")
+  hideTo(t, cover, stego, Options{}, old)
+
+  decoder, _ := extractFrom(t, stego)
+  rekeyed := filepath.Join(dir, "rekeyed.png")
+  message := []byte("new")
+  hideTo(t, stego, rekeyed, decoder.RekeyOptions(), message)
+
+  decoder, data := extractFrom(t, rekeyed)
+  if !bytes.Equal(data, message) {
+    t.Fatal("rekeyed image does not hold the new payload")
+  }
+  c := newCarrier(decoder.image)
+  start := decoder.Header().size() * bitsPerByte
+  tail := c.readBytes(start+len(data)*bitsPerByte, len(old)/2)
+  if bytes.Contains(tail, []byte("old payload")) {
+    t.Error("the old payload survives past the new one")
+  }
+}
@@ -0,0 +1,56 @@
+package steganography
+
+import (
+  "crypto/ed25519"
+  "errors"
+
+  "github.com/pranaykumar2/steg-go/internal/crypto"
+)
+
+// A signed body ends with the signer's key ID and an Ed25519 signature over
+// everything before it, so the signature covers the file metadata block as
+// well as the encrypted payload.
+const (
+  FlagSigned byte = 0x04
+
+  signatureTrailerSize = crypto.SigningKeyIDSize + ed25519.SignatureSize
+)
+
+type Signature struct {
+  KeyID   []byte
+  Value   []byte
+  Message []byte
+}
+
+func (h *Header) Signed() bool {
+  return h.Flags&FlagSigned != 0
+}
+
+func signBody(body []byte, key ed25519.PrivateKey) []byte {
+  signature := crypto.Sign(key, body)
+
+  signed := make([]byte, 0, len(body)+signatureTrailerSize)
+  signed = append(signed, body...)
+  signed = append(signed, crypto.SigningKeyID(key.Public().(ed25519.PublicKey))...)
+  return append(signed, signature...)
+}
+
+func splitSignature(data []byte) ([]byte, *Signature, error) {
+  if len(data) <= signatureTrailerSize {
+    return nil, nil, errors.New("invalid signed data: too small")
+  }
+
+  body := data[:len(data)-signatureTrailerSize]
+  trailer := data[len(body):]
+  return body, &Signature{
+    KeyID:   trailer[:crypto.SigningKeyIDSize],
+    Value:   trailer[crypto.SigningKeyIDSize:],
+    Message: body,
+  }, nil
+}
+
+// Signature returns the sender signature found by the last Extract or
+// ExtractCopy, or nil if the image is unsigned.
+func (d *Decoder) Signature() *Signature {
+  return d.signature
+}
@@ -0,0 +1,21 @@
+package steganography
+
+// A thread body holds a list of separately encrypted messages, see the
+// thread package. New messages are appended to the body and the whole of it
+// embedded again.
+const ThreadModeEnabled byte = 0x04
+
+// HideThread embeds a thread body.
+func (e *Encoder) HideThread(data []byte) error {
+  body := make([]byte, 0, 1+len(data))
+  body = append(body, ThreadModeEnabled)
+  body = append(body, data...)
+
+  return e.embed(body)
+}
+
+// IsThread reports whether the body returned by the last Extract is a
+// thread.
+func (d *Decoder) IsThread() bool {
+  return d.mode == ThreadModeEnabled
+}
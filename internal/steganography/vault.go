@@ -0,0 +1,20 @@
+package steganography
+
+// A vault body holds an encrypted store of secrets rather than a message or
+// a file. It has a mode byte of its own so that extract can point at the
+// vault commands instead of showing the store as text.
+const VaultModeEnabled byte = 0x03
+
+// HideVault embeds an encrypted vault, see the vault package.
+func (e *Encoder) HideVault(data []byte) error {
+  body := make([]byte, 0, 1+len(data))
+  body = append(body, VaultModeEnabled)
+  body = append(body, data...)
+
+  return e.embed(body)
+}
+
+// IsVault reports whether the body returned by the last Extract is a vault.
+func (d *Decoder) IsVault() bool {
+  return d.mode == VaultModeEnabled
+}
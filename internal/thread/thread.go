@@ -0,0 +1,232 @@
+package thread
+
+import (
+  "crypto/ed25519"
+  "encoding/binary"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "os"
+  "time"
+
+  "github.com/pranaykumar2/steg-go/internal/crypto"
+  "github.com/pranaykumar2/steg-go/internal/steganography"
+)
+
+// A thread keeps a running conversation in a stego image. Its body is a list
+// of records, each a payload encrypted on its own:
+//
+//   length (4) | payload, repeated
+//
+// so a message is appended without opening, or even being able to open,
+// the ones before it. Each record seals its sequence number, the time it
+// was posted and, when signed, its author's signing key ID with the text.
+const (
+  formatVersion = 1
+  lengthSize    = 4
+)
+
+var ErrNotThread = errors.New("the image holds hidden content, but not a thread")
+
+type Message struct {
+  Version   int       `json:"version"`
+  Seq       int       `json:"seq"`
+  Created   time.Time `json:"created"`
+  Author    []byte    `json:"author,omitempty"`
+  Signature []byte    `json:"signature,omitempty"`
+  Text      string    `json:"text"`
+}
+
+// SignedData is what the author's signature covers.
+func (m *Message) SignedData() []byte {
+  data := binary.BigEndian.AppendUint32(nil, uint32(m.Seq))
+  data = binary.BigEndian.AppendUint64(data, uint64(m.Created.Unix()))
+  return append(data, m.Text...)
+}
+
+type Thread struct {
+  Records [][]byte
+
+  decoder *steganography.Decoder
+  options steganography.Options
+}
+
+// New is an empty thread, to be embedded with options. Padding would leave
+// no room for the next message, so it is not used.
+func New(options steganography.Options) *Thread {
+  options.Padding = steganography.PaddingNone
+  options.Signer = nil
+  return &Thread{options: options}
+}
+
+// Parse reads the thread the decoder extracted as data. It is embedded
+// again in the layout the image already has, except that a thread with
+// redundant copies gets as many as still fit, since it only grows.
+func Parse(decoder *steganography.Decoder, data []byte) (*Thread, error) {
+  if !decoder.IsThread() {
+    return nil, ErrNotThread
+  }
+
+  records, err := splitRecords(data)
+  if err != nil {
+    return nil, decoder.PayloadError(err)
+  }
+  if len(records) == 0 {
+    return nil, errors.New("malformed thread: no messages")
+  }
+  options := decoder.RekeyOptions()
+  options.Padding = steganography.PaddingNone
+  if options.Copies > 1 {
+    options.Copies = steganography.AutoCopies
+  }
+  return &Thread{Records: records, decoder: decoder, options: options}, nil
+}
+
+func splitRecords(data []byte) ([][]byte, error) {
+  var records [][]byte
+  for len(data) > 0 {
+    if len(data) < lengthSize {
+      return nil, errors.New("malformed thread: truncated record length")
+    }
+    length := int(binary.BigEndian.Uint32(data))
+    data = data[lengthSize:]
+    if length == 0 || length > len(data) {
+      return nil, errors.New("malformed thread: record length out of range")
+    }
+    records = append(records, data[:length])
+    data = data[length:]
+  }
+  return records, nil
+}
+
+// Messages decrypts every record encryptor opens. Records sealed with other
+// credentials are nil; only when none opens is the wrong key an error. A
+// message whose Seq is not its position was moved, or one before it
+// removed.
+func (t *Thread) Messages(encryptor *crypto.Encryptor) ([]*Message, error) {
+  messages := make([]*Message, len(t.Records))
+  var keyErr error
+  for i, record := range t.Records {
+    message, err := t.open(encryptor, i, record)
+    if errors.Is(err, crypto.ErrWrongKey) {
+      if keyErr == nil {
+        keyErr = err
+      }
+      continue
+    }
+    if err != nil {
+      return nil, fmt.Errorf("failed to open message %d: %w", i+1, err)
+    }
+    messages[i] = message
+  }
+
+  for _, message := range messages {
+    if message != nil {
+      return messages, nil
+    }
+  }
+  if keyErr == nil {
+    return messages, nil
+  }
+  return nil, fmt.Errorf("failed to decrypt thread: %w", keyErr)
+}
+
+// open decrypts record i, falling back to the same record in each
+// redundant copy when the majority vote does not decrypt.
+func (t *Thread) open(encryptor *crypto.Encryptor, i int, record []byte) (*Message, error) {
+  plaintext, err := encryptor.Decrypt(record)
+  for c := 0; err != nil && !errors.Is(err, crypto.ErrWrongKey) && t.decoder != nil && c < t.decoder.CopyCount(); c++ {
+    copyData, _, _, copyErr := t.decoder.ExtractCopy(c)
+    if copyErr != nil {
+      continue
+    }
+    if records, splitErr := splitRecords(copyData); splitErr == nil && i < len(records) {
+      if copyPlaintext, decryptErr := encryptor.Decrypt(records[i]); decryptErr == nil {
+        plaintext, err = copyPlaintext, nil
+      }
+    }
+  }
+  if err != nil {
+    if t.decoder != nil {
+      err = t.decoder.PayloadError(err)
+    }
+    return nil, err
+  }
+  defer crypto.Wipe(plaintext)
+
+  message := &Message{}
+  if err := json.Unmarshal(plaintext, message); err != nil {
+    return nil, fmt.Errorf("malformed message: %v", err)
+  }
+  if message.Version != formatVersion {
+    return nil, fmt.Errorf("unsupported message version %d", message.Version)
+  }
+  return message, nil
+}
+
+// Append seals text as the next message, signed by signer if it is not nil.
+func (t *Thread) Append(encryptor *crypto.Encryptor, text string, signer ed25519.PrivateKey) (*Message, error) {
+  if text == "" {
+    return nil, errors.New("message cannot be empty")
+  }
+
+  message := &Message{
+    Version: formatVersion,
+    Seq:     len(t.Records) + 1,
+    Created: time.Now().UTC().Truncate(time.Second),
+    Text:    text,
+  }
+  if signer != nil {
+    message.Author = crypto.SigningKeyID(signer.Public().(ed25519.PublicKey))
+    message.Signature = crypto.Sign(signer, message.SignedData())
+  }
+
+  plaintext, err := json.Marshal(message)
+  if err != nil {
+    return nil, err
+  }
+  defer crypto.Wipe(plaintext)
+
+  record, err := encryptor.Encrypt(plaintext)
+  if err != nil {
+    return nil, fmt.Errorf("failed to encrypt message: %v", err)
+  }
+  t.Records = append(t.Records, record)
+  return message, nil
+}
+
+func (t *Thread) body() []byte {
+  size := 0
+  for _, record := range t.Records {
+    size += lengthSize + len(record)
+  }
+  body := make([]byte, 0, size)
+  for _, record := range t.Records {
+    body = binary.BigEndian.AppendUint32(body, uint32(len(record)))
+    body = append(body, record...)
+  }
+  return body
+}
+
+// Save embeds the thread in the image at imagePath, writing the result to
+// outputPath, which may be imagePath itself.
+func (t *Thread) Save(imagePath, outputPath string) (*steganography.Encoder, error) {
+  encoder, err := steganography.NewEncoderWithOptions(imagePath, t.options)
+  if err != nil {
+    return nil, fmt.Errorf("failed to initialize encoder: %v", err)
+  }
+  if err := encoder.HideThread(t.body()); err != nil {
+    return nil, fmt.Errorf("no room for the thread in this image: %v", err)
+  }
+
+  temp := outputPath + ".tmp"
+  if err := encoder.SaveOutput(temp); err != nil {
+    os.Remove(temp)
+    return nil, fmt.Errorf("failed to save output image: %v", err)
+  }
+  if err := os.Rename(temp, outputPath); err != nil {
+    os.Remove(temp)
+    return nil, fmt.Errorf("failed to save output image: %v", err)
+  }
+  return encoder, nil
+}
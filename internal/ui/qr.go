@@ -0,0 +1,96 @@
+package ui
+
+import (
+  "errors"
+  "fmt"
+  "os"
+
+  "github.com/fatih/color"
+  "github.com/skip2/go-qrcode"
+)
+
+const qrPNGSize = 512
+
+// PrintQRCode draws content as a QR code, two modules per character cell
+// using Unicode half blocks. With colour the dark and light modules are
+// painted explicitly, so it scans on light and dark terminals alike;
+// without, light modules are drawn as blocks for a dark background.
+func (u *UI) PrintQRCode(title, content string) error {
+  code, err := qrcode.New(content, qrcode.Medium)
+  if err != nil {
+    return err
+  }
+  bitmap := code.Bitmap()
+
+  fmt.Println()
+  color.New(color.FgHiYellow).Printf("  %s\n\n", title)
+
+  for y := 0; y < len(bitmap); y += 2 {
+    fmt.Print("  ")
+    for x := range bitmap[y] {
+      top := bitmap[y][x]
+      bottom := y+1 < len(bitmap) && bitmap[y+1][x]
+      if color.NoColor {
+        fmt.Print(qrHalfBlock(!top, !bottom))
+        continue
+      }
+      color.New(qrForeground(top), qrBackground(bottom)).Print("▀")
+    }
+    fmt.Println()
+  }
+  fmt.Println()
+  return nil
+}
+
+func qrHalfBlock(top, bottom bool) string {
+  switch {
+  case top && bottom:
+    return "█"
+  case top:
+    return "▀"
+  case bottom:
+    return "▄"
+  }
+  return " "
+}
+
+func qrForeground(dark bool) color.Attribute {
+  if dark {
+    return color.FgBlack
+  }
+  return color.FgHiWhite
+}
+
+func qrBackground(dark bool) color.Attribute {
+  if dark {
+    return color.BgBlack
+  }
+  return color.BgHiWhite
+}
+
+// WriteQRCodePNG saves content as a QR code image. The code holds a key,
+// so the file is readable only by its owner and an existing file is never
+// overwritten.
+func (u *UI) WriteQRCodePNG(content, path string) error {
+  code, err := qrcode.New(content, qrcode.Medium)
+  if err != nil {
+    return err
+  }
+  image, err := code.PNG(qrPNGSize)
+  if err != nil {
+    return err
+  }
+
+  file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+  if errors.Is(err, os.ErrExist) {
+    return fmt.Errorf("refusing to overwrite existing file: %s", path)
+  }
+  if err != nil {
+    return err
+  }
+  if _, err := file.Write(image); err != nil {
+    file.Close()
+    return err
+  }
+  return file.Close()
+}
@@ -2,10 +2,12 @@ package ui
 
 import (
   "bufio"
+  "bytes"
   "fmt"
   "os"
   "strings"
   "time"
+  "golang.org/x/term"
   "github.com/pranaykumar2/steg-go/pkg/exiftools"
   "github.com/fatih/color"
   "github.com/briandowns/spinner"
@@ -206,6 +208,27 @@ func (u *UI) PromptInput(prompt string) string {
   return strings.TrimSpace(input)
 }
 
+// PromptPassword reads a line without echoing it. When stdin is not a
+// terminal it falls back to a plain read so input can still be piped in.
+func (u *UI) PromptPassword(prompt string) string {
+  return string(u.PromptSecret(prompt))
+}
+
+// PromptSecret is PromptPassword returning bytes, which the caller can wipe
+// once done with them, unlike a string.
+func (u *UI) PromptSecret(prompt string) []byte {
+  color.New(color.FgCyan, color.Bold).Printf("🔑 %s: ", prompt)
+  fd := int(os.Stdin.Fd())
+  if !term.IsTerminal(fd) {
+    input, _ := u.reader.ReadBytes('\n')
+    return bytes.TrimRight(input, "\r\n")
+  }
+
+  input, _ := term.ReadPassword(fd)
+  fmt.Println()
+  return input
+}
+
 func (u *UI) PromptConfirmation(prompt string) bool {
   color.New(color.FgMagenta, color.Bold).Printf("❓ %s (y/n): ", prompt)
   input, _ := u.reader.ReadString('\n')
@@ -272,12 +295,101 @@ func (u *UI) PrintDataDetails(details map[string]string) {
 }
 
 func (u *UI) PrintKeyBox(key string) {
+  u.printKeyBox("ENCRYPTION KEY", key)
+  color.New(color.FgHiRed).Println("    IMPORTANT: Save this key to extract your data later!")
   fmt.Println()
-  keyLines := splitStringByLength(key, 48)
+}
+
+func (u *UI) PrintPublicKeyBox(key string) {
+  u.printKeyBox("PUBLIC KEY", key)
+  color.New(color.FgHiGreen).Println("    Share this key with anyone who should hide content for you.")
+  fmt.Println()
+}
+
+func (u *UI) PrintSigningKeyBox(key string) {
+  u.printKeyBox("SIGNING PUBLIC KEY", key)
+  color.New(color.FgHiGreen).Println("    Share this key so others can verify images you sign.")
+  fmt.Println()
+}
 
-  color.New(color.FgHiYellow).Println("  ┌─ ENCRYPTION KEY ─────────────────────────────┐")
+func (u *UI) PrintPrivateKeyBox(key string) {
+  u.printKeyBox("PRIVATE KEY", key)
+  color.New(color.FgHiRed).Println("    Anyone holding this key can extract content hidden for you.")
+  fmt.Println()
+}
+
+// PrintSecretBox shows a secret taken out of a vault. Its lines are slices
+// of secret itself, so wiping secret afterwards leaves no copy behind.
+func (u *UI) PrintSecretBox(secret []byte) {
+  fmt.Println()
+  color.New(color.FgHiYellow).Printf("  ┌─ SECRET %s┐\n", strings.Repeat("─", 43-len("SECRET")))
+  for _, line := range splitBytesByLength(secret, 48) {
+    u.printBoxLine(line)
+  }
+  color.New(color.FgHiYellow).Println("  └─────────────────────────────────────────────┘")
+  fmt.Println()
+}
+
+// PrintKeyEncodings shows a key again as Base58Check and as numbered
+// recovery words, which are easier to read out or write down than hex.
+func (u *UI) PrintKeyEncodings(base58 string, words []string) {
+  u.printKeyBox("BASE58 KEY", base58)
+  u.printBox("RECOVERY WORDS", wordLines(words))
+  fmt.Println()
+}
+
+// PrintKeyShare shows one share of a split key as hex and as words.
+func (u *UI) PrintKeyShare(title, share string, words []string) {
+  u.printKeyBox(title, share)
+  u.printBox(title+" WORDS", wordLines(words))
+  fmt.Println()
+}
 
-  for _, line := range keyLines {
+// PrintCurve draws values between 0 and 1 as rows of bars, each row
+// labelled with how far into the curve it starts.
+func (u *UI) PrintCurve(title string, values []float64) {
+  const perRow = 40
+  bars := []rune(" ▁▂▃▄▅▆▇█")
+
+  fmt.Println()
+  color.New(color.FgHiMagenta).Printf("  %s:\n", title)
+  for i := 0; i < len(values); i += perRow {
+    row := ""
+    for j := i; j < i+perRow && j < len(values); j++ {
+      level := int(values[j]*float64(len(bars)-1) + 0.5)
+      if level < 0 {
+        level = 0
+      } else if level >= len(bars) {
+        level = len(bars) - 1
+      }
+      row += string(bars[level])
+    }
+    color.New(color.FgCyan).Printf("  %3d%% ", i*100/len(values))
+    color.New(color.FgHiWhite, color.BgBlack).Printf("%s\n", row)
+  }
+}
+
+func wordLines(words []string) []string {
+  var lines []string
+  for i := 0; i < len(words); i += 3 {
+    line := ""
+    for j := i; j < i+3 && j < len(words); j++ {
+      line += fmt.Sprintf("%2d. %-9s", j+1, words[j])
+    }
+    lines = append(lines, strings.TrimRight(line, " "))
+  }
+  return lines
+}
+
+func (u *UI) printKeyBox(title, key string) {
+  u.printBox(title, splitStringByLength(key, 48))
+}
+
+func (u *UI) printBox(title string, lines []string) {
+  fmt.Println()
+  color.New(color.FgHiYellow).Printf("  ┌─ %s %s┐\n", title, strings.Repeat("─", 43-len(title)))
+
+  for _, line := range lines {
     color.New(color.FgHiYellow).Print("  │ ")
     color.New(color.FgHiWhite, color.BgBlack).Printf(" %s ", line)
 
@@ -290,8 +402,29 @@ func (u *UI) PrintKeyBox(key string) {
   }
 
   color.New(color.FgHiYellow).Println("  └─────────────────────────────────────────────┘")
-  color.New(color.FgHiRed).Println("    IMPORTANT: Save this key to extract your data later!")
-  fmt.Println()
+}
+
+// printBoxLine is a line of printBox held in bytes.
+func (u *UI) printBoxLine(line []byte) {
+  color.New(color.FgHiYellow).Print("  │ ")
+  color.New(color.FgHiWhite, color.BgBlack).Printf(" %s ", line)
+
+  padding := 47 - len(line)
+  if padding > 0 {
+    fmt.Print(strings.Repeat(" ", padding))
+  }
+
+  color.New(color.FgHiYellow).Println(" │")
+}
+
+// splitBytesByLength is splitStringByLength for bytes, returning slices of
+// input rather than copies.
+func splitBytesByLength(input []byte, length int) [][]byte {
+  var result [][]byte
+  for i := 0; i < len(input); i += length {
+    result = append(result, input[i:min(i+length, len(input))])
+  }
+  return result
 }
 
 func splitStringByLength(input string, length int) []string {
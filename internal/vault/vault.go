@@ -0,0 +1,222 @@
+package vault
+
+import (
+  "encoding/json"
+  "errors"
+  "fmt"
+  "os"
+  "time"
+
+  "github.com/pranaykumar2/steg-go/internal/crypto"
+  "github.com/pranaykumar2/steg-go/internal/steganography"
+)
+
+// A vault keeps credentials in a stego image: a JSON list of entries,
+// encrypted like any other payload and embedded as a vault body. Every
+// change rewrites the whole vault over the image it was read from, in the
+// layout that image already has. The header only records that a payload
+// was padded, so the vault keeps the padding mode itself. Secrets are bytes,
+// base64 in the JSON, so they can be wiped once used.
+const formatVersion = 1
+
+var ErrNotVault = errors.New("the image holds hidden content, but not a vault")
+
+type Entry struct {
+  Title    string    `json:"title"`
+  Username string    `json:"username,omitempty"`
+  Secret   []byte    `json:"secret"`
+  Notes    string    `json:"notes,omitempty"`
+  Created  time.Time `json:"created"`
+}
+
+type Vault struct {
+  Version int      `json:"version"`
+  Padding string   `json:"padding,omitempty"`
+  Entries []*Entry `json:"entries"`
+
+  options steganography.Options
+  cipher  crypto.Cipher
+  kdf     *crypto.KDFParams
+  info    *crypto.PayloadInfo
+}
+
+// New is an empty vault, to be embedded with options.
+func New(options steganography.Options) *Vault {
+  return &Vault{Version: formatVersion, Entries: []*Entry{}, options: options}
+}
+
+// Open decrypts the vault the decoder extracted as data, trying each
+// redundant copy on its own when the majority vote does not decrypt.
+func Open(decoder *steganography.Decoder, data []byte, encryptor *crypto.Encryptor) (*Vault, error) {
+  if !decoder.IsVault() {
+    return nil, ErrNotVault
+  }
+
+  plaintext, err := encryptor.Decrypt(data)
+  for i := 0; err != nil && i < decoder.CopyCount(); i++ {
+    copyData, _, _, copyErr := decoder.ExtractCopy(i)
+    if copyErr != nil {
+      continue
+    }
+    if copyPlaintext, decryptErr := encryptor.Decrypt(copyData); decryptErr == nil {
+      data, plaintext, err = copyData, copyPlaintext, nil
+    }
+  }
+  if err != nil {
+    return nil, fmt.Errorf("failed to decrypt vault: %w", decoder.PayloadError(err))
+  }
+  defer crypto.Wipe(plaintext)
+
+  payloadCipher, err := crypto.PayloadCipher(data)
+  if err != nil {
+    return nil, err
+  }
+  kdf, sealedWithKDF, err := crypto.PayloadKDFParams(data)
+  if err != nil {
+    return nil, err
+  }
+
+  contents := plaintext
+  if decoder.Header().Padded() {
+    if contents, err = steganography.Unpad(plaintext); err != nil {
+      return nil, err
+    }
+  }
+
+  v := &Vault{
+    options: decoder.RekeyOptions(),
+    cipher:  payloadCipher,
+    info:    encryptor.Info(),
+  }
+  if sealedWithKDF {
+    v.kdf = &kdf
+  }
+  if err := json.Unmarshal(contents, v); err != nil {
+    return nil, fmt.Errorf("malformed vault: %v", err)
+  }
+  if v.Version != formatVersion {
+    return nil, fmt.Errorf("unsupported vault version %d", v.Version)
+  }
+  if padding, err := steganography.ParsePaddingMode(v.Padding); err == nil && padding != steganography.PaddingNone {
+    v.options.Padding = padding
+  }
+  return v, nil
+}
+
+// Wipe zeroes the secrets of every entry. The vault cannot be saved after.
+func (v *Vault) Wipe() {
+  for _, entry := range v.Entries {
+    crypto.Wipe(entry.Secret)
+  }
+}
+
+func (v *Vault) Find(title string) *Entry {
+  for _, entry := range v.Entries {
+    if entry.Title == title {
+      return entry
+    }
+  }
+  return nil
+}
+
+// Add stores a new entry. Titles are unique, since entries are looked up by
+// title.
+func (v *Vault) Add(entry *Entry) error {
+  if entry.Title == "" {
+    return errors.New("entry title cannot be empty")
+  }
+  if len(entry.Secret) == 0 {
+    return errors.New("entry secret cannot be empty")
+  }
+  if v.Find(entry.Title) != nil {
+    return fmt.Errorf("an entry titled %q already exists", entry.Title)
+  }
+
+  entry.Created = time.Now().UTC()
+  v.Entries = append(v.Entries, entry)
+  return nil
+}
+
+func (v *Vault) Remove(title string) error {
+  for i, entry := range v.Entries {
+    if entry.Title == title {
+      v.Entries = append(v.Entries[:i], v.Entries[i+1:]...)
+      return nil
+    }
+  }
+  return fmt.Errorf("no entry titled %q", title)
+}
+
+// Save encrypts the vault and embeds it in the image at imagePath, writing
+// the result to outputPath, which may be imagePath itself. A vault that was
+// opened keeps its cipher, passphrase cost and payload info.
+//
+// The encryptor has to be able to seal the vault again on the next change,
+// which rules out recipients: their private keys only open it.
+func (v *Vault) Save(encryptor *crypto.Encryptor, imagePath, outputPath string) (*steganography.Encoder, error) {
+  if encryptor.UsesRecipients() || (encryptor.GetKey() == nil && !encryptor.UsesPassphrase() && !encryptor.UsesMaster()) {
+    return nil, errors.New("a vault can only be sealed with a key, a passphrase or a team master key")
+  }
+  if v.cipher != nil {
+    encryptor.SetCipher(v.cipher)
+  }
+  if v.kdf != nil && encryptor.UsesPassphrase() {
+    if err := encryptor.SetKDFParams(*v.kdf); err != nil {
+      return nil, err
+    }
+  }
+  if v.info != nil {
+    if err := encryptor.SetInfo(v.info); err != nil {
+      return nil, err
+    }
+  }
+
+  v.Padding = ""
+  if v.options.Padding != steganography.PaddingNone {
+    v.Padding = v.options.Padding.String()
+  }
+  plaintext, err := json.Marshal(v)
+  if err != nil {
+    return nil, err
+  }
+  defer crypto.Wipe(plaintext)
+
+  encoder, err := steganography.NewEncoderWithOptions(imagePath, v.options)
+  if err != nil {
+    return nil, fmt.Errorf("failed to initialize encoder: %v", err)
+  }
+
+  size, err := encoder.PaddedSize(len(plaintext), encryptor.Overhead)
+  if err != nil {
+    return nil, err
+  }
+  if size != len(plaintext) {
+    padded, err := steganography.Pad(plaintext, size)
+    if err != nil {
+      return nil, err
+    }
+    defer crypto.Wipe(padded)
+    plaintext = padded
+  }
+
+  encrypted, err := encryptor.Encrypt(plaintext)
+  if err != nil {
+    return nil, fmt.Errorf("failed to encrypt vault: %v", err)
+  }
+  if err := encoder.HideVault(encrypted); err != nil {
+    return nil, fmt.Errorf("failed to embed vault: %v", err)
+  }
+
+  // The vault usually replaces its only copy, so never leave it half
+  // written.
+  temp := outputPath + ".tmp"
+  if err := encoder.SaveOutput(temp); err != nil {
+    os.Remove(temp)
+    return nil, fmt.Errorf("failed to save output image: %v", err)
+  }
+  if err := os.Rename(temp, outputPath); err != nil {
+    os.Remove(temp)
+    return nil, fmt.Errorf("failed to save output image: %v", err)
+  }
+  return encoder, nil
+}
@@ -0,0 +1,87 @@
+package vault
+
+import (
+  "image"
+  "image/png"
+  "math/rand"
+  "os"
+  "path/filepath"
+  "testing"
+
+  "github.com/pranaykumar2/steg-go/internal/crypto"
+  "github.com/pranaykumar2/steg-go/internal/steganography"
+)
+
+func writeCover(t *testing.T, path string) {
+  t.Helper()
+  img := image.NewRGBA(image.Rect(0, 0, 200, 150))
+  rand.New(rand.NewSource(1)).Read(img.Pix)
+  for i := 3; i < len(img.Pix); i += 4 {
+    img.Pix[i] = 0xff
+  }
+  file, err := os.Create(path)
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer file.Close()
+  if err := png.Encode(file, img); err != nil {
+    t.Fatal(err)
+  }
+}
+
+func extract(t *testing.T, path string) (*steganography.Decoder, []byte) {
+  t.Helper()
+  decoder, err := steganography.NewDecoder(path)
+  if err != nil {
+    t.Fatal(err)
+  }
+  data, _, _, err := decoder.Extract()
+  if err != nil {
+    t.Fatal(err)
+  }
+  return decoder, data
+}
+
+// A passphrase vault is opened with the default cost, as the CLI and API
+// do, but has to be sealed again at the cost it was created with.
+func TestSaveKeepsPassphraseCost(t *testing.T) {
+  dir := t.TempDir()
+  cover := filepath.Join(dir, "cover.png")
+  writeCover(t, cover)
+  path := filepath.Join(dir, "vault.png")
+
+  passphrase := []byte("correct horse battery staple")
+  strong := crypto.KDFParams{Time: 4, Memory: 32 * 1024, Threads: 2}
+  encryptor, err := crypto.NewEncryptorWithPassphrase(passphrase, strong)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if _, err := New(steganography.Options{}).Save(encryptor, cover, path); err != nil {
+    t.Fatal(err)
+  }
+
+  opener, err := crypto.NewEncryptorWithPassphrase(passphrase, crypto.KDFParams{Time: 1, Memory: 64, Threads: 1})
+  if err != nil {
+    t.Fatal(err)
+  }
+  decoder, data := extract(t, path)
+  v, err := Open(decoder, data, opener)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if err := v.Add(&Entry{Title: "mail", Secret: []byte("hunter2")}); err != nil {
+    t.Fatal(err)
+  }
+  if _, err := v.Save(opener, path, path); err != nil {
+    t.Fatal(err)
+  }
+
+  _, data = extract(t, path)
+  params, ok, err := crypto.PayloadKDFParams(data)
+  if err != nil || !ok {
+    t.Fatalf("no KDF record in the saved vault (%v)", err)
+  }
+  if params != strong {
+    t.Errorf("vault sealed again with %+v, want %+v", params, strong)
+  }
+}
@@ -0,0 +1,236 @@
+package steganalysis
+
+import (
+  "errors"
+  "fmt"
+  "image"
+  "math"
+
+  "github.com/pranaykumar2/steg-go/pkg/imageprocessing"
+)
+
+// The chi-square attack of Westfeld and Pfitzmann: overwriting LSBs with
+// message bits evens out the counts of each pair of values 2k and 2k+1, so
+// a histogram whose pairs agree better than chance says LSB data. Each
+// window of channel values yields the probability that its pair counts
+// come from embedding; a curve of windows along the scan order shows where
+// in the image the data lies.
+const (
+  DefaultWindows = 100
+
+  // Pairs expected to hold fewer values than this are left out, as the
+  // chi-square approximation does not hold for them.
+  minExpected = 5
+)
+
+type Order int
+
+const (
+  RowMajor Order = iota
+  ColumnMajor
+)
+
+func (o Order) String() string {
+  if o == ColumnMajor {
+    return "column-major"
+  }
+  return "row-major"
+}
+
+// Point is the embedding probability of one window, which starts at Offset,
+// a share of the image's channel values in scan order.
+type Point struct {
+  Offset      float64
+  Probability float64
+}
+
+type Curve struct {
+  Order  Order
+  Points []Point
+}
+
+// Embedded is the share of windows whose probability exceeds one half, an
+// estimate of how much of the scan carries LSB data.
+func (c *Curve) Embedded() float64 {
+  if len(c.Points) == 0 {
+    return 0
+  }
+  embedded := 0
+  for _, point := range c.Points {
+    if point.Probability > 0.5 {
+      embedded++
+    }
+  }
+  return float64(embedded) / float64(len(c.Points))
+}
+
+// Leading is the share of the scan covered by the run of windows from its
+// start whose probability exceeds one half. Data written in scan order from
+// the first value, as plain LSB embedding does, shows as a leading run about
+// as long as the message.
+func (c *Curve) Leading() float64 {
+  for i, point := range c.Points {
+    if point.Probability <= 0.5 {
+      return float64(i) / float64(len(c.Points))
+    }
+  }
+  return 1
+}
+
+type Report struct {
+  Width      int
+  Height     int
+  WindowSize int
+
+  // Probability is the result of the attack over the whole image.
+  Probability float64
+  RowMajor    Curve
+  ColumnMajor Curve
+}
+
+// Likely is the curve that points more strongly at embedding: the one with
+// the longer leading run, or with more windows above one half.
+func (r *Report) Likely() *Curve {
+  row, column := r.RowMajor.Leading(), r.ColumnMajor.Leading()
+  if column > row || (column == row && r.ColumnMajor.Embedded() > r.RowMajor.Embedded()) {
+    return &r.ColumnMajor
+  }
+  return &r.RowMajor
+}
+
+func AnalyzeFile(imagePath string, windows int) (*Report, error) {
+  processor, err := imageprocessing.NewImageProcessor(imagePath)
+  if err != nil {
+    return nil, err
+  }
+  return Analyze(processor.GetImage(), windows)
+}
+
+// Analyze runs the attack over windows consecutive windows of the red,
+// green and blue values of img, scanned both ways.
+func Analyze(img image.Image, windows int) (*Report, error) {
+  bounds := img.Bounds()
+  width, height := bounds.Dx(), bounds.Dy()
+  if windows < 1 {
+    return nil, errors.New("at least one window is needed")
+  }
+  samples := width * height * 3
+  if samples/windows < 2*minExpected {
+    return nil, fmt.Errorf("image too small for %d windows", windows)
+  }
+
+  values := make([]uint8, samples)
+  for y := 0; y < height; y++ {
+    for x := 0; x < width; x++ {
+      r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+      i := (y*width + x) * 3
+      values[i], values[i+1], values[i+2] = uint8(r>>8), uint8(g>>8), uint8(b>>8)
+    }
+  }
+
+  report := &Report{
+    Width:       width,
+    Height:      height,
+    WindowSize:  samples / windows,
+    Probability: probability(histogram(values, samples, func(i int) int { return i })),
+  }
+  report.RowMajor = curve(RowMajor, values, windows, func(i int) int { return i })
+  report.ColumnMajor = curve(ColumnMajor, values, windows, func(i int) int {
+    x := i / (height * 3)
+    y := (i / 3) % height
+    return (y*width+x)*3 + i%3
+  })
+  return report, nil
+}
+
+// curve evaluates each window of the scan, where index maps a position in
+// scan order to its value.
+func curve(order Order, values []uint8, windows int, index func(int) int) Curve {
+  size := len(values) / windows
+  c := Curve{Order: order, Points: make([]Point, windows)}
+  for w := range c.Points {
+    start := w * size
+    counts := histogram(values, size, func(i int) int { return index(start + i) })
+    c.Points[w] = Point{
+      Offset:      float64(start) / float64(len(values)),
+      Probability: probability(counts),
+    }
+  }
+  return c
+}
+
+func histogram(values []uint8, n int, index func(int) int) *[256]int {
+  var counts [256]int
+  for i := 0; i < n; i++ {
+    counts[values[index(i)]]++
+  }
+  return &counts
+}
+
+// probability is the chance that pairs of values were equalized by
+// embedding: the upper tail of the chi-square distribution at the
+// statistic comparing each even value's count with its pair's mean.
+func probability(counts *[256]int) float64 {
+  statistic, categories := 0.0, 0
+  for k := 0; k < 128; k++ {
+    expected := float64(counts[2*k]+counts[2*k+1]) / 2
+    if expected < minExpected {
+      continue
+    }
+    diff := float64(counts[2*k]) - expected
+    statistic += diff * diff / expected
+    categories++
+  }
+  if categories < 2 {
+    return 0
+  }
+  return upperGamma(float64(categories-1)/2, statistic/2)
+}
+
+// upperGamma is the regularized upper incomplete gamma function Q(a, x),
+// by its series below a+1 and its continued fraction above.
+func upperGamma(a, x float64) float64 {
+  if x <= 0 {
+    return 1
+  }
+  lgamma, _ := math.Lgamma(a)
+  front := math.Exp(a*math.Log(x) - x - lgamma)
+
+  if x < a+1 {
+    sum, term := 1/a, 1/a
+    for n := 1; n < 1000; n++ {
+      term *= x / (a + float64(n))
+      sum += term
+      if term < sum*1e-15 {
+        break
+      }
+    }
+    return math.Max(0, 1-sum*front)
+  }
+
+  // Lentz's method.
+  const tiny = 1e-300
+  b := x + 1 - a
+  c := 1 / tiny
+  d := 1 / b
+  h := d
+  for n := 1; n < 1000; n++ {
+    an := -float64(n) * (float64(n) - a)
+    b += 2
+    d = an*d + b
+    if math.Abs(d) < tiny {
+      d = tiny
+    }
+    c = b + an/c
+    if math.Abs(c) < tiny {
+      c = tiny
+    }
+    d = 1 / d
+    delta := d * c
+    h *= delta
+    if math.Abs(delta-1) < 1e-15 {
+      break
+    }
+  }
+  return math.Min(1, front*h)
+}